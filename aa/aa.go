@@ -2,11 +2,9 @@
 package aa
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
-	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	urlpkg "net/url"
 	"reflect"
@@ -18,11 +16,6 @@ import (
 
 var client = &http.Client{}
 
-var (
-	ErrNeedsKey = errors.New("needs encryption key")
-	ErrNotFound = errors.New("requested item not found")
-)
-
 type GetAttOpts struct {
 	EncKey         []byte
 	LeaveEncrypted bool
@@ -55,6 +48,9 @@ type AttEntry struct {
 		Timestamp time.Time `json:"timestamp"`
 	} `json:"attestation"`
 	Version string `json:"version"`
+
+	// Raw bytes of Attestation as received, see UnmarshalCBOR
+	rawAttestation []byte
 }
 
 // Attributes for uploading.
@@ -107,41 +103,102 @@ func init() {
 }
 
 type AuthAttrInstance struct {
-	Url  string
-	Jwt  string
-	Mock bool // No network requests go through if true
+	Url string
+	Jwt string
+	// Mock stops any network requests from going through. Reads find nothing, and
+	// writes are dropped. Use the aa/aamock package for a working in-memory AA.
+	Mock bool
+
+	// Timeout limits each HTTP request, including reading the response. Zero means
+	// no timeout.
+	Timeout time.Duration
+	// Retries is how many more times idempotent requests (GETs) are tried after a
+	// network error or 5xx response, with exponential backoff.
+	Retries int
+	// Client is the HTTP client to use, or nil for a shared default client.
+	Client *http.Client
+	// Concurrency is how many requests batch functions like GetAttestationsMany
+	// make at once. Zero means DefaultConcurrency.
+	Concurrency int
+	// Cache holds attestations that were read, if not nil.
+	Cache *Cache
 }
 
 var defaultInstance *AuthAttrInstance = nil
 
+// Defaults for the [aa] config section
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 3
+)
+
 func GetAAInstanceFromConfig() *AuthAttrInstance {
 	if defaultInstance != nil {
 		return defaultInstance
 	}
+	conf := config.GetConfig()
 	defaultInstance = &AuthAttrInstance{
-		Url: config.GetConfig().AA.Url,
-		Jwt: config.GetConfig().AA.Jwt,
+		Url:     conf.AA.Url,
+		Jwt:     conf.AA.Jwt,
+		Timeout: defaultTimeout,
+		Retries: defaultRetries,
+	}
+	if conf.AA.Timeout != 0 {
+		defaultInstance.Timeout = conf.AA.Timeout
+	}
+	if conf.AA.Retries != 0 {
+		// Negative disables retries
+		defaultInstance.Retries = max(conf.AA.Retries, 0)
+	}
+	if conf.AA.CacheDir != "" {
+		defaultInstance.Cache = &Cache{Dir: conf.AA.CacheDir, TTL: conf.AA.CacheTTL}
 	}
 	return defaultInstance
 }
 
+// invalidateCache removes the attributes from the cache, if there is one.
+func (a *AuthAttrInstance) invalidateCache(cid string, attrs ...string) {
+	if a.Cache != nil {
+		a.Cache.invalidate(cid, attrs...)
+	}
+}
+
 // GetAttestationRaw returns the raw bytes for the attribute from AA.
 //
 // If an encryption key was needed (to decrypt value for sig verify) but not provided
-// a ErrNeedsKey is returned. ErrNotFound is returned if the CID-attribute pair doesn't
-// exist in the database.
+// the error matches ErrNeedsKey, or ErrBadKey if the key was wrong. It matches ErrNotFound
+// if the CID-attribute pair doesn't exist in the database. Use errors.Is to check, and
+// errors.As with *APIError for details.
 func GetAttestationRaw(cid, attr string, opts GetAttOpts) ([]byte, error) {
 	return GetAAInstanceFromConfig().GetAttestationRaw(cid, attr, opts)
 }
 
+// GetAttestationRawCtx is like GetAttestationRaw but takes a context.
+func GetAttestationRawCtx(ctx context.Context, cid, attr string, opts GetAttOpts) ([]byte, error) {
+	return GetAAInstanceFromConfig().GetAttestationRawCtx(ctx, cid, attr, opts)
+}
+
 // GetAttestationRaw returns the raw bytes for the attribute from AA.
 //
 // If an encryption key was needed (to decrypt value for sig verify) but not provided
-// a ErrNeedsKey is returned. ErrNotFound is returned if the CID-attribute pair doesn't
-// exist in the database.
+// the error matches ErrNeedsKey, or ErrBadKey if the key was wrong. It matches ErrNotFound
+// if the CID-attribute pair doesn't exist in the database. Use errors.Is to check, and
+// errors.As with *APIError for details.
 func (a *AuthAttrInstance) GetAttestationRaw(cid, attr string, opts GetAttOpts) ([]byte, error) {
+	return a.GetAttestationRawCtx(context.Background(), cid, attr, opts)
+}
+
+// GetAttestationRawCtx is like GetAttestationRaw but takes a context.
+func (a *AuthAttrInstance) GetAttestationRawCtx(ctx context.Context, cid, attr string, opts GetAttOpts) ([]byte, error) {
 	if a.Mock {
-		return nil, nil
+		return nil, ErrNotFound
+	}
+
+	useCache := a.Cache != nil && cacheable(opts)
+	if useCache {
+		if data, ok := a.Cache.get(cid, attr); ok {
+			return data, nil
+		}
 	}
 
 	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/c/%s/%s",
@@ -161,52 +218,68 @@ func (a *AuthAttrInstance) GetAttestationRaw(cid, attr string, opts GetAttOpts)
 	}
 	url.RawQuery = q.Encode()
 
-	resp, err := client.Get(url.String())
+	status, data, err := a.do(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 400 {
-		return nil, ErrNeedsKey
-	}
-	if resp.StatusCode == 404 {
-		return nil, ErrNotFound
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code in response: %d", resp.StatusCode)
-	}
-
-	return io.ReadAll(resp.Body)
+	if status != 200 {
+		e := newAPIError("GET /v1/c/{cid}/{attr}", cid, attr, status, data)
+		if status == 400 && !isOtherBadRequest(e.Message) {
+			// Decryption failed
+			if opts.EncKey == nil {
+				e.kind = ErrNeedsKey
+			} else {
+				e.kind = ErrBadKey
+			}
+		}
+		return nil, e
+	}
+	if useCache {
+		// Best effort, the cache is not required
+		_ = a.Cache.put(cid, attr, data)
+	}
+	return data, nil
 }
 
 // GetAttestation returns the attestation for the provided attribute from AA.
 //
 // If an encryption key was needed (to decrypt value for sig verify) but not provided
-// a ErrNeedsKey is returned. ErrNotFound is returned if the CID-attribute pair doesn't
-// exist in the database.
+// the error matches ErrNeedsKey, or ErrBadKey if the key was wrong. It matches ErrNotFound
+// if the CID-attribute pair doesn't exist in the database. Use errors.Is to check, and
+// errors.As with *APIError for details.
 //
 // The Format fields of `opts` is ignored.
 func GetAttestation(cid, attr string, opts GetAttOpts) (*AttEntry, error) {
 	return GetAAInstanceFromConfig().GetAttestation(cid, attr, opts)
 }
 
+// GetAttestationCtx is like GetAttestation but takes a context.
+func GetAttestationCtx(ctx context.Context, cid, attr string, opts GetAttOpts) (*AttEntry, error) {
+	return GetAAInstanceFromConfig().GetAttestationCtx(ctx, cid, attr, opts)
+}
+
 // GetAttestation returns the attestation for the provided attribute from AA.
 //
 // If an encryption key was needed (to decrypt value for sig verify) but not provided
-// a ErrNeedsKey is returned. ErrNotFound is returned if the CID-attribute pair doesn't
-// exist in the database.
+// the error matches ErrNeedsKey, or ErrBadKey if the key was wrong. It matches ErrNotFound
+// if the CID-attribute pair doesn't exist in the database. Use errors.Is to check, and
+// errors.As with *APIError for details.
 //
 // The Format fields of `opts` is ignored.
 func (a *AuthAttrInstance) GetAttestation(cid, attr string, opts GetAttOpts) (*AttEntry, error) {
+	return a.GetAttestationCtx(context.Background(), cid, attr, opts)
+}
+
+// GetAttestationCtx is like GetAttestation but takes a context.
+func (a *AuthAttrInstance) GetAttestationCtx(ctx context.Context, cid, attr string, opts GetAttOpts) (*AttEntry, error) {
 	if a.Mock {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	// Ignore format so CBOR is guaranteed
 	opts.Format = ""
 
-	data, err := a.GetAttestationRaw(cid, attr, opts)
+	data, err := a.GetAttestationRawCtx(ctx, cid, attr, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -223,39 +296,59 @@ func GetAttestations(cid string) (map[string]*AttEntry, error) {
 	return GetAAInstanceFromConfig().GetAttestations(cid)
 }
 
+// GetAttestationsCtx is like GetAttestations but takes a context.
+func GetAttestationsCtx(ctx context.Context, cid string) (map[string]*AttEntry, error) {
+	return GetAAInstanceFromConfig().GetAttestationsCtx(ctx, cid)
+}
+
 // GetAttestations returns all attestations for the provided CID from AA.
 func (a *AuthAttrInstance) GetAttestations(cid string) (map[string]*AttEntry, error) {
+	return a.GetAttestationsCtx(context.Background(), cid)
+}
+
+// GetAttestationsCtx is like GetAttestations but takes a context.
+func (a *AuthAttrInstance) GetAttestationsCtx(ctx context.Context, cid string) (map[string]*AttEntry, error) {
 	if a.Mock {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
-	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/c/%s", a.Url, urlpkg.PathEscape(cid)))
-	if err != nil {
-		return nil, err
+	if a.Cache != nil {
+		if raws, ok := a.Cache.getAll(cid); ok {
+			v := make(map[string]*AttEntry, len(raws))
+			for attr, raw := range raws {
+				var ae AttEntry
+				if err := dagCborDecMode.Unmarshal(raw, &ae); err != nil {
+					return nil, err
+				}
+				v[attr] = &ae
+			}
+			return v, nil
+		}
 	}
 
-	resp, err := client.Get(url.String())
+	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/c/%s", a.Url, urlpkg.PathEscape(cid)))
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 404 {
-		return nil, ErrNotFound
-	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code in response: %d", resp.StatusCode)
-	}
 
-	data, err := io.ReadAll(resp.Body)
+	status, data, err := a.do(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	if status != 200 {
+		return nil, newAPIError("GET /v1/c/{cid}", cid, "", status, data)
+	}
 
 	var v map[string]*AttEntry
 	if err := dagCborDecMode.Unmarshal(data, &v); err != nil {
 		return nil, err
 	}
+	if a.Cache != nil {
+		var raws map[string]cbor.RawMessage
+		if dagCborDecMode.Unmarshal(data, &raws) == nil {
+			_ = a.Cache.putAll(cid, raws)
+		}
+	}
 	return v, nil
 }
 
@@ -263,11 +356,27 @@ func SetAttestations(cid string, index bool, kvs []PostKV) error {
 	return GetAAInstanceFromConfig().SetAttestations(cid, index, kvs)
 }
 
+// SetAttestationsCtx is like SetAttestations but takes a context.
+func SetAttestationsCtx(ctx context.Context, cid string, index bool, kvs []PostKV) error {
+	return GetAAInstanceFromConfig().SetAttestationsCtx(ctx, cid, index, kvs)
+}
+
 func (a *AuthAttrInstance) SetAttestations(cid string, index bool, kvs []PostKV) error {
+	return a.SetAttestationsCtx(context.Background(), cid, index, kvs)
+}
+
+// SetAttestationsCtx is like SetAttestations but takes a context.
+func (a *AuthAttrInstance) SetAttestationsCtx(ctx context.Context, cid string, index bool, kvs []PostKV) error {
 	if a.Mock {
 		return nil
 	}
 
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	defer a.invalidateCache(cid, keys...)
+
 	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/c/%s", a.Url, urlpkg.PathEscape(cid)))
 	if err != nil {
 		return err
@@ -283,21 +392,12 @@ func (a *AuthAttrInstance) SetAttestations(cid string, index bool, kvs []PostKV)
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(b))
+	status, data, err := a.do(ctx, "POST", url.String(), b)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/cbor")
-	req.Header.Add("Authorization", "Bearer "+a.Jwt)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("bad status code in response: %d", resp.StatusCode)
+	if status != 200 {
+		return newAPIError("POST /v1/c/{cid}", cid, "", status, data)
 	}
 	return nil
 }
@@ -307,10 +407,20 @@ func GetCIDs() ([]string, error) {
 	return GetAAInstanceFromConfig().GetCIDs()
 }
 
+// GetCIDsCtx is like GetCIDs but takes a context.
+func GetCIDsCtx(ctx context.Context) ([]string, error) {
+	return GetAAInstanceFromConfig().GetCIDsCtx(ctx)
+}
+
 // GetCIDs returns a slice of all the CIDs stored in the database, as strings.
 func (a *AuthAttrInstance) GetCIDs() ([]string, error) {
+	return a.GetCIDsCtx(context.Background())
+}
+
+// GetCIDsCtx is like GetCIDs but takes a context.
+func (a *AuthAttrInstance) GetCIDsCtx(ctx context.Context) ([]string, error) {
 	if a.Mock {
-		return nil, nil
+		return []string{}, nil
 	}
 
 	url, err := urlpkg.Parse(a.Url + "/v1/cids")
@@ -318,19 +428,12 @@ func (a *AuthAttrInstance) GetCIDs() ([]string, error) {
 		return nil, err
 	}
 
-	resp, err := client.Get(url.String())
+	status, data, err := a.do(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code in response: %d", resp.StatusCode)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if status != 200 {
+		return nil, newAPIError("GET /v1/cids", "", "", status, data)
 	}
 
 	var v []string
@@ -352,13 +455,24 @@ func AppendAttestation(cid, attr string, val any) error {
 	return GetAAInstanceFromConfig().AppendAttestation(cid, attr, val)
 }
 
+// AppendAttestationCtx is like AppendAttestation but takes a context.
+func AppendAttestationCtx(ctx context.Context, cid, attr string, val any) error {
+	return GetAAInstanceFromConfig().AppendAttestationCtx(ctx, cid, attr, val)
+}
+
 // AppendAttestation appends to an array stored at attr.
 //
 // See https://github.com/starlinglab/authenticated-attributes/blob/main/docs/http.md#post-v1ccidattr
 func (a *AuthAttrInstance) AppendAttestation(cid, attr string, val any) error {
+	return a.AppendAttestationCtx(context.Background(), cid, attr, val)
+}
+
+// AppendAttestationCtx is like AppendAttestation but takes a context.
+func (a *AuthAttrInstance) AppendAttestationCtx(ctx context.Context, cid, attr string, val any) error {
 	if a.Mock {
 		return nil
 	}
+	defer a.invalidateCache(cid, attr)
 
 	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/c/%s/%s?append=1",
 		a.Url, urlpkg.PathEscape(cid), urlpkg.PathEscape(attr)))
@@ -371,21 +485,12 @@ func (a *AuthAttrInstance) AppendAttestation(cid, attr string, val any) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(b))
+	status, data, err := a.do(ctx, "POST", url.String(), b)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/cbor")
-	req.Header.Add("Authorization", "Bearer "+a.Jwt)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("bad status code in response: %d", resp.StatusCode)
+	if status != 200 {
+		return newAPIError("POST /v1/c/{cid}/{attr}", cid, attr, status, data)
 	}
 	return nil
 }
@@ -408,6 +513,11 @@ func AddRelationship(cid, relType, relationType, relCid string) error {
 	return GetAAInstanceFromConfig().AddRelationship(cid, relType, relationType, relCid)
 }
 
+// AddRelationshipCtx is like AddRelationship but takes a context.
+func AddRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
+	return GetAAInstanceFromConfig().AddRelationshipCtx(ctx, cid, relType, relationType, relCid)
+}
+
 // AddRelationship adds a relationship to the database.
 //
 // relType must be either "children" or "parents".
@@ -417,10 +527,23 @@ func AddRelationship(cid, relType, relationType, relCid string) error {
 // See AA docs for details:
 // https://github.com/starlinglab/authenticated-attributes/blob/main/docs/http.md#post-v1relcid
 func (a *AuthAttrInstance) AddRelationship(cid, relType, relationType, relCid string) error {
+	return a.AddRelationshipCtx(context.Background(), cid, relType, relationType, relCid)
+}
+
+// AddRelationshipCtx is like AddRelationship but takes a context.
+func (a *AuthAttrInstance) AddRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
 	if a.Mock {
 		return nil
 	}
 
+	// AA records the relationship on both CIDs
+	inverse := "parents"
+	if relType == "parents" {
+		inverse = "children"
+	}
+	defer a.invalidateCache(cid, relType)
+	defer a.invalidateCache(relCid, inverse)
+
 	url, err := urlpkg.Parse(fmt.Sprintf("%s/v1/rel/%s", a.Url, urlpkg.PathEscape(cid)))
 	if err != nil {
 		return err
@@ -436,21 +559,12 @@ func (a *AuthAttrInstance) AddRelationship(cid, relType, relationType, relCid st
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url.String(), bytes.NewReader(b))
+	status, data, err := a.do(ctx, "POST", url.String(), b)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/cbor")
-	req.Header.Add("Authorization", "Bearer "+a.Jwt)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("bad status code in response: %d", resp.StatusCode)
+	if status != 200 {
+		return newAPIError("POST /v1/rel/{cid}", cid, "", status, data)
 	}
 	return nil
 }
@@ -462,12 +576,22 @@ func IndexMatchQuery(attr, val, valType string) ([]string, error) {
 	return GetAAInstanceFromConfig().IndexMatchQuery(attr, val, valType)
 }
 
+// IndexMatchQueryCtx is like IndexMatchQuery but takes a context.
+func IndexMatchQueryCtx(ctx context.Context, attr, val, valType string) ([]string, error) {
+	return GetAAInstanceFromConfig().IndexMatchQueryCtx(ctx, attr, val, valType)
+}
+
 // IndexMatchQuery queries the AA index for any CIDs with the provided attribute-value pair.
 // See the API docs for more information:
 // https://github.com/starlinglab/authenticated-attributes/blob/main/docs/http.md#get-v1i
 func (a *AuthAttrInstance) IndexMatchQuery(attr, val, valType string) ([]string, error) {
+	return a.IndexMatchQueryCtx(context.Background(), attr, val, valType)
+}
+
+// IndexMatchQueryCtx is like IndexMatchQuery but takes a context.
+func (a *AuthAttrInstance) IndexMatchQueryCtx(ctx context.Context, attr, val, valType string) ([]string, error) {
 	if a.Mock {
-		return nil, nil
+		return []string{}, nil
 	}
 
 	url, err := urlpkg.Parse(a.Url + "/v1/i")
@@ -482,20 +606,12 @@ func (a *AuthAttrInstance) IndexMatchQuery(attr, val, valType string) ([]string,
 	q.Add("type", valType)
 	url.RawQuery = q.Encode()
 
-	resp, err := client.Get(url.String())
+	status, data, err := a.do(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code in response: %d", resp.StatusCode)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if status != 200 {
+		return nil, newAPIError("GET /v1/i", "", attr, status, data)
 	}
 
 	var cids []string
@@ -512,11 +628,21 @@ func IndexListQuery(attr string) ([]string, error) {
 	return GetAAInstanceFromConfig().IndexListQuery(attr)
 }
 
+// IndexListQueryCtx is like IndexListQuery but takes a context.
+func IndexListQueryCtx(ctx context.Context, attr string) ([]string, error) {
+	return GetAAInstanceFromConfig().IndexListQueryCtx(ctx, attr)
+}
+
 // IndexListQuery queries the AA index for any values that have been indexed for the
 // given attribute.
 func (a *AuthAttrInstance) IndexListQuery(attr string) ([]string, error) {
+	return a.IndexListQueryCtx(context.Background(), attr)
+}
+
+// IndexListQueryCtx is like IndexListQuery but takes a context.
+func (a *AuthAttrInstance) IndexListQueryCtx(ctx context.Context, attr string) ([]string, error) {
 	if a.Mock {
-		return nil, nil
+		return []string{}, nil
 	}
 
 	url, err := urlpkg.Parse(a.Url + "/v1/i")
@@ -529,20 +655,12 @@ func (a *AuthAttrInstance) IndexListQuery(attr string) ([]string, error) {
 	q.Add("key", attr)
 	url.RawQuery = q.Encode()
 
-	resp, err := client.Get(url.String())
+	status, data, err := a.do(ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("bad status code in response: %d", resp.StatusCode)
-	}
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if status != 200 {
+		return nil, newAPIError("GET /v1/i", "", attr, status, data)
 	}
 
 	var vals []string
@@ -0,0 +1,525 @@
+// Package aamock provides an in-memory implementation of the Authenticated Attributes
+// HTTP API, for tests and offline development. Tests can start one with the aatest
+// package.
+//
+// It speaks the same HTTP and DAG-CBOR protocol as AA, signs attestations with its own
+// ed25519 key, and supports encryption, appending, relationships and the index. VCs are
+// returned without proofs, nothing is timestamped, and data is lost when the server stops.
+package aamock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/starlinglab/integrity-v2/aa"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Version is reported in every attestation entry.
+const Version = "aatest"
+
+var (
+	dagCborDecMode cbor.DecMode
+	dagCborEncMode cbor.EncMode
+)
+
+func init() {
+	// Same as the aa package
+	cborTags := cbor.NewTagSet()
+	err := cborTags.Add(
+		cbor.TagOptions{EncTag: cbor.EncTagRequired, DecTag: cbor.DecTagRequired},
+		reflect.TypeOf(aa.CborCID{}),
+		42,
+	)
+	if err != nil {
+		panic(err)
+	}
+	dagCborDecMode, err = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any{}),
+	}.DecModeWithSharedTags(cborTags)
+	if err != nil {
+		panic(err)
+	}
+	dagCborEncMode, err = cbor.EncOptions{
+		Sort:          cbor.SortCanonical,
+		ShortestFloat: cbor.ShortestFloatNone,
+		Time:          cbor.TimeRFC3339,
+		TimeTag:       cbor.EncTagNone,
+		IndefLength:   cbor.IndefLengthForbidden,
+		TagsMd:        cbor.TagsAllowed,
+	}.EncModeWithSharedTags(cborTags)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// entry is a stored attestation.
+type entry struct {
+	// Attestation as signed, with the plaintext value
+	attestation map[string]any
+	signature   map[string]any
+	// Encrypted value, nil if the attestation isn't encrypted
+	ciphertext []byte
+}
+
+// Server is an in-memory AA server. It implements http.Handler.
+type Server struct {
+	// Jwt is required as a bearer token for all writes, if not empty
+	Jwt string
+
+	priv    ed25519.PrivateKey
+	handler http.Handler
+
+	mu      sync.Mutex
+	entries map[string]map[string]*entry // CID -> attribute -> entry
+	// attribute -> type -> value -> CIDs
+	index map[string]map[string]map[string][]string
+}
+
+// NewServer returns a server that signs attestations with priv. If priv is nil a new
+// key is generated.
+func NewServer(priv ed25519.PrivateKey) *Server {
+	if priv == nil {
+		var err error
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+	}
+	s := &Server{
+		priv:    priv,
+		entries: make(map[string]map[string]*entry),
+		index:   make(map[string]map[string]map[string][]string),
+	}
+
+	r := chi.NewRouter()
+	r.Get("/v1/cids", s.handleGetCIDs)
+	r.Get("/v1/i", s.handleIndex)
+	r.Get("/v1/c/{cid}", s.handleGetAll)
+	r.Get("/v1/c/{cid}/{attr}", s.handleGetOne)
+	r.Post("/v1/c/{cid}", s.handleSetMany)
+	r.Post("/v1/c/{cid}/{attr}", s.handleSetOne)
+	r.Post("/v1/rel/{cid}", s.handleAddRelationship)
+	s.handler = r
+	return s
+}
+
+// PublicKey returns the key attestations are signed with.
+func (s *Server) PublicKey() ed25519.PublicKey {
+	return s.priv.Public().(ed25519.PublicKey)
+}
+
+// TrustedKey returns the signing key for use with aa.VerifyAttEntry.
+func (s *Server) TrustedKey() *aa.TrustedKey {
+	return &aa.TrustedKey{PubKey: s.PublicKey(), Name: "aatest"}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && s.Jwt != "" && r.Header.Get("Authorization") != "Bearer "+s.Jwt {
+		writeError(w, http.StatusUnauthorized, "invalid or missing JWT")
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	_, _ = w.Write(b)
+}
+
+func writeCBOR(w http.ResponseWriter, v any) {
+	b, err := dagCborEncMode.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/cbor")
+	_, _ = w.Write(b)
+}
+
+// sign stores a new signed attestation. s.mu must be held.
+func (s *Server) sign(cid, attr string, value any, encKey []byte) error {
+	cborCid, err := aa.NewCborCID(cid)
+	if err != nil {
+		return fmt.Errorf("invalid CID: %w", err)
+	}
+	att := map[string]any{
+		"CID":       cborCid,
+		"value":     value,
+		"attribute": attr,
+		"encrypted": encKey != nil,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	b, err := dagCborEncMode.Marshal(att)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(b)
+	msg := append([]byte{0x01, 0x71, 0x12, 0x20}, hash[:]...)
+
+	e := &entry{
+		attestation: att,
+		signature: map[string]any{
+			"pubKey": []byte(s.PublicKey()),
+			"sig":    ed25519.Sign(s.priv, msg),
+			"msg":    aa.CborCID(append([]byte{0x00}, msg...)),
+		},
+	}
+	if encKey != nil {
+		e.ciphertext, err = encrypt(value, encKey)
+		if err != nil {
+			return err
+		}
+	}
+	if s.entries[cid] == nil {
+		s.entries[cid] = make(map[string]*entry)
+	}
+	s.entries[cid][attr] = e
+	return nil
+}
+
+// encode returns the entry as AA sends it. The value is only included in plaintext
+// if decrypt is true.
+func (e *entry) encode(decrypt bool) map[string]any {
+	att := e.attestation
+	if e.ciphertext != nil && !decrypt {
+		att = make(map[string]any, len(e.attestation))
+		for k, v := range e.attestation {
+			att[k] = v
+		}
+		att["value"] = e.ciphertext
+	}
+	return map[string]any{
+		"signature":   e.signature,
+		"attestation": att,
+		"timestamp": map[string]any{
+			"ots": map[string]any{"proof": []byte{}, "upgraded": false, "msg": ""},
+		},
+		"version": Version,
+	}
+}
+
+func encrypt(value any, key []byte) ([]byte, error) {
+	var k [32]byte
+	if len(key) != len(k) {
+		return nil, fmt.Errorf("encryption key must be 32 bytes")
+	}
+	copy(k[:], key)
+	b, err := dagCborEncMode.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return secretbox.Seal(nonce[:], b, &nonce, &k), nil
+}
+
+// checkKey reports whether key decrypts the entry's ciphertext.
+func (e *entry) checkKey(key []byte) bool {
+	var k [32]byte
+	var nonce [24]byte
+	if len(key) != len(k) || len(e.ciphertext) < len(nonce) {
+		return false
+	}
+	copy(k[:], key)
+	copy(nonce[:], e.ciphertext)
+	_, ok := secretbox.Open(nil, e.ciphertext[len(nonce):], &nonce, &k)
+	return ok
+}
+
+func (s *Server) handleGetCIDs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cids := make([]string, 0, len(s.entries))
+	for cid := range s.entries {
+		cids = append(cids, cid)
+	}
+	slices.Sort(cids)
+	writeCBOR(w, cids)
+}
+
+func (s *Server) handleGetAll(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	atts, ok := s.entries[chi.URLParam(r, "cid")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "CID not found")
+		return
+	}
+	ret := make(map[string]any, len(atts))
+	for attr, e := range atts {
+		ret[attr] = e.encode(false)
+	}
+	writeCBOR(w, ret)
+}
+
+func (s *Server) handleGetOne(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[chi.URLParam(r, "cid")][chi.URLParam(r, "attr")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "attribute not found")
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format != "" && format != "cbor" && format != "vc" {
+		writeError(w, http.StatusBadRequest, "format must be cbor or vc")
+		return
+	}
+	decrypt := false
+	if e.ciphertext != nil && q.Get("decrypt") != "0" {
+		if !q.Has("key") {
+			writeError(w, http.StatusBadRequest, "attestation is encrypted, key needed")
+			return
+		}
+		key, err := base64.URLEncoding.DecodeString(q.Get("key"))
+		if err != nil || !e.checkKey(key) {
+			writeError(w, http.StatusBadRequest, "decryption failed")
+			return
+		}
+		decrypt = true
+	}
+	if format == "vc" {
+		writeVC(w, e.encode(decrypt))
+		return
+	}
+	writeCBOR(w, e.encode(decrypt))
+}
+
+// writeVC writes the entry as a minimal Verifiable Credential. Unlike AA's, it has
+// no proof of its own.
+func writeVC(w http.ResponseWriter, ent map[string]any) {
+	att := ent["attestation"].(map[string]any)
+	vc := map[string]any{
+		"@context":     []string{"https://www.w3.org/2018/credentials/v1"},
+		"type":         []string{"VerifiableCredential"},
+		"issuer":       "urn:aatest",
+		"issuanceDate": att["timestamp"],
+		"credentialSubject": map[string]any{
+			"id":                      "ipfs://" + att["CID"].(aa.CborCID).String(),
+			att["attribute"].(string): att["value"],
+		},
+	}
+	b, err := json.Marshal(vc)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (s *Server) handleSetMany(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var kvs []aa.PostKV
+	if err := dagCborDecMode.Unmarshal(body, &kvs); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	cid := chi.URLParam(r, "cid")
+	index := r.URL.Query().Get("index") == "1"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kv := range kvs {
+		if kv.Key == "" {
+			writeError(w, http.StatusBadRequest, "missing key")
+			return
+		}
+		var encKey []byte
+		if len(kv.EncKey) > 0 {
+			encKey = kv.EncKey
+		}
+		if err := s.sign(cid, kv.Key, kv.Value, encKey); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if index && kv.Type != "" && encKey == nil {
+			s.addToIndex(cid, kv.Key, kv.Type, kv.Value)
+		}
+	}
+}
+
+func (s *Server) handleSetOne(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var set struct {
+		Value  any `cbor:"value"`
+		EncKey any `cbor:"encKey"` // false or key bytes
+	}
+	if err := dagCborDecMode.Unmarshal(body, &set); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	encKey, _ := set.EncKey.([]byte)
+	cid := chi.URLParam(r, "cid")
+	attr := chi.URLParam(r, "attr")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value := set.Value
+	if r.URL.Query().Get("append") == "1" {
+		if encKey != nil {
+			writeError(w, http.StatusBadRequest, "can't append encrypted values")
+			return
+		}
+		var arr []any
+		if e, ok := s.entries[cid][attr]; ok {
+			if e.ciphertext != nil {
+				writeError(w, http.StatusBadRequest, "can't append to an encrypted attribute")
+				return
+			}
+			arr, ok = e.attestation["value"].([]any)
+			if !ok {
+				writeError(w, http.StatusConflict, "existing value is not an array")
+				return
+			}
+		}
+		value = append(slices.Clone(arr), value)
+	}
+	if err := s.sign(cid, attr, value, encKey); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+func (s *Server) handleAddRelationship(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var rel struct {
+		Type         string     `cbor:"type"`
+		RelationType string     `cbor:"relation_type"`
+		Cid          aa.CborCID `cbor:"cid"`
+	}
+	if err := dagCborDecMode.Unmarshal(body, &rel); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	var inverse string
+	switch rel.Type {
+	case "children":
+		inverse = "parents"
+	case "parents":
+		inverse = "children"
+	default:
+		writeError(w, http.StatusBadRequest, "type must be children or parents")
+		return
+	}
+	if rel.RelationType == "" || len(rel.Cid) < 2 {
+		writeError(w, http.StatusBadRequest, "missing relation_type or cid")
+		return
+	}
+	cid := chi.URLParam(r, "cid")
+	cborCid, err := aa.NewCborCID(cid)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid CID: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Relationships are stored in both directions
+	if err := s.addRelationship(cid, rel.Type, rel.RelationType, rel.Cid); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.addRelationship(rel.Cid.String(), inverse, rel.RelationType, cborCid); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// addRelationship adds relCid to the relationship attribute of cid, which maps relation
+// types to arrays of CIDs. s.mu must be held.
+func (s *Server) addRelationship(cid, relType, relationType string, relCid aa.CborCID) error {
+	rels := make(map[string]any)
+	if e, ok := s.entries[cid][relType]; ok {
+		if m, ok := e.attestation["value"].(map[string]any); ok {
+			for k, v := range m {
+				rels[k] = v
+			}
+		}
+	}
+	list, _ := rels[relationType].([]any)
+	for _, v := range list {
+		if c, ok := v.(aa.CborCID); ok && slices.Equal(c, relCid) {
+			// Already exists
+			return nil
+		}
+	}
+	rels[relationType] = append(slices.Clone(list), relCid)
+	return s.sign(cid, relType, rels, nil)
+}
+
+// addToIndex records that cid has the value for attr. s.mu must be held.
+func (s *Server) addToIndex(cid, attr, typ string, value any) {
+	if s.index[attr] == nil {
+		s.index[attr] = make(map[string]map[string][]string)
+	}
+	if s.index[attr][typ] == nil {
+		s.index[attr][typ] = make(map[string][]string)
+	}
+	val := fmt.Sprint(value)
+	if !slices.Contains(s.index[attr][typ][val], cid) {
+		s.index[attr][typ][val] = append(s.index[attr][typ][val], cid)
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch q.Get("query") {
+	case "match":
+		cids := s.index[q.Get("key")][q.Get("type")][q.Get("val")]
+		if cids == nil {
+			cids = []string{}
+		}
+		writeCBOR(w, cids)
+	case "list":
+		vals := []string{}
+		for _, byVal := range s.index[q.Get("key")] {
+			for val := range byVal {
+				if !slices.Contains(vals, val) {
+					vals = append(vals, val)
+				}
+			}
+		}
+		slices.Sort(vals)
+		writeCBOR(w, vals)
+	default:
+		writeError(w, http.StatusBadRequest, "query must be match or list")
+	}
+}
+
+// PublicKeyHex returns the signing public key in the format used by trusted keys files.
+func (s *Server) PublicKeyHex() string {
+	return hex.EncodeToString(s.PublicKey())
+}
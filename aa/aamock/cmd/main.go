@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"github.com/starlinglab/integrity-v2/aa/aamock"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+func main() {
+	util.Runner(os.Args[1:], aamock.Run)
+}
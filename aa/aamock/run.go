@@ -0,0 +1,68 @@
+package aamock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Run runs the in-memory server as a standalone service, for local development.
+func Run(args []string) error {
+	fs := flag.NewFlagSet("aa-mock", flag.ContinueOnError)
+	host := fs.String("host", "localhost:3001", "address to listen on")
+	jwt := fs.String("jwt", "", "JWT required for writes, none if empty")
+	keyPath := fs.String("key", "", "file holding a hex ed25519 seed to sign with, created if it doesn't exist. A new key is used each run otherwise")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("aa-mock takes no arguments")
+	}
+
+	var priv ed25519.PrivateKey
+	if *keyPath != "" {
+		priv, err = loadOrCreateKey(*keyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	s := NewServer(priv)
+	s.Jwt = *jwt
+	log.Println("aa-mock: data is kept in memory only and lost on exit")
+	log.Printf("aa-mock: signing key, for the trusted_keys file: %s aa-mock\n", s.PublicKeyHex())
+	log.Println("aa-mock: listening on", *host)
+	return http.ListenAndServe(*host, s)
+}
+
+func loadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, fmt.Errorf("error generating key: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("error saving key: %w", err)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading key: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key file must hold a %d byte hex seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
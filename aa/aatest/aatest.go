@@ -0,0 +1,24 @@
+// Package aatest runs in-memory AA servers from the aamock package in tests.
+package aatest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aamock"
+)
+
+// Server is the in-memory server started by Start.
+type Server = aamock.Server
+
+// Start runs a new server for the duration of the test, and returns it along with
+// an instance that uses it.
+func Start(tb testing.TB) (*Server, *aa.AuthAttrInstance) {
+	tb.Helper()
+	s := aamock.NewServer(nil)
+	s.Jwt = "aatest"
+	srv := httptest.NewServer(s)
+	tb.Cleanup(srv.Close)
+	return s, &aa.AuthAttrInstance{Url: srv.URL, Jwt: s.Jwt, Client: srv.Client()}
+}
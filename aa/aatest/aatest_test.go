@@ -0,0 +1,170 @@
+package aatest
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+)
+
+func TestSetAndGet(t *testing.T) {
+	s, a := Start(t)
+	key := make([]byte, 32)
+	key[0] = 1
+
+	err := a.SetAttestations(cid1, true, []aa.PostKV{
+		{Key: "name", Value: "hello", Type: "str"},
+		{Key: "secret", Value: "shh", EncKey: key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ae, err := a.GetAttestation(cid1, "name", aa.GetAttOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ae.Attestation.Value != "hello" || ae.Attestation.CID.String() != cid1 {
+		t.Errorf("unexpected attestation: %+v", ae.Attestation)
+	}
+	if _, err := aa.VerifyAttEntry(ae, []*aa.TrustedKey{s.TrustedKey()}); err != nil {
+		t.Errorf("signature should verify: %v", err)
+	}
+
+	// Encryption
+	if _, err := a.GetAttestation(cid1, "secret", aa.GetAttOpts{}); !errors.Is(err, aa.ErrNeedsKey) {
+		t.Errorf("got %v, want ErrNeedsKey", err)
+	}
+	if _, err := a.GetAttestation(cid1, "secret", aa.GetAttOpts{EncKey: make([]byte, 32)}); !errors.Is(err, aa.ErrBadKey) {
+		t.Errorf("got %v, want ErrBadKey", err)
+	}
+	ae, err = a.GetAttestation(cid1, "secret", aa.GetAttOpts{EncKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ae.Attestation.Value != "shh" || !ae.Attestation.Encrypted {
+		t.Errorf("unexpected decrypted attestation: %+v", ae.Attestation)
+	}
+	if _, err := aa.VerifyAttEntry(ae, []*aa.TrustedKey{s.TrustedKey()}); err != nil {
+		t.Errorf("decrypted signature should verify: %v", err)
+	}
+	atts, err := a.GetAttestations(cid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := atts["secret"].Attestation.Value.([]byte); !ok || len(atts) != 2 {
+		t.Errorf("encrypted value should be bytes in full listing, got %v", atts["secret"].Attestation.Value)
+	}
+
+	// Index
+	cids, err := a.IndexMatchQuery("name", "hello", "str")
+	if err != nil || !slices.Equal(cids, []string{cid1}) {
+		t.Errorf("got (%v, %v) from index", cids, err)
+	}
+	vals, err := a.IndexListQuery("name")
+	if err != nil || !slices.Equal(vals, []string{"hello"}) {
+		t.Errorf("got (%v, %v) from index list", vals, err)
+	}
+
+	if _, err := a.GetAttestation(cid2, "name", aa.GetAttOpts{}); !errors.Is(err, aa.ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestAppendAndRelationships(t *testing.T) {
+	_, a := Start(t)
+	for _, v := range []string{"a", "b"} {
+		if err := a.AppendAttestation(cid1, "list", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ae, err := a.GetAttestation(cid1, "list", aa.GetAttOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := ae.Attestation.Value.([]any); !ok || len(v) != 2 || v[1] != "b" {
+		t.Errorf("unexpected appended value: %v", ae.Attestation.Value)
+	}
+
+	if err := a.AddRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	// Adding twice doesn't duplicate
+	if err := a.AddRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	ae, err = a.GetAttestation(cid2, "parents", aa.GetAttOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived := ae.Attestation.Value.(map[string]any)["derived"].([]any)
+	if len(derived) != 1 || derived[0].(aa.CborCID).String() != cid1 {
+		t.Errorf("unexpected inverse relationship: %v", ae.Attestation.Value)
+	}
+
+	cids, err := a.GetCIDs()
+	if err != nil || !slices.Equal(cids, []string{cid2, cid1}) {
+		t.Errorf("got (%v, %v) for CIDs", cids, err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	_, a := Start(t)
+	a.Jwt = "wrong"
+	err := a.SetAttestations(cid1, false, []aa.PostKV{{Key: "name", Value: "x"}})
+	if !errors.Is(err, aa.ErrUnauthorized) {
+		t.Errorf("got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestEffectiveRelationships(t *testing.T) {
+	_, a := Start(t)
+	if err := a.AddRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	rels, err := a.GetEffectiveRelationships(cid2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rels.Has("parents", "derived", cid1) {
+		t.Errorf("relationship missing: %+v", rels)
+	}
+
+	if err := a.RemoveRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	for _, cid := range []string{cid1, cid2} {
+		rels, err := a.GetEffectiveRelationships(cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rels.Children) != 0 || len(rels.Parents) != 0 {
+			t.Errorf("relationship should be removed from %s: %+v", cid, rels)
+		}
+	}
+	// Still there in AA
+	rels, err = a.GetRelationships(cid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rels.Has("children", "derived", cid2) {
+		t.Errorf("raw relationship missing: %+v", rels)
+	}
+
+	if err := a.RestoreRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	rels, err = a.GetEffectiveRelationships(cid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rels.Children["derived"], []string{cid2}) {
+		t.Errorf("relationship should be restored: %+v", rels)
+	}
+}
@@ -0,0 +1,131 @@
+package aa
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultConcurrency is how many requests batch functions make at once, if the
+// instance doesn't set Concurrency.
+const DefaultConcurrency = 8
+
+// BatchResult is the result of getting attestations for one CID in a batch.
+type BatchResult struct {
+	CID          string
+	Attestations map[string]*AttEntry
+	Err          error // Matches ErrNotFound if the CID isn't in AA
+}
+
+// GetAttestationsMany returns the attestations for each of the CIDs, keyed by CID and
+// then attribute. If attrs is not empty, only those attributes are included. CIDs that
+// don't exist in AA are left out, any other error stops the batch and is returned.
+//
+// Encrypted attestations are not decrypted.
+func GetAttestationsMany(cids, attrs []string) (map[string]map[string]*AttEntry, error) {
+	return GetAAInstanceFromConfig().GetAttestationsMany(cids, attrs)
+}
+
+// GetAttestationsManyCtx is like GetAttestationsMany but takes a context.
+func GetAttestationsManyCtx(ctx context.Context, cids, attrs []string) (map[string]map[string]*AttEntry, error) {
+	return GetAAInstanceFromConfig().GetAttestationsManyCtx(ctx, cids, attrs)
+}
+
+// GetAttestationsMany returns the attestations for each of the CIDs, keyed by CID and
+// then attribute. If attrs is not empty, only those attributes are included. CIDs that
+// don't exist in AA are left out, any other error stops the batch and is returned.
+//
+// Encrypted attestations are not decrypted.
+func (a *AuthAttrInstance) GetAttestationsMany(cids, attrs []string) (map[string]map[string]*AttEntry, error) {
+	return a.GetAttestationsManyCtx(context.Background(), cids, attrs)
+}
+
+// GetAttestationsManyCtx is like GetAttestationsMany but takes a context.
+func (a *AuthAttrInstance) GetAttestationsManyCtx(ctx context.Context, cids, attrs []string) (map[string]map[string]*AttEntry, error) {
+	ret := make(map[string]map[string]*AttEntry, len(cids))
+	err := a.StreamAttestationsMany(ctx, cids, attrs, func(r *BatchResult) error {
+		if errors.Is(r.Err, ErrNotFound) {
+			return nil
+		}
+		if r.Err != nil {
+			return r.Err
+		}
+		ret[r.CID] = r.Attestations
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// StreamAttestationsMany gets the attestations for each of the CIDs concurrently, and
+// calls fn with each result in the same order as cids. Errors for a single CID are
+// passed to fn in the result; if fn returns an error the batch stops and it is returned.
+// Only a limited number of results are held in memory at once, so it's suitable for
+// very long lists of CIDs.
+//
+// If attrs is not empty, only those attributes are included. Encrypted attestations
+// are not decrypted.
+func StreamAttestationsMany(ctx context.Context, cids, attrs []string, fn func(*BatchResult) error) error {
+	return GetAAInstanceFromConfig().StreamAttestationsMany(ctx, cids, attrs, fn)
+}
+
+// StreamAttestationsMany gets the attestations for each of the CIDs concurrently, and
+// calls fn with each result in the same order as cids. Errors for a single CID are
+// passed to fn in the result; if fn returns an error the batch stops and it is returned.
+// Only a limited number of results are held in memory at once, so it's suitable for
+// very long lists of CIDs.
+//
+// If attrs is not empty, only those attributes are included. Encrypted attestations
+// are not decrypted.
+func (a *AuthAttrInstance) StreamAttestationsMany(ctx context.Context, cids, attrs []string, fn func(*BatchResult) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n := a.Concurrency
+	if n <= 0 {
+		n = DefaultConcurrency
+	}
+
+	// Each CID gets its own result channel, queued in order. The queue size limits
+	// how many requests are in flight or waiting to be consumed.
+	queue := make(chan chan *BatchResult, n)
+	go func() {
+		defer close(queue)
+		for _, cid := range cids {
+			ch := make(chan *BatchResult, 1)
+			select {
+			case queue <- ch:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				ch <- a.getBatchResult(ctx, cid, attrs)
+			}()
+		}
+	}()
+
+	for ch := range queue {
+		if err := fn(<-ch); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+func (a *AuthAttrInstance) getBatchResult(ctx context.Context, cid string, attrs []string) *BatchResult {
+	atts, err := a.GetAttestationsCtx(ctx, cid)
+	if err != nil {
+		return &BatchResult{CID: cid, Err: err}
+	}
+	if len(attrs) > 0 {
+		filtered := make(map[string]*AttEntry, len(attrs))
+		for _, attr := range attrs {
+			if ae, ok := atts[attr]; ok {
+				filtered[attr] = ae
+			}
+		}
+		atts = filtered
+	}
+	return &BatchResult{CID: cid, Attestations: atts}
+}
@@ -0,0 +1,101 @@
+package aa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStreamAttestationsMany(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		cid := strings.TrimPrefix(r.URL.Path, "/v1/c/")
+		if strings.HasPrefix(cid, "missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atts := map[string]any{
+			"name":        map[string]any{"attestation": map[string]any{"value": cid}},
+			"description": map[string]any{"attestation": map[string]any{"value": "test"}},
+		}
+		b, err := dagCborEncMode.Marshal(atts)
+		if err != nil {
+			t.Error(err)
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	var cids []string
+	for i := range 50 {
+		if i%10 == 3 {
+			cids = append(cids, fmt.Sprintf("missing%d", i))
+		} else {
+			cids = append(cids, fmt.Sprintf("cid%d", i))
+		}
+	}
+
+	a := &AuthAttrInstance{Url: srv.URL, Concurrency: 4}
+	var got []string
+	err := a.StreamAttestationsMany(context.Background(), cids, []string{"name"}, func(r *BatchResult) error {
+		got = append(got, r.CID)
+		if strings.HasPrefix(r.CID, "missing") {
+			if !errors.Is(r.Err, ErrNotFound) {
+				t.Errorf("%s: got %v, want ErrNotFound", r.CID, r.Err)
+			}
+			return nil
+		}
+		if r.Err != nil {
+			t.Errorf("%s: unexpected error: %v", r.CID, r.Err)
+			return nil
+		}
+		if len(r.Attestations) != 1 || r.Attestations["name"].Attestation.Value != r.CID {
+			t.Errorf("%s: unexpected attestations %v", r.CID, r.Attestations)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(got, ",") != strings.Join(cids, ",") {
+		t.Errorf("results out of order: %v", got)
+	}
+	// Queue of 4 plus one being consumed plus one being queued
+	if m := maxInFlight.Load(); m > 6 {
+		t.Errorf("got %d requests at once, want at most 6", m)
+	}
+
+	// Map version skips missing CIDs
+	m, err := a.GetAttestationsMany(cids, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m) != 45 || len(m["cid0"]) != 2 {
+		t.Errorf("got %d CIDs, want 45 with 2 attributes each", len(m))
+	}
+
+	// Stops on callback error
+	stop := errors.New("stop")
+	var calls int
+	err = a.StreamAttestationsMany(context.Background(), cids, nil, func(r *BatchResult) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got (%v, %d calls), want stop after 1 call", err, calls)
+	}
+}
@@ -0,0 +1,217 @@
+package aa
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// DefaultCacheTTL is used if a Cache has no TTL set.
+const DefaultCacheTTL = 10 * time.Minute
+
+// Cache is an on-disk read-through cache of attestations.
+//
+// Attestations are stored under the hash of their signature, so a changed attestation
+// is never confused with an old one. Lookups by CID and attribute go through small
+// reference files, which expire after the TTL. Writes made through the same
+// AuthAttrInstance invalidate the affected references right away, but writes from
+// elsewhere are only seen once the TTL passes.
+//
+// Only attestations retrieved without an encryption key are cached. The directory
+// can be deleted at any time to clear the cache.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// allAttrs is the attribute name used for references to all of a CID's attestations.
+// It can't clash with a real attribute, as those can't be empty.
+const allAttrs = ""
+
+func (c *Cache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultCacheTTL
+	}
+	return c.TTL
+}
+
+func (c *Cache) refPath(cid, attr string) string {
+	h := sha256.Sum256([]byte(cid + "\x00" + attr))
+	return filepath.Join(c.Dir, "refs", hex.EncodeToString(h[:]))
+}
+
+func (c *Cache) blobPath(sigHash string) string {
+	return filepath.Join(c.Dir, "attestations", sigHash[:2], sigHash)
+}
+
+// writeFile atomically writes a cache file, so readers never see partial data.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op once renamed
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readRef returns the contents of a reference file, if it exists and hasn't expired.
+func (c *Cache) readRef(cid, attr string) ([]byte, bool) {
+	path := c.refPath(cid, attr)
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) > c.ttl() {
+		return nil, false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// putBlob stores a single raw attestation entry and returns its signature hash.
+func (c *Cache) putBlob(raw []byte) (string, error) {
+	var v struct {
+		Signature struct {
+			Sig []byte `cbor:"sig"`
+		} `cbor:"signature"`
+	}
+	if err := dagCborDecMode.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	if len(v.Signature.Sig) == 0 {
+		return "", errors.New("attestation has no signature")
+	}
+	h := sha256.Sum256(v.Signature.Sig)
+	sigHash := hex.EncodeToString(h[:])
+	path := c.blobPath(sigHash)
+	if _, err := os.Stat(path); err == nil {
+		// Already stored, and the contents can't have changed
+		return sigHash, nil
+	}
+	return sigHash, writeFile(path, raw)
+}
+
+func (c *Cache) getBlob(sigHash string) ([]byte, bool) {
+	if len(sigHash) != sha256.Size*2 {
+		return nil, false
+	}
+	b, err := os.ReadFile(c.blobPath(sigHash))
+	return b, err == nil
+}
+
+// get returns the raw attestation entry for the CID and attribute.
+func (c *Cache) get(cid, attr string) ([]byte, bool) {
+	ref, ok := c.readRef(cid, attr)
+	if !ok {
+		return nil, false
+	}
+	return c.getBlob(string(ref))
+}
+
+// put stores the raw attestation entry for the CID and attribute.
+func (c *Cache) put(cid, attr string, raw []byte) error {
+	sigHash, err := c.putBlob(raw)
+	if err != nil {
+		return err
+	}
+	return writeFile(c.refPath(cid, attr), []byte(sigHash))
+}
+
+// getAll returns all the raw attestation entries for the CID, by attribute.
+func (c *Cache) getAll(cid string) (map[string]cbor.RawMessage, bool) {
+	ref, ok := c.readRef(cid, allAttrs)
+	if !ok {
+		return nil, false
+	}
+	ret := make(map[string]cbor.RawMessage)
+	scanner := bufio.NewScanner(bytes.NewReader(ref))
+	for scanner.Scan() {
+		sigHash, attr, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			return nil, false
+		}
+		raw, ok := c.getBlob(sigHash)
+		if !ok {
+			return nil, false
+		}
+		ret[attr] = raw
+	}
+	return ret, true
+}
+
+// putAll stores all the raw attestation entries for the CID. Unencrypted ones are
+// also made available to get.
+func (c *Cache) putAll(cid string, raws map[string]cbor.RawMessage) error {
+	var ref bytes.Buffer
+	for attr, raw := range raws {
+		if strings.Contains(attr, "\n") {
+			return fmt.Errorf("invalid attribute name %q", attr)
+		}
+		sigHash, err := c.putBlob(raw)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&ref, "%s %s\n", sigHash, attr)
+
+		var v struct {
+			Attestation struct {
+				Encrypted bool `cbor:"encrypted"`
+			} `cbor:"attestation"`
+		}
+		if err := dagCborDecMode.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		if !v.Attestation.Encrypted {
+			// Retrieving encrypted ones individually requires a key
+			if err := writeFile(c.refPath(cid, attr), []byte(sigHash)); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFile(c.refPath(cid, allAttrs), ref.Bytes())
+}
+
+// invalidate removes the references for the attributes of the CID, as well as the
+// reference to all of them.
+func (c *Cache) invalidate(cid string, attrs ...string) {
+	for _, attr := range append(attrs, allAttrs) {
+		err := os.Remove(c.refPath(cid, attr))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// The stale entry could be served, so make sure it expires instead
+			old := time.Now().Add(-2 * c.ttl())
+			_ = os.Chtimes(c.refPath(cid, attr), old, old)
+		}
+	}
+}
+
+// cacheable reports whether a single attestation request can use the cache.
+// Requests that involve encryption or other formats always go to AA.
+func cacheable(opts GetAttOpts) bool {
+	return opts.EncKey == nil && !opts.LeaveEncrypted && opts.Format == ""
+}
+
+// DisableCache turns off the cache for the instance from the config, for the rest
+// of the program. It's used for --no-cache flags.
+func DisableCache() {
+	GetAAInstanceFromConfig().Cache = nil
+}
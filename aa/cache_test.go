@@ -0,0 +1,93 @@
+package aa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	const cid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	var gets atomic.Int32
+	value := "first"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			return
+		}
+		gets.Add(1)
+		entry := func(v string) map[string]any {
+			return map[string]any{
+				"signature":   map[string]any{"sig": []byte("sig of " + v)},
+				"attestation": map[string]any{"value": v},
+			}
+		}
+		var resp any
+		if r.URL.Path == "/v1/c/"+cid {
+			resp = map[string]any{"name": entry(value), "other": entry("other")}
+		} else {
+			resp = entry(value)
+		}
+		b, _ := dagCborEncMode.Marshal(resp)
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	cache := &Cache{Dir: t.TempDir(), TTL: time.Hour}
+	a := &AuthAttrInstance{Url: srv.URL, Cache: cache}
+	get := func(opts GetAttOpts) string {
+		t.Helper()
+		ae, err := a.GetAttestation(cid, "name", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ae.Attestation.Value.(string)
+	}
+
+	get(GetAttOpts{})
+	if v := get(GetAttOpts{}); v != "first" || gets.Load() != 1 {
+		t.Errorf("got %s after %d requests, want first after 1", v, gets.Load())
+	}
+
+	// Requests with keys always go to AA
+	get(GetAttOpts{EncKey: make([]byte, 32)})
+	if gets.Load() != 2 {
+		t.Errorf("request with key should not use the cache")
+	}
+
+	// Writing invalidates
+	value = "second"
+	if err := a.SetAttestations(cid, false, []PostKV{{Key: "name", Value: "second"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := get(GetAttOpts{}); v != "second" || gets.Load() != 3 {
+		t.Errorf("got %s after %d requests, want second after 3", v, gets.Load())
+	}
+
+	// Listing all is cached too, and fills in single attributes
+	value = "third"
+	for range 2 {
+		atts, err := a.GetAttestations(cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(atts) != 2 || atts["name"].Attestation.Value != "third" {
+			t.Errorf("unexpected attestations: %v", atts)
+		}
+	}
+	if v := get(GetAttOpts{}); v != "third" || gets.Load() != 4 {
+		t.Errorf("got %s after %d requests, want third after 4", v, gets.Load())
+	}
+
+	// Expired after TTL
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cache.refPath(cid, "name"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	get(GetAttOpts{})
+	if gets.Load() != 5 {
+		t.Errorf("expired entry should not be used")
+	}
+}
@@ -0,0 +1,95 @@
+package aa
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Backoff between retries, doubling each time up to the max
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+func (a *AuthAttrInstance) httpClient() *http.Client {
+	if a.Client != nil {
+		return a.Client
+	}
+	return client
+}
+
+// do sends a request to AA and returns the response status code and body.
+// A non-nil body is sent as CBOR along with the JWT.
+//
+// GET requests are idempotent, so they are retried on network errors and 5xx
+// responses, up to a.Retries times. Other requests are only tried once, since
+// retrying them could create duplicate attestations.
+func (a *AuthAttrInstance) do(ctx context.Context, method, url string, body []byte) (int, []byte, error) {
+	attempts := 1
+	if method == http.MethodGet {
+		attempts += a.Retries
+	}
+
+	var (
+		status int
+		data   []byte
+		err    error
+	)
+	for i := range attempts {
+		if i > 0 {
+			delay := min(retryBaseDelay<<(i-1), retryMaxDelay)
+			select {
+			case <-ctx.Done():
+				return 0, nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		status, data, err = a.doOnce(ctx, method, url, body)
+		if ctx.Err() != nil {
+			// Cancelled by the caller, not worth retrying
+			return 0, nil, ctx.Err()
+		}
+		if err == nil && status < 500 {
+			break
+		}
+	}
+	return status, data, err
+}
+
+// doOnce makes a single attempt at a request, limited by a.Timeout.
+func (a *AuthAttrInstance) doOnce(ctx context.Context, method, url string, body []byte) (int, []byte, error) {
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/cbor")
+		req.Header.Add("Authorization", "Bearer "+a.Jwt)
+	}
+
+	resp, err := a.httpClient().Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read the body before the timeout context is cancelled
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
@@ -0,0 +1,92 @@
+package aa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	// Don't slow down tests
+	retryBaseDelay = time.Millisecond
+}
+
+func TestRetryGet(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := dagCborEncMode.Marshal([]string{"bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"})
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	a := &AuthAttrInstance{Url: srv.URL, Retries: 3}
+	cids, err := a.GetCIDs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cids) != 1 || calls.Load() != 3 {
+		t.Errorf("got %v after %d calls, want 1 CID after 3 calls", cids, calls.Load())
+	}
+
+	// Gives up after running out of retries
+	a.Retries = 1
+	calls.Store(-10)
+	if _, err := a.GetCIDs(); err == nil {
+		t.Error("expected error after retries run out")
+	}
+	if n := calls.Load() + 10; n != 2 {
+		t.Errorf("got %d calls, want 2", n)
+	}
+}
+
+func TestNoRetryPost(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	a := &AuthAttrInstance{Url: srv.URL, Retries: 3}
+	if err := a.SetAttestations("bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4", false, nil); err == nil {
+		t.Error("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("POST was sent %d times, want 1", calls.Load())
+	}
+}
+
+func TestTimeoutAndCancel(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	a := &AuthAttrInstance{Url: srv.URL, Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	if _, err := a.GetCIDs(); err == nil {
+		t.Error("expected timeout error")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("request took too long to time out")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a = &AuthAttrInstance{Url: srv.URL, Retries: 5}
+	if _, err := a.GetCIDsCtx(ctx); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
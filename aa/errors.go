@@ -0,0 +1,124 @@
+package aa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors returned by AA requests can be checked against these with errors.Is.
+var (
+	ErrNeedsKey     = errors.New("needs encryption key")
+	ErrBadKey       = errors.New("wrong encryption key")
+	ErrNotFound     = errors.New("requested item not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrConflict     = errors.New("conflict")
+)
+
+// maxMessageLength limits how much of an error response is kept
+const maxMessageLength = 1024
+
+// APIError is returned for any non-200 response from AA.
+type APIError struct {
+	StatusCode int
+	Endpoint   string // Method and path template, like "GET /v1/c/{cid}/{attr}"
+	CID        string // Empty if the endpoint isn't for a CID
+	Attr       string // Empty if the endpoint isn't for an attribute
+	Message    string // Error message from AA, if any
+
+	// kind is the sentinel error this matches, if any
+	kind error
+}
+
+func newAPIError(endpoint, cid, attr string, status int, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: status,
+		Endpoint:   endpoint,
+		CID:        cid,
+		Attr:       attr,
+		Message:    decodeMessage(body),
+	}
+	switch status {
+	case 401:
+		e.kind = ErrUnauthorized
+	case 403:
+		e.kind = ErrForbidden
+	case 404:
+		e.kind = ErrNotFound
+	case 409:
+		e.kind = ErrConflict
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "AA returned status %d for %s", e.StatusCode, e.Endpoint)
+	if e.CID != "" {
+		fmt.Fprintf(&sb, " (CID %s", e.CID)
+		if e.Attr != "" {
+			fmt.Fprintf(&sb, ", attribute %s", e.Attr)
+		}
+		sb.WriteString(")")
+	}
+	if e.Message != "" {
+		sb.WriteString(": " + e.Message)
+	}
+	switch e.kind {
+	case ErrUnauthorized, ErrForbidden:
+		sb.WriteString(" (check the jwt in the [aa] config section)")
+	case ErrNeedsKey:
+		sb.WriteString(" (attribute is encrypted, an encryption key is needed)")
+	case ErrBadKey:
+		sb.WriteString(" (the encryption key provided may be wrong)")
+	}
+	return sb.String()
+}
+
+// Is makes errors.Is match the sentinel error for the response status.
+func (e *APIError) Is(target error) bool {
+	return e.kind != nil && target == e.kind
+}
+
+// isOtherBadRequest returns true if AA's error message for a 400 response clearly
+// names a cause other than decryption. Getting an attestation returns 400 both when
+// a key is missing or wrong ("attestation is encrypted, key needed", "decryption
+// failed") and when the format parameter is invalid ("format must be cbor or vc").
+// Anything not recognized is treated as a key problem, as it always has been.
+func isOtherBadRequest(msg string) bool {
+	return strings.Contains(strings.ToLower(msg), "format")
+}
+
+// decodeMessage extracts AA's error message from a response body. AA sends
+// JSON or plain text errors depending on the endpoint.
+func decodeMessage(body []byte) string {
+	var v struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &v) == nil {
+		if v.Error != "" {
+			return v.Error
+		}
+		if v.Message != "" {
+			return v.Message
+		}
+	}
+	var m map[string]any
+	if dagCborDecMode.Unmarshal(body, &m) == nil {
+		if s, ok := m["error"].(string); ok {
+			return s
+		}
+	}
+	if !utf8.Valid(body) {
+		return ""
+	}
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxMessageLength {
+		s = s[:maxMessageLength] + "..."
+	}
+	return s
+}
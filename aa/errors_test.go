@@ -0,0 +1,83 @@
+package aa
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	const cid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	status := 0
+	message := "server says no"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"error": "` + message + `"}`))
+	}))
+	defer srv.Close()
+	a := &AuthAttrInstance{Url: srv.URL}
+
+	tests := []struct {
+		status  int
+		message string
+		key     []byte
+		want    error
+	}{
+		{400, "attestation is encrypted, key needed", nil, ErrNeedsKey},
+		{400, "decryption failed", []byte("wrong"), ErrBadKey},
+		{400, "server says no", nil, ErrNeedsKey},
+		{400, "server says no", []byte("wrong"), ErrBadKey},
+		{401, "server says no", nil, ErrUnauthorized},
+		{403, "server says no", nil, ErrForbidden},
+		{404, "server says no", nil, ErrNotFound},
+		{409, "server says no", nil, ErrConflict},
+	}
+	for _, tt := range tests {
+		status, message = tt.status, tt.message
+		_, err := a.GetAttestation(cid, "description", GetAttOpts{EncKey: tt.key})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d: got %v, want %v", tt.status, err, tt.want)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			t.Fatalf("status %d: error is not an APIError", tt.status)
+		}
+		if apiErr.StatusCode != tt.status || apiErr.CID != cid || apiErr.Attr != "description" ||
+			apiErr.Message != tt.message || apiErr.Endpoint != "GET /v1/c/{cid}/{attr}" {
+			t.Errorf("status %d: unexpected fields %+v", tt.status, apiErr)
+		}
+	}
+
+	// Other bad requests aren't key errors
+	status, message = 400, "format must be cbor or vc"
+	_, err := a.GetAttestation(cid, "description", GetAttOpts{EncKey: []byte("key")})
+	if errors.Is(err, ErrNeedsKey) || errors.Is(err, ErrBadKey) {
+		t.Errorf("got %v, should not be an encryption error", err)
+	}
+
+	// Other endpoints don't treat 400 as an encryption problem
+	status, message = 400, "server says no"
+	err = a.SetAttestations(cid, false, nil)
+	if errors.Is(err, ErrNeedsKey) || errors.Is(err, ErrBadKey) {
+		t.Errorf("got %v, should not be an encryption error", err)
+	}
+	if !strings.Contains(err.Error(), "server says no") {
+		t.Errorf("error should contain the server message: %v", err)
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := map[string]string{
+		`{"message": "bad"}`: "bad",
+		"plain text\n":       "plain text",
+		"\xff\xfe":           "",
+	}
+	for body, want := range tests {
+		if got := decodeMessage([]byte(body)); got != want {
+			t.Errorf("%q: got %q, want %q", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package aa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Relationships holds the relationships of a CID, as added by AddRelationship.
+// Each map goes from relation type (like "encrypted" or "derived") to related CIDs.
+type Relationships struct {
+	Children map[string][]string `json:"children"`
+	Parents  map[string][]string `json:"parents"`
+}
+
+// GetRelationships returns the parents and children of the CID. A CID with no
+// relationships returns empty maps, not an error.
+//
+// AA stores relationships as the "children" and "parents" attributes of each CID,
+// so those are what is retrieved.
+func GetRelationships(cid string) (*Relationships, error) {
+	return GetAAInstanceFromConfig().GetRelationships(cid)
+}
+
+// GetRelationshipsCtx is like GetRelationships but takes a context.
+func GetRelationshipsCtx(ctx context.Context, cid string) (*Relationships, error) {
+	return GetAAInstanceFromConfig().GetRelationshipsCtx(ctx, cid)
+}
+
+// GetRelationships returns the parents and children of the CID. A CID with no
+// relationships returns empty maps, not an error.
+//
+// AA stores relationships as the "children" and "parents" attributes of each CID,
+// so those are what is retrieved.
+func (a *AuthAttrInstance) GetRelationships(cid string) (*Relationships, error) {
+	return a.GetRelationshipsCtx(context.Background(), cid)
+}
+
+// GetRelationshipsCtx is like GetRelationships but takes a context.
+func (a *AuthAttrInstance) GetRelationshipsCtx(ctx context.Context, cid string) (*Relationships, error) {
+	children, err := a.getRelAttr(ctx, cid, "children")
+	if err != nil {
+		return nil, err
+	}
+	parents, err := a.getRelAttr(ctx, cid, "parents")
+	if err != nil {
+		return nil, err
+	}
+	return &Relationships{Children: children, Parents: parents}, nil
+}
+
+// getRelAttr retrieves and parses one of the relationship attributes.
+func (a *AuthAttrInstance) getRelAttr(ctx context.Context, cid, relType string) (map[string][]string, error) {
+	ae, err := a.GetAttestationCtx(ctx, cid, relType, GetAttOpts{})
+	if errors.Is(err, ErrNotFound) {
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rels, err := parseRelValue(ae.Attestation.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s attribute for %s: %w", relType, cid, err)
+	}
+	return rels, nil
+}
+
+// parseRelValue converts a decoded relationship attestation value, a map of relation
+// types to arrays of CIDs.
+func parseRelValue(v any) (map[string][]string, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("value is %T, not a map", v)
+	}
+	rels := make(map[string][]string, len(m))
+	for relationType, list := range m {
+		arr, ok := list.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s is %T, not an array", relationType, list)
+		}
+		cids := make([]string, 0, len(arr))
+		for _, c := range arr {
+			cc, ok := c.(CborCID)
+			if !ok || len(cc) < 2 {
+				return nil, fmt.Errorf("%s contains %T, not a CID", relationType, c)
+			}
+			cids = append(cids, cc.String())
+		}
+		rels[relationType] = cids
+	}
+	return rels, nil
+}
+
+// CorrectionsAttr is the attribute that records changes to a CID's relationships.
+//
+// AA has no way to delete a relationship, and its history should stay intact anyway.
+// Instead, removals and restorations are appended to this attribute, on both CIDs
+// of the relationship, and applied by GetEffectiveRelationships.
+const CorrectionsAttr = "relationship_corrections"
+
+// RelCorrection is a single entry of the CorrectionsAttr array.
+type RelCorrection struct {
+	Action       string  `cbor:"action"` // "remove" or "restore"
+	Type         string  `cbor:"type"`   // "children" or "parents"
+	RelationType string  `cbor:"relation_type"`
+	Cid          CborCID `cbor:"cid"`
+}
+
+// RemoveRelationship records that a relationship added with AddRelationship no longer
+// applies. The arguments are the same as for AddRelationship.
+func RemoveRelationship(cid, relType, relationType, relCid string) error {
+	return GetAAInstanceFromConfig().RemoveRelationship(cid, relType, relationType, relCid)
+}
+
+// RemoveRelationshipCtx is like RemoveRelationship but takes a context.
+func RemoveRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
+	return GetAAInstanceFromConfig().RemoveRelationshipCtx(ctx, cid, relType, relationType, relCid)
+}
+
+// RemoveRelationship records that a relationship added with AddRelationship no longer
+// applies. The arguments are the same as for AddRelationship.
+func (a *AuthAttrInstance) RemoveRelationship(cid, relType, relationType, relCid string) error {
+	return a.RemoveRelationshipCtx(context.Background(), cid, relType, relationType, relCid)
+}
+
+// RemoveRelationshipCtx is like RemoveRelationship but takes a context.
+func (a *AuthAttrInstance) RemoveRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
+	return a.correctRelationship(ctx, "remove", cid, relType, relationType, relCid)
+}
+
+// RestoreRelationship undoes RemoveRelationship. Adding the relationship again with
+// AddRelationship is not enough, as AA already has it.
+func RestoreRelationship(cid, relType, relationType, relCid string) error {
+	return GetAAInstanceFromConfig().RestoreRelationship(cid, relType, relationType, relCid)
+}
+
+// RestoreRelationshipCtx is like RestoreRelationship but takes a context.
+func RestoreRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
+	return GetAAInstanceFromConfig().RestoreRelationshipCtx(ctx, cid, relType, relationType, relCid)
+}
+
+// RestoreRelationship undoes RemoveRelationship. Adding the relationship again with
+// AddRelationship is not enough, as AA already has it.
+func (a *AuthAttrInstance) RestoreRelationship(cid, relType, relationType, relCid string) error {
+	return a.RestoreRelationshipCtx(context.Background(), cid, relType, relationType, relCid)
+}
+
+// RestoreRelationshipCtx is like RestoreRelationship but takes a context.
+func (a *AuthAttrInstance) RestoreRelationshipCtx(ctx context.Context, cid, relType, relationType, relCid string) error {
+	return a.correctRelationship(ctx, "restore", cid, relType, relationType, relCid)
+}
+
+// correctRelationship appends the correction to both CIDs, like AA does for
+// relationships themselves.
+func (a *AuthAttrInstance) correctRelationship(ctx context.Context, action, cid, relType, relationType, relCid string) error {
+	var inverse string
+	switch relType {
+	case "children":
+		inverse = "parents"
+	case "parents":
+		inverse = "children"
+	default:
+		return fmt.Errorf("relType must be children or parents, not %q", relType)
+	}
+	cidCbor, err := NewCborCID(cid)
+	if err != nil {
+		return fmt.Errorf("failed to parse cid (%s): %v", cid, err)
+	}
+	relCidCbor, err := NewCborCID(relCid)
+	if err != nil {
+		return fmt.Errorf("failed to parse relCid (%s): %v", relCid, err)
+	}
+
+	err = a.AppendAttestationCtx(ctx, cid, CorrectionsAttr, RelCorrection{
+		Action: action, Type: relType, RelationType: relationType, Cid: relCidCbor,
+	})
+	if err != nil {
+		return err
+	}
+	return a.AppendAttestationCtx(ctx, relCid, CorrectionsAttr, RelCorrection{
+		Action: action, Type: inverse, RelationType: relationType, Cid: cidCbor,
+	})
+}
+
+// GetEffectiveRelationships is like GetRelationships, but removals and restorations
+// recorded in CorrectionsAttr are applied, in order.
+func GetEffectiveRelationships(cid string) (*Relationships, error) {
+	return GetAAInstanceFromConfig().GetEffectiveRelationships(cid)
+}
+
+// GetEffectiveRelationshipsCtx is like GetEffectiveRelationships but takes a context.
+func GetEffectiveRelationshipsCtx(ctx context.Context, cid string) (*Relationships, error) {
+	return GetAAInstanceFromConfig().GetEffectiveRelationshipsCtx(ctx, cid)
+}
+
+// GetEffectiveRelationships is like GetRelationships, but removals and restorations
+// recorded in CorrectionsAttr are applied, in order.
+func (a *AuthAttrInstance) GetEffectiveRelationships(cid string) (*Relationships, error) {
+	return a.GetEffectiveRelationshipsCtx(context.Background(), cid)
+}
+
+// GetEffectiveRelationshipsCtx is like GetEffectiveRelationships but takes a context.
+func (a *AuthAttrInstance) GetEffectiveRelationshipsCtx(ctx context.Context, cid string) (*Relationships, error) {
+	rels, err := a.GetRelationshipsCtx(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	corrections, err := a.getRelCorrections(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	rels.apply(corrections)
+	return rels, nil
+}
+
+// getRelCorrections returns the relationship corrections recorded for the CID,
+// oldest first.
+func (a *AuthAttrInstance) getRelCorrections(ctx context.Context, cid string) ([]RelCorrection, error) {
+	data, err := a.GetAttestationRawCtx(ctx, cid, CorrectionsAttr, GetAttOpts{})
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Attestation struct {
+			Value []RelCorrection `cbor:"value"`
+		} `cbor:"attestation"`
+	}
+	if err := dagCborDecMode.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s attribute for %s: %w", CorrectionsAttr, cid, err)
+	}
+	return v.Attestation.Value, nil
+}
+
+// apply applies the corrections to the relationships, in order.
+func (r *Relationships) apply(corrections []RelCorrection) {
+	for _, c := range corrections {
+		m := r.Children
+		if c.Type == "parents" {
+			m = r.Parents
+		}
+		if len(c.Cid) < 2 {
+			continue
+		}
+		relCid := c.Cid.String()
+		switch c.Action {
+		case "remove":
+			m[c.RelationType] = slices.DeleteFunc(m[c.RelationType], func(s string) bool {
+				return s == relCid
+			})
+			if len(m[c.RelationType]) == 0 {
+				delete(m, c.RelationType)
+			}
+		case "restore":
+			if !slices.Contains(m[c.RelationType], relCid) {
+				m[c.RelationType] = append(m[c.RelationType], relCid)
+			}
+		}
+	}
+}
+
+// Has reports whether the relationship exists.
+func (r *Relationships) Has(relType, relationType, relCid string) bool {
+	m := r.Children
+	if relType == "parents" {
+		m = r.Parents
+	}
+	return slices.Contains(m[relationType], relCid)
+}
+
+// RelationshipsFromAttestations returns the effective relationships recorded in the
+// "children", "parents" and CorrectionsAttr attestations, like GetEffectiveRelationships
+// but without contacting AA. Missing attestations are treated as empty.
+func RelationshipsFromAttestations(atts map[string]*AttEntry) (*Relationships, error) {
+	rels := &Relationships{Children: map[string][]string{}, Parents: map[string][]string{}}
+	var err error
+	if ae, ok := atts["children"]; ok {
+		if rels.Children, err = parseRelValue(ae.Attestation.Value); err != nil {
+			return nil, fmt.Errorf("invalid children attribute: %w", err)
+		}
+	}
+	if ae, ok := atts["parents"]; ok {
+		if rels.Parents, err = parseRelValue(ae.Attestation.Value); err != nil {
+			return nil, fmt.Errorf("invalid parents attribute: %w", err)
+		}
+	}
+	if ae, ok := atts[CorrectionsAttr]; ok {
+		// Round-trip to get the struct
+		b, err := dagCborEncMode.Marshal(ae.Attestation.Value)
+		if err != nil {
+			return nil, err
+		}
+		var corrections []RelCorrection
+		if err := dagCborDecMode.Unmarshal(b, &corrections); err != nil {
+			return nil, fmt.Errorf("invalid %s attribute: %w", CorrectionsAttr, err)
+		}
+		rels.apply(corrections)
+	}
+	return rels, nil
+}
@@ -0,0 +1,87 @@
+package aa
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrVCMismatch is returned by CheckVC if the VC doesn't state what the attestation
+// does.
+var ErrVCMismatch = errors.New("VC does not match attestation")
+
+// VCSubject returns the CID and attribute that a Verifiable Credential from AA is
+// about, and the raw JSON value it states for the attribute.
+func VCSubject(vc []byte) (cid, attr string, value json.RawMessage, err error) {
+	var v struct {
+		Subject map[string]json.RawMessage `json:"credentialSubject"`
+	}
+	if err := json.Unmarshal(vc, &v); err != nil {
+		return "", "", nil, fmt.Errorf("error decoding VC: %w", err)
+	}
+	var id string
+	if err := json.Unmarshal(v.Subject["id"], &id); err != nil || !strings.HasPrefix(id, "ipfs://") {
+		return "", "", nil, fmt.Errorf("VC subject has no ipfs:// id")
+	}
+	if len(v.Subject) != 2 {
+		return "", "", nil, fmt.Errorf("VC subject must have a single attribute")
+	}
+	for k, val := range v.Subject {
+		if k != "id" {
+			attr, value = k, val
+		}
+	}
+	return strings.TrimPrefix(id, "ipfs://"), attr, value, nil
+}
+
+// CheckVC checks that a Verifiable Credential from AA states the same CID, attribute
+// and value as the attestation. VCs aren't covered by the attestation signature, so
+// this is what ties a VC to an attestation verified with VerifyAttEntry.
+func CheckVC(vc []byte, ae *AttEntry) error {
+	cid, attr, value, err := VCSubject(vc)
+	if err != nil {
+		return err
+	}
+	if len(ae.Attestation.CID) < 2 || cid != ae.Attestation.CID.String() {
+		return fmt.Errorf("%w: VC is for CID %s", ErrVCMismatch, cid)
+	}
+	if attr != ae.Attestation.Attribute {
+		return fmt.Errorf("%w: VC is for attribute %q", ErrVCMismatch, attr)
+	}
+
+	// Compare as JSON, which is how the value is encoded in the VC
+	attValue, err := json.Marshal(ae.Attestation.Value)
+	if err != nil {
+		return fmt.Errorf("error encoding attestation value: %w", err)
+	}
+	var want, got any
+	if err := json.Unmarshal(attValue, &want); err != nil {
+		return fmt.Errorf("error decoding attestation value: %w", err)
+	}
+	if err := json.Unmarshal(value, &got); err != nil {
+		return fmt.Errorf("error decoding VC value: %w", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		return fmt.Errorf("%w: value is different", ErrVCMismatch)
+	}
+	return nil
+}
+
+// minCiphertextSize is the size of the nonce and authenticator that start and end
+// every encrypted attestation value.
+const minCiphertextSize = 24 + 16
+
+// VCValueEncrypted returns true if the value from VCSubject is an encrypted
+// attestation value, as AA states it when the attestation is left encrypted: the
+// ciphertext bytes as a base64 string.
+func VCValueEncrypted(value json.RawMessage) bool {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(b) >= minCiphertextSize
+}
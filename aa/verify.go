@@ -0,0 +1,155 @@
+package aa
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/starlinglab/integrity-v2/config"
+)
+
+var (
+	ErrMsgMismatch  = errors.New("signed message does not match attestation")
+	ErrBadSignature = errors.New("invalid signature")
+	ErrUntrustedKey = errors.New("signing key is not trusted")
+)
+
+// TrustedKey is an AA signing public key that is known, and attestations signed
+// with it are accepted as valid.
+type TrustedKey struct {
+	PubKey ed25519.PublicKey
+	Name   string
+}
+
+// UnmarshalCBOR fulfills the cbor.Unmarshaler interface.
+//
+// It decodes the AttEntry as usual, but also holds on to the raw bytes of the
+// attestation, so that the signature can be checked later by VerifyAttEntry.
+func (ae *AttEntry) UnmarshalCBOR(data []byte) error {
+	// New type without methods, to prevent infinite recursion
+	type attEntry AttEntry
+	var v attEntry
+	if err := dagCborDecMode.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var raw struct {
+		Attestation cbor.RawMessage `cbor:"attestation"`
+	}
+	if err := dagCborDecMode.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*ae = AttEntry(v)
+	ae.rawAttestation = raw.Attestation
+	return nil
+}
+
+// attestationCID returns the binary CIDv1 of the DAG-CBOR encoded attestation.
+// This is the message AA signs.
+func (ae *AttEntry) attestationCID() ([]byte, error) {
+	var b []byte
+	var err error
+	if ae.rawAttestation != nil {
+		// Round-trip through a generic value, so the encoding follows the DAG-CBOR
+		// rules no matter how the bytes were received.
+		var v any
+		if err := dagCborDecMode.Unmarshal(ae.rawAttestation, &v); err != nil {
+			return nil, err
+		}
+		b, err = dagCborEncMode.Marshal(v)
+	} else {
+		// Not decoded from CBOR, so the struct is all there is.
+		// This may not be an exact match for timestamps with sub-second precision.
+		b, err = dagCborEncMode.Marshal(ae.Attestation)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(b)
+	// The bytes are (in order) CID version, dag-cbor multicodec, sha2-256 multihash, 32 byte length hash
+	return append([]byte{0x01, 0x71, 0x12, 0x20}, hash[:]...), nil
+}
+
+// VerifyAttEntry checks the signature of the attestation without contacting AA.
+//
+// The attestation is re-encoded as DAG-CBOR and hashed, and the resulting CID must match
+// the signed message. The signature must then be valid, and made by one of the provided
+// trusted keys. The matching key is returned.
+//
+// Encrypted attestations must have been retrieved decrypted for this to succeed.
+func VerifyAttEntry(ae *AttEntry, keys []*TrustedKey) (*TrustedKey, error) {
+	msg, err := ae.attestationCID()
+	if err != nil {
+		return nil, fmt.Errorf("error encoding attestation: %w", err)
+	}
+	if len(ae.Signature.Msg) == 0 || !bytes.Equal(ae.Signature.Msg[1:], msg) {
+		return nil, ErrMsgMismatch
+	}
+	if len(ae.Signature.PubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: public key is %d bytes", ErrBadSignature, len(ae.Signature.PubKey))
+	}
+	if !ed25519.Verify(ae.Signature.PubKey, msg, ae.Signature.Sig) {
+		return nil, ErrBadSignature
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.PubKey, ae.Signature.PubKey) {
+			return k, nil
+		}
+	}
+	return nil, ErrUntrustedKey
+}
+
+// LoadTrustedKeys reads the list of trusted AA signing keys from a file.
+//
+// Each line holds a hex-encoded ed25519 public key, optionally followed by whitespace
+// and a name for the key. Empty lines and lines starting with # are ignored.
+func LoadTrustedKeys(path string) ([]*TrustedKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []*TrustedKey
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyHex, name, _ := strings.Cut(line, " ")
+		pubKey, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hex: %w", lineNum, err)
+		}
+		if len(pubKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("line %d: key is %d bytes, not %d", lineNum, len(pubKey), ed25519.PublicKeySize)
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = keyHex
+		}
+		keys = append(keys, &TrustedKey{PubKey: pubKey, Name: name})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// TrustedKeysFromConfig loads the trusted keys file set in the config under aa.trusted_keys.
+func TrustedKeysFromConfig() ([]*TrustedKey, error) {
+	path := config.GetConfig().AA.TrustedKeys
+	if path == "" {
+		return nil, fmt.Errorf("aa.trusted_keys path is not configured")
+	}
+	return LoadTrustedKeys(path)
+}
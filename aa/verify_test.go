@@ -0,0 +1,149 @@
+package aa
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCid = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+
+// signedEntry builds the DAG-CBOR for an AA database entry signed by priv, the same
+// way AA does, and decodes it like GetAttestation would.
+func signedEntry(t *testing.T, priv ed25519.PrivateKey, value any) *AttEntry {
+	t.Helper()
+	cborCid, err := NewCborCID(testCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation := map[string]any{
+		"CID":       cborCid,
+		"value":     value,
+		"attribute": "description",
+		"encrypted": false,
+		// Sub-second precision is lost if re-encoded from time.Time, so this makes sure
+		// the original bytes are what's used.
+		"timestamp": "2024-05-22T14:37:23.120Z",
+	}
+	b, err := dagCborEncMode.Marshal(attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(b)
+	msg := append([]byte{0x01, 0x71, 0x12, 0x20}, hash[:]...)
+
+	entry := map[string]any{
+		"attestation": attestation,
+		"signature": map[string]any{
+			"pubKey": []byte(priv.Public().(ed25519.PublicKey)),
+			"sig":    ed25519.Sign(priv, msg),
+			"msg":    CborCID(append([]byte{0x00}, msg...)),
+		},
+		"version": "2.0",
+	}
+	b, err = dagCborEncMode.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ae AttEntry
+	if err := dagCborDecMode.Unmarshal(b, &ae); err != nil {
+		t.Fatal(err)
+	}
+	return &ae
+}
+
+func newKey(t *testing.T, name string) (ed25519.PrivateKey, *TrustedKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, &TrustedKey{PubKey: pub, Name: name}
+}
+
+func TestVerifyAttEntry(t *testing.T) {
+	priv, trusted := newKey(t, "prod")
+	_, other := newKey(t, "other")
+
+	t.Run("valid", func(t *testing.T) {
+		ae := signedEntry(t, priv, map[string]any{"nested": []any{"a", uint64(1)}})
+		key, err := VerifyAttEntry(ae, []*TrustedKey{other, trusted})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if key != trusted {
+			t.Errorf("got key %s, want %s", key.Name, trusted.Name)
+		}
+	})
+
+	t.Run("untrusted key", func(t *testing.T) {
+		ae := signedEntry(t, priv, "hello")
+		_, err := VerifyAttEntry(ae, []*TrustedKey{other})
+		if !errors.Is(err, ErrUntrustedKey) {
+			t.Errorf("got %v, want ErrUntrustedKey", err)
+		}
+	})
+
+	t.Run("tampered signature", func(t *testing.T) {
+		ae := signedEntry(t, priv, "hello")
+		ae.Signature.Sig[0] ^= 0xff
+		_, err := VerifyAttEntry(ae, []*TrustedKey{trusted})
+		if !errors.Is(err, ErrBadSignature) {
+			t.Errorf("got %v, want ErrBadSignature", err)
+		}
+	})
+
+	t.Run("tampered attestation", func(t *testing.T) {
+		ae := signedEntry(t, priv, "hello")
+		// Change the value after signing, as if the CBOR was edited
+		var att map[string]any
+		if err := dagCborDecMode.Unmarshal(ae.rawAttestation, &att); err != nil {
+			t.Fatal(err)
+		}
+		att["value"] = "goodbye"
+		raw, err := dagCborEncMode.Marshal(att)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ae.rawAttestation = raw
+		ae.Attestation.Value = "goodbye"
+		_, err = VerifyAttEntry(ae, []*TrustedKey{trusted})
+		if !errors.Is(err, ErrMsgMismatch) {
+			t.Errorf("got %v, want ErrMsgMismatch", err)
+		}
+	})
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trusted_keys.txt")
+	data := "# comment\n\n" +
+		"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a  prod signer\n" +
+		"3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadTrustedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(keys))
+	}
+	if keys[0].Name != "prod signer" {
+		t.Errorf("got name %q, want %q", keys[0].Name, "prod signer")
+	}
+	if keys[1].Name != "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c" {
+		t.Errorf("unnamed key should be named by its hex, got %q", keys[1].Name)
+	}
+
+	if err := os.WriteFile(path, []byte("abcd\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTrustedKeys(path); err == nil {
+		t.Error("expected error for short key")
+	}
+}
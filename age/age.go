@@ -0,0 +1,144 @@
+// Package age encrypts files and keys for partners' age X25519 public keys, so
+// they can be decrypted with the age tool or with this package. It wraps
+// filippo.io/age, adding the fingerprints recorded in AA.
+//
+// See https://age-encryption.org/v1
+package age
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+)
+
+// ErrIncorrectIdentity is returned by Decrypt if none of the identities can decrypt
+// the file.
+var ErrIncorrectIdentity = errors.New("no identity matched any of the recipients")
+
+// Recipient is an X25519 public key that files can be encrypted to.
+type Recipient struct {
+	r *age.X25519Recipient
+}
+
+// ParseRecipient parses a public key in the "age1..." format.
+func ParseRecipient(s string) (*Recipient, error) {
+	r, err := age.ParseX25519Recipient(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed recipient %q: %w", s, err)
+	}
+	return &Recipient{r: r}, nil
+}
+
+// String returns the public key in the "age1..." format.
+func (r *Recipient) String() string {
+	return r.r.String()
+}
+
+// Fingerprint returns a short hex identifier of the public key, for logs and
+// metadata where the full key isn't needed.
+func (r *Recipient) Fingerprint() string {
+	h := sha256.Sum256([]byte(r.String()))
+	return hex.EncodeToString(h[:8])
+}
+
+// Identity is an X25519 private key, that can decrypt files encrypted to its
+// Recipient.
+type Identity struct {
+	i *age.X25519Identity
+}
+
+// GenerateIdentity creates a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{i: i}, nil
+}
+
+// ParseIdentity parses a private key in the "AGE-SECRET-KEY-1..." format.
+func ParseIdentity(s string) (*Identity, error) {
+	i, err := age.ParseX25519Identity(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed identity: %w", err)
+	}
+	return &Identity{i: i}, nil
+}
+
+// ParseIdentities reads an identity file, as written by age-keygen: one identity
+// per line, with blank lines and lines starting with # ignored.
+func ParseIdentities(r io.Reader) ([]*Identity, error) {
+	parsed, err := age.ParseIdentities(r)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]*Identity, len(parsed))
+	for i, id := range parsed {
+		x, ok := id.(*age.X25519Identity)
+		if !ok {
+			return nil, fmt.Errorf("unsupported identity type %T", id)
+		}
+		ids[i] = &Identity{i: x}
+	}
+	return ids, nil
+}
+
+// ReadIdentityFile reads an identity file, see ParseIdentities.
+func ReadIdentityFile(path string) ([]*Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseIdentities(f)
+}
+
+// String returns the private key in the "AGE-SECRET-KEY-1..." format.
+func (i *Identity) String() string {
+	return i.i.String()
+}
+
+// Recipient returns the public key of the identity.
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{r: i.i.Recipient()}
+}
+
+// Encrypt returns a writer that encrypts what's written to it for the recipients,
+// and writes the age file to dst. Close must be called to finish the file.
+func Encrypt(dst io.Writer, recipients ...*Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients")
+	}
+	rs := make([]age.Recipient, len(recipients))
+	for i, r := range recipients {
+		rs[i] = r.r
+	}
+	return age.Encrypt(dst, rs...)
+}
+
+// Decrypt reads the header of the age file in src, and returns a reader of the
+// decrypted contents. ErrIncorrectIdentity is returned if the file isn't encrypted
+// to any of the identities.
+//
+// Reads return an error if the file was modified or truncated, so all of it must be
+// read before trusting any of it.
+func Decrypt(src io.Reader, identities ...*Identity) (io.Reader, error) {
+	ids := make([]age.Identity, len(identities))
+	for i, id := range identities {
+		ids[i] = id.i
+	}
+	r, err := age.Decrypt(src, ids...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrIncorrectIdentity
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading age file: %w", err)
+	}
+	return r, nil
+}
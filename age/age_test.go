@@ -0,0 +1,152 @@
+package age
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := id.String()
+	if !strings.HasPrefix(s, "AGE-SECRET-KEY-1") {
+		t.Fatalf("identity is %s", s)
+	}
+	parsed, err := ParseIdentity(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := parsed.Recipient().String()
+	if !strings.HasPrefix(r, "age1") || r != id.Recipient().String() {
+		t.Fatalf("recipient is %s", r)
+	}
+	if _, err := ParseRecipient(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseRecipient(s); err == nil {
+		t.Fatal("identity was accepted as a recipient")
+	}
+
+	ids, err := ParseIdentities(strings.NewReader("# created: now\n# public key: " + r + "\n" + s + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("parsed %d identities", len(ids))
+	}
+}
+
+// chunkSize is the size of age payload chunks.
+const chunkSize = 64 * 1024
+
+func TestEncryptDecrypt(t *testing.T) {
+	alice, _ := GenerateIdentity()
+	bob, _ := GenerateIdentity()
+	eve, _ := GenerateIdentity()
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 100} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		var buf bytes.Buffer
+		w, err := Encrypt(&buf, alice.Recipient(), bob.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Odd write sizes, to cross chunk boundaries
+		for p := plain; len(p) > 0; {
+			n := min(len(p), 1000)
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		enc := buf.Bytes()
+
+		r, err := Decrypt(bytes.NewReader(enc), eve, bob)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: decrypted data doesn't match", size)
+		}
+
+		if _, err := Decrypt(bytes.NewReader(enc), eve); !errors.Is(err, ErrIncorrectIdentity) {
+			t.Fatalf("size %d: wrong identity: %v", size, err)
+		}
+
+		// Truncating the payload by a chunk or less must be noticed
+		if size > chunkSize {
+			cut := enc[:len(enc)-(size%chunkSize)-16]
+			r, err := Decrypt(bytes.NewReader(cut), alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(r); err == nil {
+				t.Fatalf("size %d: truncation wasn't noticed", size)
+			}
+		}
+		flipped := bytes.Clone(enc)
+		flipped[len(flipped)-1] ^= 1
+		r, err = Decrypt(bytes.NewReader(flipped), alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatalf("size %d: modification wasn't noticed", size)
+		}
+	}
+}
+
+// Files from the age tool must decrypt, see testdata/. They were made with:
+//
+//	age-keygen -o identity.txt
+//	age -r <public key> -o plain.txt.age plain.txt
+func TestAgeTool(t *testing.T) {
+	ids, err := ReadIdentityFile("testdata/identity.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := ids[0].Recipient().String(); r != "age19h5ra6lgnasnxx9pu47mxgavjhcmke2vnk2pgafudhvp46eymsksc4ng99" {
+		t.Fatalf("recipient is %s", r)
+	}
+	enc, err := os.ReadFile("testdata/plain.txt.age")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := os.ReadFile("testdata/plain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Decrypt(bytes.NewReader(enc), ids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data doesn't match")
+	}
+
+	// Header changes are caught by the MAC
+	modified := bytes.Replace(enc, []byte("X25519 "), []byte("X25519  "), 1)
+	if _, err := Decrypt(bytes.NewReader(modified), ids...); err == nil {
+		t.Fatal("modified header was accepted")
+	}
+}
@@ -0,0 +1,460 @@
+package attrimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	specPath  string
+	cidsPath  string
+	filesDir  string
+	statePath string
+	dryRun    bool
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.StringVar(&specPath, "spec", "", "path to mapping spec file (.toml or .json)")
+	fs.StringVar(&cidsPath, "cids", "", "path to two-column CSV mapping file keys to CIDs")
+	fs.StringVar(&filesDir, "files", "", "directory of the files, to find CIDs by hashing them instead of using --cids")
+	fs.StringVar(&statePath, "state", "", "(optional) path to file that records imported rows, defaults to the input path with .state added")
+	fs.BoolVar(&dryRun, "dry-run", false, "show the changes that would be made to AA, without making them")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	if specPath == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nprovide a spec file with --spec")
+	}
+	if (cidsPath == "") == (filesDir == "") {
+		return fmt.Errorf("provide exactly one of --cids and --files")
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single input file (.csv or .jsonl)")
+	}
+	inputPath := fs.Arg(0)
+	if statePath == "" {
+		statePath = inputPath + ".state"
+	}
+
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		return fmt.Errorf("error loading spec: %w", err)
+	}
+	rows, err := readRows(inputPath)
+	if err != nil {
+		return fmt.Errorf("error reading input: %w", err)
+	}
+
+	imp := &importer{
+		spec:   spec,
+		aa:     aa.GetAAInstanceFromConfig(),
+		out:    os.Stdout,
+		dryRun: dryRun,
+		encKey: func(cid, attr string) ([]byte, error) {
+			_, key, _, err := util.GenerateEncKey(cid, attr)
+			return key, err
+		},
+	}
+	if dryRun {
+		// Don't create keys that might never be used
+		imp.encKey = util.ReadEncKey
+	}
+	if cidsPath != "" {
+		imp.cids, err = readCidsCsv(cidsPath)
+		if err != nil {
+			return fmt.Errorf("error reading CIDs CSV: %w", err)
+		}
+	} else {
+		imp.filesDir = filesDir
+		imp.cids = make(map[string]string)
+	}
+	if err := imp.loadState(statePath); err != nil {
+		return fmt.Errorf("error reading state file: %w", err)
+	}
+
+	return imp.run(rows)
+}
+
+// row is a single record of the input, by column name.
+type row map[string]any
+
+// readRows reads a CSV file with a header row, or a JSON lines file of objects.
+func readRows(path string) ([]row, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []row
+	if strings.ToLower(filepath.Ext(path)) == ".jsonl" {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var r row
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			rows = append(rows, r)
+		}
+		return rows, scanner.Err()
+	}
+
+	cr := csv.NewReader(f)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %w", err)
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := make(row, len(header))
+		for i, col := range header {
+			r[col] = record[i]
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// readCidsCsv reads a two-column CSV mapping keys (like file names) to CIDs.
+func readCidsCsv(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cids := make(map[string]string)
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cids[record[0]] = record[1]
+	}
+	return cids, nil
+}
+
+type importer struct {
+	spec   *Spec
+	aa     *aa.AuthAttrInstance
+	out    io.Writer
+	dryRun bool
+	// encKey returns the key for an encrypted attribute, or nil if there isn't one.
+	encKey func(cid, attr string) ([]byte, error)
+
+	cids     map[string]string
+	filesDir string // If set, cids is filled in by hashing files from here
+
+	// done holds the keys of rows that were already imported, and state records
+	// newly imported ones. state is nil for dry runs.
+	done  map[string]bool
+	state *os.File
+}
+
+// loadState reads the keys of rows imported by previous runs, and opens the state
+// file to add more.
+func (imp *importer) loadState(path string) error {
+	imp.done = make(map[string]bool)
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, key := range strings.Split(string(b), "\n") {
+		if key != "" {
+			imp.done[key] = true
+		}
+	}
+	if imp.dryRun {
+		return nil
+	}
+	imp.state, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	return err
+}
+
+// resolve returns the CID for a key.
+func (imp *importer) resolve(key string) (string, error) {
+	if cid, ok := imp.cids[key]; ok {
+		return cid, nil
+	}
+	if imp.filesDir == "" {
+		return "", fmt.Errorf("no CID for %q in CIDs CSV", key)
+	}
+	f, err := os.Open(filepath.Join(imp.filesDir, key))
+	if err != nil {
+		return "", fmt.Errorf("error opening file to calculate CID: %w", err)
+	}
+	defer f.Close()
+	cid, err := util.CalculateFileCid(f)
+	if err != nil {
+		return "", fmt.Errorf("error calculating CID of %s: %w", key, err)
+	}
+	imp.cids[key] = cid
+	return cid, nil
+}
+
+func (imp *importer) run(rows []row) error {
+	if imp.state != nil {
+		defer imp.state.Close()
+	}
+
+	var imported, skipped, unchanged int
+	for i, r := range rows {
+		if !imp.spec.matches(r) {
+			continue
+		}
+		key := cellString(r[imp.spec.KeyColumn])
+		if key == "" {
+			return fmt.Errorf("row %d: %s is empty", i+1, imp.spec.KeyColumn)
+		}
+		if imp.done[key] {
+			skipped++
+			continue
+		}
+		changed, err := imp.importRow(key, r)
+		if err != nil {
+			return fmt.Errorf("row %d (%s): %w\n\n%d rows imported. Rows that were already imported are skipped when run again",
+				i+1, key, err, imported)
+		}
+		if !changed {
+			unchanged++
+		}
+		imported++
+		if imp.state != nil {
+			if _, err := fmt.Fprintln(imp.state, key); err != nil {
+				return fmt.Errorf("error writing state file: %w", err)
+			}
+		}
+	}
+
+	if imp.dryRun {
+		fmt.Fprintf(imp.out, "\n%d rows would be imported, %d of them without changes. %d skipped as already imported.\n",
+			imported, unchanged, skipped)
+	} else {
+		fmt.Fprintf(imp.out, "\n%d rows imported, %d of them without changes. %d skipped as already imported.\n",
+			imported, unchanged, skipped)
+	}
+	return nil
+}
+
+// matches reports whether the row meets the spec's requirements.
+func (s *Spec) matches(r row) bool {
+	for col, val := range s.Require {
+		if cellString(r[col]) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// cellString returns the cell as a trimmed string, or "" if it's missing or null.
+func cellString(cell any) string {
+	switch v := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+// relation is a relationship to add, in the terms of aa.AddRelationship.
+type relation struct {
+	relType, relationType, relCid string
+}
+
+// importRow compares the row to what's in AA, prints the differences, and sets the
+// changed values unless this is a dry run. Empty cells are ignored.
+func (imp *importer) importRow(key string, r row) (bool, error) {
+	cid, err := imp.resolve(key)
+	if err != nil {
+		return false, err
+	}
+
+	var diff []string
+	var indexed, unindexed []aa.PostKV
+	for _, c := range imp.spec.Columns {
+		if cellString(r[c.Column]) == "" {
+			continue
+		}
+		val, err := c.parseValue(r[c.Column])
+		if err != nil {
+			return false, err
+		}
+		var encKey []byte
+		if c.Encrypted {
+			encKey, err = imp.encKey(cid, c.Attr)
+			if err != nil {
+				return false, fmt.Errorf("error getting key for %s: %w", c.Attr, err)
+			}
+		}
+
+		old, err := imp.aa.GetAttestation(cid, c.Attr, aa.GetAttOpts{EncKey: encKey})
+		switch {
+		case errors.Is(err, aa.ErrNotFound):
+			diff = append(diff, fmt.Sprintf("+ %s: %s", c.Attr, toJSON(val)))
+		case errors.Is(err, aa.ErrNeedsKey):
+			// Only happens in dry runs, when the key doesn't exist yet
+			diff = append(diff, fmt.Sprintf("? %s: %s (encrypted, can't compare)", c.Attr, toJSON(val)))
+		case err != nil:
+			return false, fmt.Errorf("error getting current value of %s: %w", c.Attr, err)
+		case equal(old.Attestation.Value, val):
+			continue
+		default:
+			diff = append(diff, fmt.Sprintf("~ %s: %s -> %s", c.Attr, toJSON(old.Attestation.Value), toJSON(val)))
+		}
+
+		kv := aa.PostKV{Key: c.Attr, Value: val, EncKey: encKey}
+		if c.Index {
+			kv.Type = "str"
+			indexed = append(indexed, kv)
+		} else {
+			unindexed = append(unindexed, kv)
+		}
+	}
+
+	rels, err := imp.relations(r)
+	if err != nil {
+		return false, err
+	}
+	var newRels []relation
+	if len(rels) > 0 {
+		current, err := imp.aa.GetEffectiveRelationships(cid)
+		if err != nil {
+			return false, fmt.Errorf("error getting current relationships: %w", err)
+		}
+		for _, rel := range rels {
+			if !current.Has(rel.relType, rel.relationType, rel.relCid) {
+				newRels = append(newRels, rel)
+				diff = append(diff, fmt.Sprintf("+ %s: %s %s", rel.relType, rel.relationType, rel.relCid))
+			}
+		}
+	}
+
+	if len(diff) == 0 {
+		fmt.Fprintf(imp.out, "%s (%s): no changes\n", key, cid)
+		return false, nil
+	}
+	fmt.Fprintf(imp.out, "%s (%s):\n", key, cid)
+	for _, d := range diff {
+		fmt.Fprintf(imp.out, "  %s\n", d)
+	}
+	if imp.dryRun {
+		return true, nil
+	}
+
+	if len(indexed) > 0 {
+		if err := imp.aa.SetAttestations(cid, true, indexed); err != nil {
+			return false, fmt.Errorf("error setting attestations: %w", err)
+		}
+	}
+	if len(unindexed) > 0 {
+		if err := imp.aa.SetAttestations(cid, false, unindexed); err != nil {
+			return false, fmt.Errorf("error setting attestations: %w", err)
+		}
+	}
+	for _, rel := range newRels {
+		if err := imp.addRelationship(cid, rel); err != nil {
+			return false, fmt.Errorf("error adding relationship: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// relations returns the relationships listed in the row.
+func (imp *importer) relations(r row) ([]relation, error) {
+	var rels []relation
+	for _, spec := range imp.spec.Relationships {
+		cell := cellString(r[spec.Column])
+		if cell == "" {
+			continue
+		}
+		relationType := spec.RelationType
+		if spec.RelationTypeColumn != "" {
+			relationType = cellString(r[spec.RelationTypeColumn])
+			if relationType == "" {
+				return nil, fmt.Errorf("%s is empty but %s is not", spec.RelationTypeColumn, spec.Column)
+			}
+		}
+		for _, relKey := range strings.Split(cell, spec.Separator) {
+			relKey = strings.TrimSpace(relKey)
+			if relKey == "" {
+				continue
+			}
+			relCid, err := imp.resolve(relKey)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", spec.Column, err)
+			}
+			rels = append(rels, relation{spec.Type, relationType, relCid})
+		}
+	}
+	return rels, nil
+}
+
+// addRelationship adds the relationship, restoring it if it was removed before.
+func (imp *importer) addRelationship(cid string, rel relation) error {
+	err := imp.aa.AddRelationship(cid, rel.relType, rel.relationType, rel.relCid)
+	if err != nil {
+		return err
+	}
+	rels, err := imp.aa.GetEffectiveRelationships(cid)
+	if err != nil {
+		return err
+	}
+	if rels.Has(rel.relType, rel.relationType, rel.relCid) {
+		return nil
+	}
+	return imp.aa.RestoreRelationship(cid, rel.relType, rel.relationType, rel.relCid)
+}
+
+// equal compares values decoded from AA and parsed from the input. Their Go types
+// differ (like uint64 and int64), so they are compared through JSON.
+func equal(a, b any) bool {
+	var ja, jb any
+	if json.Unmarshal([]byte(toJSON(a)), &ja) != nil || json.Unmarshal([]byte(toJSON(b)), &jb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(ja, jb)
+}
+
+func toJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
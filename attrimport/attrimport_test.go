@@ -0,0 +1,142 @@
+package attrimport
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+)
+
+const testSpec = `
+key_column = "file"
+require = { ingest = "TRUE" }
+
+[[columns]]
+column = "desc"
+attr = "description"
+
+[[columns]]
+column = "date"
+type = "date"
+
+[[columns]]
+column = "count"
+type = "int"
+
+[[columns]]
+column = "secret"
+encrypted = true
+
+[[relationships]]
+column = "parent"
+type = "parents"
+relation_type = "derived"
+`
+
+func TestImport(t *testing.T) {
+	_, a := aatest.Start(t)
+	dir := t.TempDir()
+	specPath := filepath.Join(dir, "spec.toml")
+	if err := os.WriteFile(specPath, []byte(testSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputPath := filepath.Join(dir, "sheet.csv")
+	writeInput := func(s string) []row {
+		t.Helper()
+		if err := os.WriteFile(inputPath, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := readRows(inputPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	key := make([]byte, 32)
+	var out bytes.Buffer
+	newImporter := func(dryRun bool) *importer {
+		imp := &importer{
+			spec:   spec,
+			aa:     a,
+			out:    &out,
+			dryRun: dryRun,
+			encKey: func(cid, attr string) ([]byte, error) { return key, nil },
+			cids:   map[string]string{"a.jpg": cid1, "b.jpg": cid2},
+		}
+		if err := imp.loadState(inputPath + ".state"); err != nil {
+			t.Fatal(err)
+		}
+		return imp
+	}
+
+	// Second row fails
+	rows := writeInput(`ingest,file,desc,date,count,secret,parent
+TRUE,a.jpg,first,5/1/2024,,shh,
+FALSE,c.jpg,ignored,,,,
+TRUE,b.jpg,second,,x,,a.jpg
+`)
+	if err := newImporter(true).run(rows); err == nil {
+		t.Fatal("invalid int should fail")
+	}
+	if _, err := a.GetAttestation(cid1, "description", aa.GetAttOpts{}); err == nil {
+		t.Error("dry run shouldn't set attestations")
+	}
+	if !strings.Contains(out.String(), `+ date: "2024-05-01"`) {
+		t.Errorf("unexpected dry run output:\n%s", out.String())
+	}
+
+	if err := newImporter(false).run(rows); err == nil {
+		t.Fatal("invalid int should fail")
+	}
+	ae, err := a.GetAttestation(cid1, "secret", aa.GetAttOpts{EncKey: key})
+	if err != nil || ae.Attestation.Value != "shh" {
+		t.Errorf("got (%v, %v) for encrypted value", ae, err)
+	}
+
+	// Fix and resume, changing an already imported row which is skipped
+	out.Reset()
+	rows = writeInput(`ingest,file,desc,date,count,secret,parent
+TRUE,a.jpg,changed,5/1/2024,,shh,
+TRUE,b.jpg,second,,3,,a.jpg
+`)
+	if err := newImporter(false).run(rows); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "1 rows imported, 0 of them without changes. 1 skipped") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	ae, err = a.GetAttestation(cid1, "description", aa.GetAttOpts{})
+	if err != nil || ae.Attestation.Value != "first" {
+		t.Errorf("already imported row should be skipped, got (%v, %v)", ae, err)
+	}
+	rels, err := a.GetEffectiveRelationships(cid1)
+	if err != nil || !rels.Has("children", "derived", cid2) {
+		t.Errorf("got (%v, %v) for relationships", rels, err)
+	}
+
+	// Without state, only differences are shown
+	out.Reset()
+	if err := os.Remove(inputPath + ".state"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newImporter(true).run(rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "a.jpg (" + cid1 + "):\n  ~ description: \"first\" -> \"changed\"\nb.jpg (" + cid2 + "): no changes\n"
+	if !strings.HasPrefix(out.String(), want) {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,219 @@
+package attrimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Spec describes how the rows of an input file map to attributes.
+// It is loaded from a TOML or JSON file, see docs/import.md.
+type Spec struct {
+	// KeyColumn identifies the file each row is about. Its values are looked up in
+	// the CIDs CSV, or are file paths relative to the --files directory.
+	KeyColumn string `toml:"key_column" json:"key_column"`
+	// Rows are only imported if every column in Require has the given value.
+	Require map[string]string `toml:"require" json:"require"`
+
+	Columns       []Column       `toml:"columns" json:"columns"`
+	Relationships []Relationship `toml:"relationships" json:"relationships"`
+}
+
+// Column maps one input column to an attribute.
+type Column struct {
+	Column string `toml:"column" json:"column"`
+	// Attr is the attribute name, the column name is used if empty.
+	Attr string `toml:"attr" json:"attr"`
+	// Type is how the value is parsed, see parseValue.
+	Type string `toml:"type" json:"type"`
+	// Split turns the value into an array, split by this separator.
+	Split     string `toml:"split" json:"split"`
+	Index     bool   `toml:"index" json:"index"`
+	Encrypted bool   `toml:"encrypted" json:"encrypted"`
+}
+
+// Relationship adds relationships from the row's CID to the CIDs in a column.
+type Relationship struct {
+	// Column holds keys of related files, which are resolved to CIDs like KeyColumn.
+	Column string `toml:"column" json:"column"`
+	// Separator splits multiple keys in a single cell. Defaults to a comma.
+	Separator string `toml:"separator" json:"separator"`
+	// Type is "children" or "parents", the direction of the relationship from the
+	// row's CID. Defaults to "children".
+	Type string `toml:"type" json:"type"`
+	// RelationType is a fixed relation type like "related", or RelationTypeColumn
+	// can be used to take it from the row instead.
+	RelationType       string `toml:"relation_type" json:"relation_type"`
+	RelationTypeColumn string `toml:"relation_type_column" json:"relation_type_column"`
+}
+
+var valueTypes = []string{"str", "int", "float", "bool", "json", "date"}
+
+// LoadSpec reads a spec from a .toml or .json file and validates it.
+func LoadSpec(path string) (*Spec, error) {
+	var spec Spec
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if _, err := toml.DecodeFile(path, &spec); err != nil {
+			return nil, err
+		}
+	case ".json":
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &spec); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("spec must be a .toml or .json file")
+	}
+	return &spec, spec.validate()
+}
+
+func (s *Spec) validate() error {
+	if s.KeyColumn == "" {
+		return fmt.Errorf("key_column must be set")
+	}
+	if len(s.Columns) == 0 && len(s.Relationships) == 0 {
+		return fmt.Errorf("no columns or relationships to import")
+	}
+	attrs := make(map[string]bool)
+	for i := range s.Columns {
+		c := &s.Columns[i]
+		if c.Column == "" {
+			return fmt.Errorf("column %d: column must be set", i+1)
+		}
+		if c.Attr == "" {
+			c.Attr = c.Column
+		}
+		if c.Type == "" {
+			c.Type = "str"
+		}
+		if attrs[c.Attr] {
+			return fmt.Errorf("column %s: attribute %s is set twice", c.Column, c.Attr)
+		}
+		attrs[c.Attr] = true
+		if !slices.Contains(valueTypes, c.Type) {
+			return fmt.Errorf("column %s: type must be one of %s", c.Column, strings.Join(valueTypes, ", "))
+		}
+		if c.Index && (c.Type != "str" || c.Split != "" || c.Encrypted) {
+			// Same limitation as "attr set"
+			return fmt.Errorf("column %s: only unencrypted str values can be indexed", c.Column)
+		}
+		if c.Split != "" && c.Type == "json" {
+			return fmt.Errorf("column %s: json values can't be split", c.Column)
+		}
+	}
+	for i := range s.Relationships {
+		r := &s.Relationships[i]
+		if r.Column == "" {
+			return fmt.Errorf("relationship %d: column must be set", i+1)
+		}
+		if r.Separator == "" {
+			r.Separator = ","
+		}
+		if r.Type == "" {
+			r.Type = "children"
+		}
+		if r.Type != "children" && r.Type != "parents" {
+			return fmt.Errorf("relationship %s: type must be children or parents", r.Column)
+		}
+		if (r.RelationType == "") == (r.RelationTypeColumn == "") {
+			return fmt.Errorf("relationship %s: set one of relation_type and relation_type_column", r.Column)
+		}
+	}
+	return nil
+}
+
+// parseValue converts a cell to the column's type. Cells from CSV files are always
+// strings, but cells from JSON lines files may already have the right type.
+//
+// The "date" type accepts YYYY-MM-DD or MM/DD/YYYY (as exported by spreadsheets),
+// and is stored as YYYY-MM-DD.
+func (c *Column) parseValue(cell any) (any, error) {
+	if c.Split != "" {
+		if s, ok := cell.(string); ok {
+			parts := strings.Split(s, c.Split)
+			arr := make([]any, len(parts))
+			for i, p := range parts {
+				v, err := c.parseSingle(strings.TrimSpace(p))
+				if err != nil {
+					return nil, err
+				}
+				arr[i] = v
+			}
+			return arr, nil
+		}
+	}
+	return c.parseSingle(cell)
+}
+
+func (c *Column) parseSingle(cell any) (any, error) {
+	s, isStr := cell.(string)
+	if !isStr {
+		// Already decoded from JSON
+		switch c.Type {
+		case "json":
+			return cell, nil
+		case "int":
+			if f, ok := cell.(float64); ok && f == float64(int64(f)) {
+				return int64(f), nil
+			}
+		case "float":
+			if f, ok := cell.(float64); ok {
+				return f, nil
+			}
+		case "bool":
+			if b, ok := cell.(bool); ok {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: can't use %T value as %s", c.Column, cell, c.Type)
+	}
+
+	switch c.Type {
+	case "str":
+		return s, nil
+	case "int":
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid int %q", c.Column, s)
+		}
+		return v, nil
+	case "float":
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid float %q", c.Column, s)
+		}
+		return v, nil
+	case "bool":
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid bool %q", c.Column, s)
+		}
+		return v, nil
+	case "json":
+		var v any
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("%s: invalid JSON: %w", c.Column, err)
+		}
+		return v, nil
+	case "date":
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+		if t, err := time.Parse("1/2/2006", s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+		return nil, fmt.Errorf("%s: invalid date %q, use YYYY-MM-DD or MM/DD/YYYY", c.Column, s)
+	}
+	return nil, fmt.Errorf("%s: unknown type %s", c.Column, c.Type)
+}
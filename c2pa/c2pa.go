@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"math/rand/v2"
 	"os"
 	"os/exec"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/starlinglab/integrity-v2/aa"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
 	"github.com/starlinglab/integrity-v2/util"
 )
 
@@ -30,11 +32,15 @@ func Run(args []string) error {
 	fs.StringVar(&manifestName, "manifest", "", "name of the C2PA manifest template")
 	fs.BoolVar(&dryRun, "dry-run", false, "show manifest without injecting any files")
 	fs.StringVar(&signer, "signer", "local", "signer backend: local or trufo")
+	noCache := fs.Bool("no-cache", false, "always get attributes from AA, ignoring the local cache")
 
 	if err := fs.Parse(args); err != nil {
 		// Error is already printed
 		os.Exit(1)
 	}
+	if *noCache {
+		aa.DisableCache()
+	}
 
 	if manifestName == "" {
 		fs.PrintDefaults()
@@ -84,7 +90,11 @@ func signLocal(conf *config.Config) (string, error) {
 	// c2patool requires a file extension, so determine it from the file and
 	// give it a symlinked input. The stored output is keyed by CID with no
 	// extension.
-	cidPath := filepath.Join(conf.Dirs.Files, cid)
+	store, err := filestore.FromConfig(conf)
+	if err != nil {
+		return "", err
+	}
+	cidPath := store.Path(cid)
 	mediaType, err := util.GuessMediaType(cidPath)
 	if err != nil {
 		return "", err
@@ -146,7 +156,16 @@ func signTrufo(conf *config.Config) (string, error) {
 		return "", fmt.Errorf("trufo.api_key not set in config file")
 	}
 
-	media, err := os.ReadFile(filepath.Join(conf.Dirs.Files, cid))
+	store, err := filestore.FromConfig(conf)
+	if err != nil {
+		return "", err
+	}
+	r, err := store.Get(cid)
+	if err != nil {
+		return "", fmt.Errorf("error opening CID file: %w", err)
+	}
+	media, err := io.ReadAll(r)
+	r.Close()
 	if err != nil {
 		return "", fmt.Errorf("error reading CID file: %w", err)
 	}
@@ -180,7 +199,10 @@ func finishExport(conf *config.Config, signer, tmpOut string) error {
 	if err != nil {
 		return fmt.Errorf("error getting output file CID: %w", err)
 	}
-	c2paFinalPath := filepath.Join(conf.Dirs.Files, c2paCid)
+	store, err := filestore.FromConfig(conf)
+	if err != nil {
+		return err
+	}
 
 	c2paCidCbor, err := aa.NewCborCID(c2paCid)
 	if err != nil {
@@ -199,11 +221,11 @@ func finishExport(conf *config.Config, signer, tmpOut string) error {
 		return fmt.Errorf("error setting relationship attestations: %w", err)
 	}
 
-	if err := util.MoveFile(tmpOut, c2paFinalPath); err != nil {
+	if err := store.PutFile(c2paCid, tmpOut); err != nil {
 		return fmt.Errorf("error moving temp file into c2pa file storage: %w", err)
 	}
 
-	fmt.Printf("Injected file stored at %s\n", c2paFinalPath)
+	fmt.Printf("Injected file stored at %s\n", store.Path(c2paCid))
 	fmt.Println("Logged C2PA export and relationship to AuthAttr to the respective attributes: c2pa_exports, children")
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -11,8 +12,13 @@ import (
 // See example_config.toml
 type Config struct {
 	AA struct {
-		Url string `toml:"url"`
-		Jwt string `toml:"jwt"`
+		Url         string        `toml:"url"`
+		Jwt         string        `toml:"jwt"`
+		TrustedKeys string        `toml:"trusted_keys"`
+		Timeout     time.Duration `toml:"timeout"`
+		Retries     int           `toml:"retries"`
+		CacheDir    string        `toml:"cache_dir"`
+		CacheTTL    time.Duration `toml:"cache_ttl"`
 	} `toml:"aa"`
 	Webhook struct {
 		Host string `toml:"host"`
@@ -20,10 +26,15 @@ type Config struct {
 	} `toml:"webhook"`
 	Dirs struct {
 		Files             string `toml:"files"`
+		FilesLayout       string `toml:"files_layout"`
 		C2PAManifestTmpls string `toml:"c2pa_manifest_templates"`
 		EncKeys           string `toml:"enc_keys"`
 		Cardano           string `toml:"cardano"`
 	} `toml:"dirs"`
+	Keys struct {
+		MasterKey        string `toml:"master_key"`
+		EscrowRecipients string `toml:"escrow_recipients"`
+	} `toml:"keys"`
 	FolderPreprocessor struct {
 		SyncFolderRoot string `toml:"sync_folder_root"`
 	} `toml:"folder_preprocessor"`
@@ -67,6 +78,21 @@ type Config struct {
 		Url   string `toml:"url"`
 		Token string `toml:"token"`
 	} `toml:"nectar"`
+	S3 map[string]S3Target `toml:"s3"`
+}
+
+// S3Target is an S3-compatible bucket for the upload command, configured under
+// [s3.<name>] and used as the <name> remote.
+type S3Target struct {
+	Endpoint        string `toml:"endpoint"`
+	Region          string `toml:"region"` // optional, defaults to us-east-1
+	Bucket          string `toml:"bucket"`
+	AccessKeyID     string `toml:"access_key_id"`
+	SecretAccessKey string `toml:"secret_access_key"`
+	PathStyle       bool   `toml:"path_style"`     // optional, needed for MinIO and most other services
+	SSE             string `toml:"sse"`            // optional, "AES256" or "aws:kms"
+	SSEKMSKeyID     string `toml:"sse_kms_key_id"` // optional, with "aws:kms"
+	PartSize        int64  `toml:"part_size"`      // optional, in bytes
 }
 
 var conf *Config
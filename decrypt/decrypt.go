@@ -1,25 +1,55 @@
 package decrypt
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
-	"github.com/openziti/secretstream"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/age"
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/sstream"
+	"github.com/starlinglab/integrity-v2/util"
 )
 
 var (
-	inPath  string
-	keyPath string
-	outPath string
+	inPath       string
+	keyPath      string
+	identityPath string
+	outPath      string
+	verify       bool
+	restore      bool
 )
 
+// decrypter returns a reader of the decrypted data from src.
+type decrypter func(src io.Reader) (io.Reader, error)
+
+func keyDecrypter(key []byte) decrypter {
+	return func(src io.Reader) (io.Reader, error) {
+		return sstream.NewReader(src, key)
+	}
+}
+
+func identityDecrypter(identities []*age.Identity) decrypter {
+	return func(src io.Reader) (io.Reader, error) {
+		return age.Decrypt(src, identities...)
+	}
+}
+
 func Run(args []string) error {
 	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
-	fs.StringVar(&inPath, "i", "", "path to encrypted input file")
-	fs.StringVar(&keyPath, "k", "", "path to decryption key file (32 bytes)")
-	fs.StringVar(&outPath, "o", "", "path where decrypted file should be written")
+	fs.StringVar(&inPath, "i", "", "path to encrypted input file, or - for stdin")
+	fs.StringVar(&keyPath, "k", "", "path to decryption key file")
+	fs.StringVar(&identityPath, "identity", "", "path to age identity file, for files encrypted with --recipient")
+	fs.StringVar(&outPath, "o", "", "path where decrypted file should be written, or - for stdout")
+	fs.BoolVar(&verify, "verify", false, "check the file decrypts to the parent CID recorded in AA for the encrypted CID. -o is optional")
+	fs.BoolVar(&restore, "restore", false, "when decrypting a CID, put the decrypted file back into the file store")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -27,116 +57,256 @@ func Run(args []string) error {
 		os.Exit(1)
 	}
 
-	if inPath == "" || keyPath == "" || outPath == "" {
+	if fs.NArg() > 1 {
+		return fmt.Errorf("provide a single encrypted CID to decrypt")
+	}
+	if keyPath != "" && identityPath != "" {
+		return fmt.Errorf("-k and --identity can't be used together")
+	}
+	var dec decrypter
+	if identityPath != "" {
+		identities, err := age.ReadIdentityFile(identityPath)
+		if err != nil {
+			return fmt.Errorf("error reading identity file: %w", err)
+		}
+		dec = identityDecrypter(identities)
+	}
+	if fs.NArg() == 1 {
+		if inPath != "" || keyPath != "" {
+			return fmt.Errorf("-i and -k can't be used when decrypting a CID")
+		}
+		if outPath == "" && !verify && !restore {
+			return fmt.Errorf("provide -o, unless --verify or --restore is used")
+		}
+		return runCid(fs.Arg(0), dec)
+	}
+
+	if restore {
+		return fmt.Errorf("--restore can only be used when decrypting a CID")
+	}
+	if inPath == "" || (keyPath == "" && identityPath == "") || (outPath == "" && !verify) {
 		fs.PrintDefaults()
-		return fmt.Errorf("\nall flags must be specified")
+		return fmt.Errorf("\nprovide an encrypted CID, or -i and -k or --identity, and -o unless --verify is used")
+	}
+
+	if keyPath != "" {
+		key, err := util.ReadKeyFile(keyPath)
+		if err != nil {
+			return err
+		}
+		dec = keyDecrypter(key)
+	}
+
+	in := os.Stdin
+	if inPath != "-" {
+		in, err = os.Open(inPath)
+		if err != nil {
+			return fmt.Errorf("error opening input file: %w", err)
+		}
+		defer in.Close()
 	}
 
-	fi, err := os.Stat(keyPath)
+	out, err := openOutput(outPath)
 	if err != nil {
 		return err
 	}
-	if fi.Size() != 32 {
-		return fmt.Errorf("expected key file to be 32 bytes, instead was %d", fi.Size())
+	defer out.Close()
+
+	fmt.Fprintln(out.status, "Decrypting...")
+	encCid, plainCid, err := decryptFile(out, in, dec)
+	if err == nil && verify {
+		fmt.Fprintf(out.status, "Encrypted CID: %s\nDecrypted CID: %s\n", encCid, plainCid)
+		err = verifyParent(aa.GetAAInstanceFromConfig(), encCid, plainCid)
+	}
+	if err := out.finish(err); err != nil {
+		return err
 	}
+	if verify {
+		fmt.Fprintln(out.status, "Decrypted file matches the parent recorded in AA.")
+	}
+	fmt.Fprintln(out.status, "Done.")
+	return nil
+}
 
-	key, err := os.ReadFile(keyPath)
+// runCid decrypts an encrypted CID from the file store, with dec, or the key saved
+// by "file encrypt" if dec is nil.
+func runCid(cid string, dec decrypter) error {
+	conf := config.GetConfig()
+	store, err := filestore.FromConfig(conf)
 	if err != nil {
 		return err
 	}
+	if dec == nil {
+		dec, err = storedKeyDecrypter(conf.Dirs.EncKeys, cid)
+		if err != nil {
+			return err
+		}
+	}
 
-	inF, err := os.Open(inPath)
+	out, err := openOutput(outPath)
 	if err != nil {
-		return fmt.Errorf("error opening input file: %w", err)
+		return err
 	}
-	defer inF.Close()
+	defer out.Close()
 
-	fi, err = inF.Stat()
-	if err != nil {
-		return fmt.Errorf("error statting CID file: %w", err)
+	fmt.Fprintln(out.status, "Decrypting...")
+	plainCid, err := decryptCid(aa.GetAAInstanceFromConfig(), store, cid, dec, out, restore)
+	if err := out.finish(err); err != nil {
+		return err
+	}
+	fmt.Fprintf(out.status, "Decrypted CID: %s\n", plainCid)
+	fmt.Fprintln(out.status, "Decrypted file matches the parent recorded in AA.")
+	if restore {
+		fmt.Fprintf(out.status, "Decrypted file is in the file store at %s\n", store.Path(plainCid))
 	}
-	inFileSize := fi.Size()
+	fmt.Fprintln(out.status, "Done.")
+	return nil
+}
 
-	outF, err := os.Create(outPath)
+// storedKeyDecrypter returns a decrypter using the key for the encrypted CID in keysDir.
+func storedKeyDecrypter(keysDir, cid string) (decrypter, error) {
+	key, err := util.ReadKeyFile(filepath.Join(keysDir, cid+".key"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no key for %s found in the key store, use --identity if it was encrypted for recipients", cid)
+	}
 	if err != nil {
-		return fmt.Errorf("error opening output file: %w", err)
+		return nil, err
 	}
-	defer outF.Close()
+	return keyDecrypter(key), nil
+}
 
-	header := make([]byte, secretstream.StreamHeaderBytes)
-	_, err = io.ReadFull(inF, header)
+// decryptCid decrypts the encrypted CID from the store to dst, and checks the result
+// against the parent recorded in AA. With restore, the decrypted file is also put
+// into the store. It returns the decrypted CID.
+func decryptCid(a *aa.AuthAttrInstance, store filestore.Store, cid string, dec decrypter, dst io.Writer, restore bool) (string, error) {
+	in, err := store.Get(cid)
 	if err != nil {
-		return fmt.Errorf("error reading from input file: %w", err)
+		return "", fmt.Errorf("error opening encrypted file: %w", err)
 	}
+	defer in.Close()
 
-	fmt.Println("Decrypting...")
-	dec, err := secretstream.NewDecryptor(key, header)
-	if err != nil {
-		return fmt.Errorf("error starting decryption: %w", err)
-	}
-
-	// File decryption logic is more complex than encryption
-	// Need to make sure final tag in stream matches when the file bytes end
-	// See example:
-	// https://doc.libsodium.org/secret-key_cryptography/secretstream#file-encryption-example-code
-
-	// Input buffer is 32 KiB (like io.Copy) plus the overhead added by the encryption
-	// process. This keeps things aligned with encrypt.go, so each read in this loop
-	// corresponds to the write made in the encryption loop.
-	buf := make([]byte, 32768+secretstream.StreamABytes)
-	var bytesRead int64 = secretstream.StreamHeaderBytes // Already read header
-	var n int
-	for {
-		n, err = inF.Read(buf)
-		if err == io.EOF {
-			return fmt.Errorf("assertion error: unexpected end of file")
-		}
+	var tmpF *os.File
+	if restore {
+		tmpF, err = os.CreateTemp(util.TempDir(), "decrypt_")
 		if err != nil {
-			os.Remove(outPath)
-			return fmt.Errorf("error reading input file: %w", err)
+			return "", fmt.Errorf("error creating temp file: %w", err)
 		}
+		defer os.Remove(tmpF.Name())
+		defer tmpF.Close()
+		dst = io.MultiWriter(dst, tmpF)
+	}
 
-		bytesRead += int64(n)
-		if bytesRead == inFileSize {
-			// Whole file has been read, the next .Read would return (0, io.EOF)
-			break
-		}
+	encCid, plainCid, err := decryptFile(dst, in, dec)
+	if err != nil {
+		return "", err
+	}
+	if encCid != cid {
+		return "", fmt.Errorf("stored file for %s has CID %s", cid, encCid)
+	}
+	if err := verifyParent(a, cid, plainCid); err != nil {
+		return "", err
+	}
 
-		plain, tag, err := dec.Pull(buf[:n])
-		if err != nil {
-			os.Remove(outPath)
-			return fmt.Errorf("error decrypting data: %w", err)
+	if restore {
+		if err := tmpF.Close(); err != nil {
+			return "", fmt.Errorf("error writing to temp file: %w", err)
 		}
-		if tag == secretstream.TagFinal {
-			// Got "end of stream" but file hasn't reached EOF
-			os.Remove(outPath)
-			return fmt.Errorf("encrypted stream ended before file did")
+		if _, err := store.Stat(plainCid); err == nil {
+			// Already there
+			return plainCid, nil
 		}
-
-		_, err = outF.Write(plain)
-		if err != nil {
-			os.Remove(outPath)
-			return fmt.Errorf("error writing to output file: %w", err)
+		if err := store.PutFile(plainCid, tmpF.Name()); err != nil {
+			return "", fmt.Errorf("error moving file: %w", err)
 		}
 	}
-	// Decrypt last message
-	// If it isn't tagged as final then the stream is truncated and shouldn't be accepted.
-	plain, tag, err := dec.Pull(buf[:n])
+	return plainCid, nil
+}
+
+// output is where the decrypted file is written: a file, stdout, or nowhere.
+type output struct {
+	io.Writer
+	f      *os.File // Set when writing to a file
+	path   string
+	status io.Writer
+}
+
+// openOutput opens the output for path, which can be empty to discard the decrypted
+// data, or - for stdout. Status messages are kept off stdout if the data is there.
+func openOutput(path string) (*output, error) {
+	switch path {
+	case "":
+		return &output{Writer: io.Discard, status: os.Stdout}, nil
+	case "-":
+		return &output{Writer: os.Stdout, status: os.Stderr}, nil
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		os.Remove(outPath)
-		return fmt.Errorf("error decrypting data: %w", err)
+		return nil, fmt.Errorf("error opening output file: %w", err)
 	}
-	if tag != secretstream.TagFinal {
-		os.Remove(outPath)
-		return fmt.Errorf("file ended early, encrypted stream truncated")
+	return &output{Writer: f, f: f, path: path, status: os.Stdout}, nil
+}
+
+// finish closes the output file, and removes it if decryption failed with err.
+// It returns err, or an error from closing.
+func (o *output) finish(err error) error {
+	if o.f == nil {
+		return err
+	}
+	if err == nil {
+		err = o.f.Close()
+	}
+	if err != nil {
+		o.f.Close()
+		os.Remove(o.path)
+	}
+	return err
+}
+
+func (o *output) Close() error {
+	if o.f == nil {
+		return nil
 	}
+	return o.f.Close()
+}
 
-	_, err = outF.Write(plain)
+// decryptFile decrypts src to dst, and returns the CIDs of the encrypted and
+// decrypted data.
+func decryptFile(dst io.Writer, src io.Reader, dec decrypter) (string, string, error) {
+	encHasher := util.NewCidHasher()
+	plainHasher := util.NewCidHasher()
+	err := decryptStream(io.MultiWriter(dst, plainHasher), io.TeeReader(src, encHasher), dec)
 	if err != nil {
-		os.Remove(outPath)
-		return fmt.Errorf("error writing to output file: %w", err)
+		return "", "", err
 	}
+	return encHasher.CID(), plainHasher.CID(), nil
+}
 
-	fmt.Println("Done.")
+// decryptStream decrypts src to dst. Data is written to dst before the whole stream
+// is checked, so it shouldn't be trusted if an error is returned.
+func decryptStream(dst io.Writer, src io.Reader, dec decrypter) error {
+	r, err := dec(src)
+	if err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("error decrypting file: %w", err)
+	}
+	return nil
+}
+
+// verifyParent checks that the plaintext CID is recorded in AA as the parent of the
+// encrypted CID, as "file encrypt" does.
+func verifyParent(a *aa.AuthAttrInstance, encCid, plainCid string) error {
+	rels, err := a.GetEffectiveRelationships(encCid)
+	if err != nil {
+		return fmt.Errorf("error getting relationships: %w", err)
+	}
+	parents := rels.Parents["encrypted"]
+	if len(parents) == 0 {
+		return fmt.Errorf("AA has no parent recorded for encrypted CID %s", encCid)
+	}
+	if !rels.Has("parents", "encrypted", plainCid) {
+		return fmt.Errorf("decrypted CID is not the parent recorded in AA: %s", strings.Join(parents, ", "))
+	}
 	return nil
 }
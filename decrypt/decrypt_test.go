@@ -0,0 +1,175 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/age"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/sstream"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+func encryptKey(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := sstream.NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func cidOf(b []byte) string {
+	h := util.NewCidHasher()
+	h.Write(b)
+	return h.CID()
+}
+
+func TestDecryptAndVerify(t *testing.T) {
+	_, a := aatest.Start(t)
+
+	key := make([]byte, sstream.KeySize)
+	rand.Read(key)
+	plain := make([]byte, sstream.ChunkSize+100)
+	rand.Read(plain)
+	cipher := encryptKey(t, key, plain)
+
+	var out bytes.Buffer
+	encCid, plainCid, err := decryptFile(&out, bytes.NewReader(cipher), keyDecrypter(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plain) {
+		t.Fatal("decrypted data doesn't match")
+	}
+	if encCid != cidOf(cipher) || plainCid != cidOf(plain) {
+		t.Fatal("wrong CIDs")
+	}
+
+	if err := verifyParent(a, encCid, plainCid); err == nil {
+		t.Fatal("verified without a recorded parent")
+	}
+	// Like "file encrypt"
+	if err := a.AddRelationship(plainCid, "children", "encrypted", encCid); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyParent(a, encCid, plainCid); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyParent(a, encCid, encCid); err == nil {
+		t.Fatal("verified the wrong plaintext CID")
+	}
+
+	_, _, err = decryptFile(io.Discard, bytes.NewReader(cipher[:sstream.ChunkSize]), keyDecrypter(key))
+	if err == nil {
+		t.Fatal("decrypted truncated file")
+	}
+}
+
+func TestDecryptCid(t *testing.T) {
+	_, a := aatest.Start(t)
+	store, err := filestore.New(t.TempDir(), filestore.LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysDir := t.TempDir()
+	t.Setenv("TMPDIR", t.TempDir())
+
+	key := make([]byte, sstream.KeySize)
+	rand.Read(key)
+	plain := make([]byte, 1000)
+	rand.Read(plain)
+	cipher := encryptKey(t, key, plain)
+	encCid, plainCid := cidOf(cipher), cidOf(plain)
+
+	// Store it like "file encrypt" does
+	if _, err := storedKeyDecrypter(keysDir, encCid); err == nil {
+		t.Fatal("found a key that wasn't stored")
+	}
+	if err := os.WriteFile(filepath.Join(keysDir, encCid+".key"), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := storedKeyDecrypter(keysDir, encCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(encCid, bytes.NewReader(cipher)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decryptCid(a, store, encCid, dec, io.Discard, false); err == nil {
+		t.Fatal("decrypted without a parent in AA")
+	}
+	if err := a.AddRelationship(plainCid, "children", "encrypted", encCid); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	got, err := decryptCid(a, store, encCid, dec, &out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != plainCid || !bytes.Equal(out.Bytes(), plain) {
+		t.Fatal("decrypted file doesn't match")
+	}
+	if _, err := store.Stat(plainCid); err == nil {
+		t.Fatal("file was restored without restore")
+	}
+
+	if _, err := decryptCid(a, store, encCid, dec, io.Discard, true); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.Get(plainCid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := io.ReadAll(r); !bytes.Equal(b, plain) {
+		t.Fatal("restored file doesn't match")
+	}
+	// Restoring again is fine
+	if _, err := decryptCid(a, store, encCid, dec, io.Discard, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecryptIdentity(t *testing.T) {
+	partner, _ := age.GenerateIdentity()
+	other, _ := age.GenerateIdentity()
+	plain := make([]byte, 100_000)
+	rand.Read(plain)
+
+	var cipher bytes.Buffer
+	w, err := age.Encrypt(&cipher, other.Recipient(), partner.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	_, plainCid, err := decryptFile(&out, bytes.NewReader(cipher.Bytes()), identityDecrypter([]*age.Identity{partner}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainCid != cidOf(plain) || !bytes.Equal(out.Bytes(), plain) {
+		t.Fatal("decrypted file doesn't match")
+	}
+
+	wrong, _ := age.GenerateIdentity()
+	_, _, err = decryptFile(io.Discard, bytes.NewReader(cipher.Bytes()), identityDecrypter([]*age.Identity{wrong}))
+	if err == nil {
+		t.Fatal("decrypted with the wrong identity")
+	}
+}
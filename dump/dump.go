@@ -0,0 +1,288 @@
+package dump
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	projectId string
+	cidsFrom  string
+	attrList  string
+	decrypt   bool
+	format    string
+	outPath   string
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	fs.StringVar(&projectId, "project", "", "dump all CIDs with this project_id")
+	fs.StringVar(&cidsFrom, "cids-from", "", "dump the CIDs listed in this file (- for stdin)")
+	fs.StringVar(&attrList, "attrs", "", "comma-separated attributes to use as columns, defaults to every attribute found")
+	fs.BoolVar(&decrypt, "decrypt", false, "decrypt values using keys from the enc_keys directory, instead of leaving them out")
+	fs.StringVar(&format, "format", "csv", "output format: csv or jsonl")
+	fs.StringVar(&outPath, "o", "", "(optional) path to output file, defaults to stdout")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	if (projectId == "") == (cidsFrom == "") {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nprovide exactly one of --project and --cids-from")
+	}
+	if format != "csv" && format != "jsonl" {
+		return fmt.Errorf("--format must be csv or jsonl")
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("no arguments expected, use --project or --cids-from")
+	}
+
+	var cids []string
+	if projectId != "" {
+		cids, err = aa.IndexMatchQuery("project_id", projectId, "str")
+		if err != nil {
+			return fmt.Errorf("error querying index: %w", err)
+		}
+		slices.Sort(cids)
+	} else {
+		cids, err = util.ReadCIDList(cidsFrom)
+		if err != nil {
+			return fmt.Errorf("error reading CIDs file: %w", err)
+		}
+	}
+	if len(cids) == 0 {
+		return fmt.Errorf("no CIDs to dump")
+	}
+
+	var attrs []string
+	if attrList != "" {
+		for _, a := range strings.Split(attrList, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				attrs = append(attrs, a)
+			}
+		}
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer out.Close()
+	}
+
+	d := &dumper{
+		aa:    aa.GetAAInstanceFromConfig(),
+		attrs: attrs,
+	}
+	if decrypt {
+		d.encKey = util.ReadEncKey
+	}
+	if format == "csv" {
+		err = d.writeCSV(out, cids)
+	} else {
+		err = d.writeJSONL(out, cids)
+	}
+	if err != nil {
+		return err
+	}
+	if outPath != "" {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("error writing output file: %w", err)
+		}
+	}
+
+	if d.encrypted > 0 {
+		if decrypt {
+			fmt.Fprintf(os.Stderr, "%d encrypted values had no key and were left out.\n", d.encrypted)
+		} else {
+			fmt.Fprintf(os.Stderr, "%d encrypted values were left out, use --decrypt to include them.\n", d.encrypted)
+		}
+	}
+	if d.failed > 0 {
+		return fmt.Errorf("failed to get attributes for %d of %d CIDs, they were left out", d.failed, len(cids))
+	}
+	return nil
+}
+
+// encryptedValue replaces encrypted values that aren't decrypted, like in "attr get".
+const encryptedValue = "*ENCRYPTED*"
+
+type dumper struct {
+	aa    *aa.AuthAttrInstance
+	attrs []string // Empty for all
+	// encKey returns the key for an encrypted attribute, or nil if there isn't one.
+	// If encKey is nil, encrypted values are never decrypted.
+	encKey func(cid, attr string) ([]byte, error)
+
+	encrypted int // Encrypted values left out
+	failed    int // CIDs that couldn't be retrieved
+}
+
+// each calls fn with the plain values of each CID, in order. CIDs that fail are
+// reported on stderr and skipped.
+func (d *dumper) each(cids []string, fn func(cid string, values map[string]any) error) error {
+	return d.aa.StreamAttestationsMany(context.Background(), cids, d.attrs, func(r *aa.BatchResult) error {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "error getting attributes for %s: %v\n", r.CID, r.Err)
+			d.failed++
+			return nil
+		}
+		values, err := d.values(r.CID, r.Attestations)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting attributes for %s: %v\n", r.CID, err)
+			d.failed++
+			return nil
+		}
+		return fn(r.CID, values)
+	})
+}
+
+// values returns the values of the attestations, decrypting them if possible.
+func (d *dumper) values(cid string, atts map[string]*aa.AttEntry) (map[string]any, error) {
+	values := make(map[string]any, len(atts))
+	for attr, ae := range atts {
+		if !ae.Attestation.Encrypted {
+			values[attr] = ae.Attestation.Value
+			continue
+		}
+		var key []byte
+		if d.encKey != nil {
+			var err error
+			key, err = d.encKey(cid, attr)
+			if err != nil {
+				return nil, fmt.Errorf("error reading key for %s: %w", attr, err)
+			}
+		}
+		if key == nil {
+			values[attr] = encryptedValue
+			d.encrypted++
+			continue
+		}
+		ae, err := d.aa.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: key})
+		if errors.Is(err, aa.ErrBadKey) {
+			return nil, fmt.Errorf("stored key for %s is wrong", attr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting %s: %w", attr, err)
+		}
+		values[attr] = ae.Attestation.Value
+	}
+	return values, nil
+}
+
+// writeJSONL writes one JSON object per CID, with the CID under "cid" and the
+// attributes under "attributes".
+func (d *dumper) writeJSONL(w io.Writer, cids []string) error {
+	enc := json.NewEncoder(w)
+	return d.each(cids, func(cid string, values map[string]any) error {
+		line := struct {
+			CID        string         `json:"cid"`
+			Attributes map[string]any `json:"attributes"`
+		}{cid, values}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		return nil
+	})
+}
+
+// writeCSV writes a table with a row per CID and a column per attribute, after the
+// first column of CIDs. Strings are written as is, and other values as JSON.
+//
+// If no attributes were given, all rows are held in memory until every attribute
+// name is known.
+func (d *dumper) writeCSV(w io.Writer, cids []string) error {
+	cw := csv.NewWriter(w)
+	columns := d.attrs
+
+	type row struct {
+		cid    string
+		values map[string]any
+	}
+	var rows []row
+	writeRow := func(r row) error {
+		record := make([]string, len(columns)+1)
+		record[0] = r.cid
+		for i, attr := range columns {
+			record[i+1] = cell(r.values[attr])
+		}
+		return cw.Write(record)
+	}
+
+	if len(columns) > 0 {
+		if err := cw.Write(append([]string{"cid"}, columns...)); err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+	}
+	err := d.each(cids, func(cid string, values map[string]any) error {
+		if len(columns) == 0 {
+			rows = append(rows, row{cid, values})
+			return nil
+		}
+		if err := writeRow(row{cid, values}); err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(columns) == 0 {
+		seen := make(map[string]bool)
+		for _, r := range rows {
+			for attr := range r.values {
+				if !seen[attr] {
+					seen[attr] = true
+					columns = append(columns, attr)
+				}
+			}
+		}
+		slices.Sort(columns)
+		if err := cw.Write(append([]string{"cid"}, columns...)); err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		for _, r := range rows {
+			if err := writeRow(r); err != nil {
+				return fmt.Errorf("error writing output: %w", err)
+			}
+		}
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+// cell formats a value for CSV. Missing values are empty.
+func cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,63 @@
+package dump
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+)
+
+func TestDump(t *testing.T) {
+	_, a := aatest.Start(t)
+	key := make([]byte, 32)
+	err := a.SetAttestations(cid1, false, []aa.PostKV{
+		{Key: "name", Value: "one, with comma"},
+		{Key: "tags", Value: []string{"a", "b"}},
+		{Key: "secret", Value: "shh", EncKey: key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetAttestations(cid2, false, []aa.PostKV{{Key: "name", Value: "two"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	d := &dumper{aa: a}
+	if err := d.writeCSV(&buf, []string{cid1, cid2}); err != nil {
+		t.Fatal(err)
+	}
+	want := `cid,name,secret,tags
+` + cid1 + `,"one, with comma",*ENCRYPTED*,"[""a"",""b""]"
+` + cid2 + `,two,,
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if d.encrypted != 1 {
+		t.Errorf("got %d encrypted values, want 1", d.encrypted)
+	}
+
+	buf.Reset()
+	d = &dumper{
+		aa:     a,
+		attrs:  []string{"secret", "missing"},
+		encKey: func(cid, attr string) ([]byte, error) { return key, nil },
+	}
+	if err := d.writeJSONL(&buf, []string{cid1, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"}); err != nil {
+		t.Fatal(err)
+	}
+	want = `{"cid":"` + cid1 + `","attributes":{"secret":"shh"}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if d.failed != 1 {
+		t.Errorf("got %d failed CIDs, want 1", d.failed)
+	}
+}
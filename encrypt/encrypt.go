@@ -2,142 +2,148 @@ package encrypt
 
 import (
 	"crypto/rand"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
-	"github.com/openziti/secretstream"
 	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/age"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/sstream"
 	"github.com/starlinglab/integrity-v2/util"
 )
 
+var recipients []*age.Recipient
+
 func Run(args []string) error {
-	if len(args) != 1 ||
-		(len(args) > 0 && (args[0] == "--help" || args[0] == "help" || args[0] == "-h")) {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fs.Func("recipient", "age public key (age1...) to encrypt the file for, instead of a stored key. Can be repeated", func(s string) error {
+		r, err := age.ParseRecipient(s)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, r)
+		return nil
+	})
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if fs.NArg() != 1 {
 		return fmt.Errorf("just pass a single CID to encrypt")
 	}
-	cid := args[0]
 
+	if _, err := EncryptCid(fs.Arg(0), recipients); err != nil {
+		return err
+	}
+	fmt.Println("Done.")
+	return nil
+}
+
+// EncryptCid encrypts the stored file for the CID, for the recipients if there are
+// any, or with a new key saved in the key store. The encrypted file is put in the
+// file store and logged to AA, and its CID is returned.
+func EncryptCid(cid string, recipients []*age.Recipient) (string, error) {
 	conf := config.GetConfig()
 
-	cidPath := filepath.Join(conf.Dirs.Files, cid)
-	_, err := os.Stat(cidPath)
+	store, err := filestore.FromConfig(conf)
 	if err != nil {
-		return fmt.Errorf("error finding CID file: %w", err)
+		return "", err
 	}
-
-	key := make([]byte, 32)
-	_, err = rand.Read(key)
-	if err != nil {
-		return fmt.Errorf("error reading random data for key: %w", err)
+	if _, err := store.Stat(cid); err != nil {
+		return "", fmt.Errorf("error finding CID file: %w", err)
 	}
 
-	inF, err := os.Open(cidPath)
+	inF, err := store.Get(cid)
 	if err != nil {
-		return fmt.Errorf("error opening CID file: %w", err)
+		return "", fmt.Errorf("error opening CID file: %w", err)
 	}
 	defer inF.Close()
 
-	fi, err := inF.Stat()
-	if err != nil {
-		return fmt.Errorf("error statting CID file: %w", err)
-	}
-	inFileSize := fi.Size()
-
 	tmpF, err := os.CreateTemp(util.TempDir(), "encrypt_")
 	if err != nil {
-		return fmt.Errorf("error creating temp file: %w", err)
+		return "", fmt.Errorf("error creating temp file: %w", err)
 	}
 	defer tmpF.Close()
 	defer os.Remove(tmpF.Name())
 
 	fmt.Println("Encrypting...")
-	enc, header, err := secretstream.NewEncryptor(key)
-	if err != nil {
-		return fmt.Errorf("error starting encryption: %w", err)
-	}
-
-	_, err = tmpF.Write(header)
-	if err != nil {
-		return fmt.Errorf("error writing to temp file: %w", err)
-	}
-
-	buf := make([]byte, 32768) // 32 KiB, same as io.Copy
-	var bytesRead int64
-	var n int
-	for {
-		n, err = inF.Read(buf)
-		if err == io.EOF {
-			return fmt.Errorf("assertion error: unexpected end of file")
-		}
+	// The CID of the encrypted file is calculated as it's written
+	hasher := util.NewCidHasher()
+	var w io.WriteCloser
+	var key []byte
+	if len(recipients) > 0 {
+		// Only the recipients can decrypt it, so there's no key to keep
+		w, err = age.Encrypt(io.MultiWriter(tmpF, hasher), recipients...)
+	} else {
+		key = make([]byte, sstream.KeySize)
+		_, err = rand.Read(key)
 		if err != nil {
-			return fmt.Errorf("error reading CID file: %w", err)
-		}
-
-		bytesRead += int64(n)
-		if bytesRead == inFileSize {
-			// Whole file has been read, the next .Read would return (0, io.EOF)
-			break
-		}
-
-		cipher, err := enc.Push(buf[:n], secretstream.TagMessage)
-		if err != nil {
-			return fmt.Errorf("error encrypting data: %w", err)
-		}
-		_, err = tmpF.Write(cipher)
-		if err != nil {
-			return fmt.Errorf("error writing to temp file: %w", err)
+			return "", fmt.Errorf("error reading random data for key: %w", err)
 		}
+		w, err = sstream.NewWriter(io.MultiWriter(tmpF, hasher), key)
 	}
-	// The last message in the stream, the last chunk of the file
-	cipher, err := enc.Push(buf[:n], secretstream.TagFinal)
 	if err != nil {
-		return fmt.Errorf("error encrypting data: %w", err)
+		return "", fmt.Errorf("error writing to temp file: %w", err)
 	}
-	_, err = tmpF.Write(cipher)
-	if err != nil {
-		return fmt.Errorf("error writing to temp file: %w", err)
+	if _, err := io.Copy(w, inF); err != nil {
+		return "", fmt.Errorf("error encrypting CID file: %w", err)
 	}
-	fmt.Println("Done. Moving on to cleanup...")
-
-	// Calc CID
-	_, err = tmpF.Seek(0, io.SeekStart)
-	if err != nil {
-		return fmt.Errorf("error seeking in temp file: %w", err)
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("error writing to temp file: %w", err)
 	}
-	encCid, err := util.CalculateFileCid(tmpF)
-	if err != nil {
-		return fmt.Errorf("error calculating output CID: %w", err)
+	if err := tmpF.Close(); err != nil {
+		return "", fmt.Errorf("error writing to temp file: %w", err)
 	}
+	encCid := hasher.CID()
+	fmt.Println("Done. Moving on to cleanup...")
 
 	// Write key and output file
 
-	keyPath := filepath.Join(conf.Dirs.EncKeys, encCid+".key")
-	err = os.WriteFile(keyPath, key, 0600)
-	if err != nil {
-		return fmt.Errorf("error saving key: %w", err)
+	if key != nil {
+		keyPath := filepath.Join(conf.Dirs.EncKeys, encCid+".key")
+		err = util.WriteKeyFile(keyPath, key)
+		if err != nil {
+			return "", fmt.Errorf("error saving key: %w", err)
+		}
+		fmt.Printf("Saved encryption key to %s\n", keyPath)
 	}
-	fmt.Printf("Saved encryption key to %s\n", keyPath)
 
-	outPath := filepath.Join(conf.Dirs.Files, encCid)
-	err = util.MoveFile(tmpF.Name(), outPath)
+	err = store.PutFile(encCid, tmpF.Name())
 	if err != nil {
-		return fmt.Errorf("error moving file: %w", err)
+		return "", fmt.Errorf("error moving file: %w", err)
 	}
-	fmt.Printf("Saved encrypted file to %s\n", outPath)
+	fmt.Printf("Saved encrypted file to %s\n", store.Path(encCid))
 
 	// Log to AA
 	err = aa.AddRelationship(cid, "children", "encrypted", encCid)
 	if err != nil {
-		return fmt.Errorf("error adding encryption relationship to AuthAttr: %w", err)
+		return "", fmt.Errorf("error adding encryption relationship to AuthAttr: %w", err)
 	}
-	err = aa.SetAttestations(encCid, false, []aa.PostKV{{Key: "encryption_type", Value: "secretstream"}})
+	err = aa.SetAttestations(encCid, false, encryptionAtts(recipients))
 	if err != nil {
-		return fmt.Errorf("error adding encryption metadata to AuthAttr: %w", err)
+		return "", fmt.Errorf("error adding encryption metadata to AuthAttr: %w", err)
 	}
 
-	fmt.Println("Done.")
-	return nil
+	return encCid, nil
+}
+
+// encryptionAtts returns the attributes that describe how a file was encrypted.
+func encryptionAtts(recipients []*age.Recipient) []aa.PostKV {
+	if len(recipients) == 0 {
+		return []aa.PostKV{{Key: "encryption_type", Value: "secretstream"}}
+	}
+	fingerprints := make([]string, len(recipients))
+	for i, r := range recipients {
+		fingerprints[i] = r.Fingerprint()
+	}
+	return []aa.PostKV{
+		{Key: "encryption_type", Value: "age"},
+		{Key: "encryption_recipients", Value: fingerprints},
+	}
 }
@@ -0,0 +1,279 @@
+package evidence
+
+import (
+	"archive/zip"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	output      string
+	includeKeys bool
+)
+
+func Run(args []string) error {
+	if len(args) > 0 && args[0] == "verify" {
+		return runVerify(args[1:])
+	}
+
+	fs := flag.NewFlagSet("package", flag.ContinueOnError)
+	fs.StringVar(&output, "o", "", "output path for the ZIP file")
+	fs.BoolVar(&includeKeys, "include-keys", false, "include decryption keys for encrypted attributes, and their decrypted attestations")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: package [flags] <cid>\n       package verify [flags] <zip>\n\nFlags:")
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	if output == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nmust provide output path with -o")
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single CID to work with")
+	}
+	cid := fs.Arg(0)
+
+	store, err := filestore.FromConfig(config.GetConfig())
+	if err != nil {
+		return err
+	}
+	p := &packager{aa: aa.GetAAInstanceFromConfig(), store: store}
+	if includeKeys {
+		p.encKey = util.ReadEncKey
+	}
+	items, err := p.collect(cid)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer f.Close()
+	if err := writeZip(f, cid, items); err != nil {
+		os.Remove(output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	fmt.Printf("Wrote %d items to %s\n", len(items), output)
+	return nil
+}
+
+// Kinds of items in a package
+const (
+	KindFile        = "file"        // The original file
+	KindAttestation = "attestation" // DAG-CBOR attestation as AA sent it
+	KindVC          = "vc"          // Verifiable Credential of an attestation
+	KindOTS         = "ots"         // OpenTimestamps proof of an attestation
+	KindKey         = "key"         // Decryption key of an attestation
+	KindDerivative  = "derivative"  // File derived from the original, like a C2PA export
+)
+
+// ManifestPath is where the manifest is stored in the package.
+const ManifestPath = "manifest.json"
+
+// Manifest lists everything in a package.
+type Manifest struct {
+	Version int    `json:"version"`
+	CID     string `json:"cid"`
+	Items   []Item `json:"items"`
+}
+
+// Item is a single file in the package.
+type Item struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+	// Attr is the attribute, for items related to an attestation
+	Attr string `json:"attr,omitempty"`
+	// CID is the CID of file and derivative items
+	CID string `json:"cid,omitempty"`
+	// Encrypted is set for attestations and VCs that were left encrypted
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+}
+
+// item is an Item to be written, before its size and hash are known.
+type item struct {
+	Item
+	data []byte
+	// open is used instead of data for files, which may be large
+	open func() (io.ReadCloser, error)
+}
+
+type packager struct {
+	aa    *aa.AuthAttrInstance
+	store filestore.Store
+	// encKey returns the key to decrypt an attribute with, or nil to leave it
+	// encrypted. If encKey is nil, nothing is decrypted.
+	encKey func(cid, attr string) ([]byte, error)
+}
+
+// attrPath turns an attribute name into a safe file name.
+func attrPath(dir, attr, ext string) string {
+	return dir + "/" + url.PathEscape(attr) + ext
+}
+
+// collect retrieves everything to include in the package for the CID.
+func (p *packager) collect(cid string) ([]*item, error) {
+	if _, err := p.store.Stat(cid); err != nil {
+		return nil, fmt.Errorf("error finding CID file: %w", err)
+	}
+	items := []*item{{
+		Item: Item{Path: "file/" + cid, Kind: KindFile, CID: cid},
+		open: func() (io.ReadCloser, error) { return p.store.Get(cid) },
+	}}
+
+	atts, err := p.aa.GetAttestations(cid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attestations: %w", err)
+	}
+	attrs := make([]string, 0, len(atts))
+	for attr := range atts {
+		attrs = append(attrs, attr)
+	}
+	slices.Sort(attrs)
+
+	for _, attr := range attrs {
+		var key []byte
+		if atts[attr].Attestation.Encrypted && p.encKey != nil {
+			key, err = p.encKey(cid, attr)
+			if err != nil {
+				return nil, fmt.Errorf("error reading key for %s: %w", attr, err)
+			}
+		}
+		opts := aa.GetAttOpts{LeaveEncrypted: true}
+		if key != nil {
+			opts = aa.GetAttOpts{EncKey: key}
+			items = append(items, &item{
+				Item: Item{Path: attrPath("keys", attr, ".key"), Kind: KindKey, Attr: attr},
+				data: key,
+			})
+		}
+		encrypted := atts[attr].Attestation.Encrypted && key == nil
+
+		raw, err := p.aa.GetAttestationRaw(cid, attr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error getting attestation for %s: %w", attr, err)
+		}
+		items = append(items, &item{
+			Item: Item{Path: attrPath("attestations", attr, ".cbor"), Kind: KindAttestation, Attr: attr, Encrypted: encrypted},
+			data: raw,
+		})
+
+		opts.Format = "vc"
+		vc, err := p.aa.GetAttestationRaw(cid, attr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error getting VC for %s: %w", attr, err)
+		}
+		items = append(items, &item{
+			Item: Item{Path: attrPath("vcs", attr, ".json"), Kind: KindVC, Attr: attr, Encrypted: encrypted},
+			data: vc,
+		})
+
+		if proof := atts[attr].Timestamp.OTS.Proof; len(proof) > 0 {
+			items = append(items, &item{
+				Item: Item{Path: attrPath("ots", attr, ".ots"), Kind: KindOTS, Attr: attr},
+				data: proof,
+			})
+		}
+	}
+
+	rels, err := aa.RelationshipsFromAttestations(atts)
+	if err != nil {
+		return nil, err
+	}
+	for _, derived := range rels.Children["derived"] {
+		if _, err := p.store.Stat(derived); errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "warning: derived file %s is not stored here, leaving it out\n", derived)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error finding derived file %s: %w", derived, err)
+		}
+		items = append(items, &item{
+			Item: Item{Path: "derivatives/" + derived, Kind: KindDerivative, CID: derived},
+			open: func() (io.ReadCloser, error) { return p.store.Get(derived) },
+		})
+	}
+	return items, nil
+}
+
+// zipTime is the modification time of every file in the ZIP, so that the same
+// contents always result in the same ZIP.
+var zipTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeZip writes the items in order of path, followed by the manifest.
+func writeZip(w io.Writer, cid string, items []*item) error {
+	slices.SortFunc(items, func(a, b *item) int { return cmp.Compare(a.Path, b.Path) })
+
+	zw := zip.NewWriter(w)
+	manifest := Manifest{Version: 1, CID: cid, Items: make([]Item, 0, len(items))}
+	for _, it := range items {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: it.Path, Method: zip.Deflate, Modified: zipTime})
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", it.Path, err)
+		}
+		h := sha256.New()
+		n, err := it.copyTo(io.MultiWriter(fw, h))
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", it.Path, err)
+		}
+		it.Size = n
+		it.SHA256 = hex.EncodeToString(h.Sum(nil))
+		manifest.Items = append(manifest.Items, it.Item)
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: ManifestPath, Method: zip.Deflate, Modified: zipTime})
+	if err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if _, err := fw.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("error writing ZIP: %w", err)
+	}
+	return nil
+}
+
+// copyTo writes the contents of the item.
+func (it *item) copyTo(w io.Writer) (int64, error) {
+	if it.open == nil {
+		n, err := w.Write(it.data)
+		return int64(n), err
+	}
+	rc, err := it.open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(w, rc)
+}
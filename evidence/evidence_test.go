@@ -0,0 +1,172 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+func TestPackage(t *testing.T) {
+	s, a := aatest.Start(t)
+	keys := []*aa.TrustedKey{s.TrustedKey()}
+	store, err := filestore.New(t.TempDir(), filestore.LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(content string) string {
+		t.Helper()
+		cid, err := util.CalculateFileCid(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(cid, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		return cid
+	}
+	cid := put("original")
+	derived := put("derived")
+
+	key := make([]byte, 32)
+	err = a.SetAttestations(cid, false, []aa.PostKV{
+		{Key: "name", Value: "hello"},
+		{Key: "secret", Value: "shh", EncKey: key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddRelationship(cid, "children", "derived", derived); err != nil {
+		t.Fatal(err)
+	}
+
+	// edit can change items before they're written, with the manifest matching them
+	buildEdited := func(p *packager, edit func(it *item)) []byte {
+		t.Helper()
+		items, err := p.collect(cid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, it := range items {
+			edit(it)
+		}
+		var buf bytes.Buffer
+		if err := writeZip(&buf, cid, items); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	build := func(p *packager) []byte {
+		t.Helper()
+		return buildEdited(p, func(*item) {})
+	}
+	verify := func(data []byte) string {
+		t.Helper()
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := verifyPackage(zr, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		for _, r := range results {
+			sb.WriteString(r.String() + "\n")
+		}
+		return sb.String()
+	}
+
+	p := &packager{aa: a, store: store}
+	data := build(p)
+	if !bytes.Equal(data, build(p)) {
+		t.Error("package should be deterministic")
+	}
+	out := verify(data)
+	for _, want := range []string{
+		"PASS  attestations/name.cbor  signed by aatest\n",
+		"SKIP  attestations/secret.cbor  encrypted, signature can't be checked\n",
+		"PASS  file/" + cid + "  CID matches\n",
+		"PASS  derivatives/" + derived + "  listed as derived in the children attestation\n",
+		"PASS  vcs/name.json  matches the attestation\n",
+		"SKIP  vcs/secret.json  attestation is encrypted\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in results:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "FAIL") || strings.Contains(out, "keys/") {
+		t.Errorf("unexpected results:\n%s", out)
+	}
+
+	// With keys
+	p.encKey = func(cid, attr string) ([]byte, error) { return key, nil }
+	out = verify(build(p))
+	for _, want := range []string{
+		"PASS  attestations/secret.cbor  signed by aatest\n",
+		"PASS  vcs/secret.json  matches the attestation\n",
+		"SKIP  keys/secret.key  keys can't be checked offline\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in results:\n%s", want, out)
+		}
+	}
+
+	// Edited VC, with the manifest updated to match
+	out = verify(buildEdited(p, func(it *item) {
+		if it.Kind == KindVC && it.Attr == "name" {
+			it.data = bytes.Replace(it.data, []byte(`"hello"`), []byte(`"goodbye"`), 1)
+		}
+	}))
+	if !strings.Contains(out, "FAIL  vcs/name.json  VC does not match attestation: value is different\n") {
+		t.Errorf("edited VC not detected:\n%s", out)
+	}
+
+	// Edited attestation, hidden by marking it encrypted in the manifest
+	out = verify(buildEdited(p, func(it *item) {
+		if it.Attr == "name" && (it.Kind == KindAttestation || it.Kind == KindVC) {
+			it.data = bytes.Replace(it.data, []byte("hello"), []byte("bye!!"), 1)
+			it.Encrypted = true
+		}
+	}))
+	if !strings.Contains(out, "FAIL  attestations/name.cbor  listed as encrypted in the manifest, but the attestation isn't\n") {
+		t.Errorf("attestation marked encrypted not detected:\n%s", out)
+	}
+
+	// Tampered file
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		b, err := readZipFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == "file/"+cid {
+			b = []byte("tampered")
+		}
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data = buf.Bytes()
+	out = verify(data)
+	if !strings.Contains(out, "FAIL  file/"+cid+"  doesn't match the hash in the manifest") {
+		t.Errorf("tampering not detected:\n%s", out)
+	}
+}
@@ -0,0 +1,302 @@
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var keysPath string
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("package verify", flag.ContinueOnError)
+	fs.StringVar(&keysPath, "trusted-keys", "", "(optional) path to trusted keys file, instead of the one in the config")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single package ZIP file to verify")
+	}
+
+	var keys []*aa.TrustedKey
+	if keysPath != "" {
+		keys, err = aa.LoadTrustedKeys(keysPath)
+	} else {
+		keys, err = aa.TrustedKeysFromConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("error loading trusted keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no trusted keys found, nothing can be verified")
+	}
+
+	zr, err := zip.OpenReader(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error opening package: %w", err)
+	}
+	defer zr.Close()
+
+	results, err := verifyPackage(&zr.Reader, keys)
+	if err != nil {
+		return err
+	}
+	failed, skipped := 0, 0
+	for _, r := range results {
+		fmt.Println(r)
+		switch r.status {
+		case statusFail:
+			failed++
+		case statusSkip:
+			skipped++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("\n%d of %d checks failed", failed, len(results))
+	}
+	if skipped > 0 {
+		fmt.Printf("\nWARNING: %d of %d checks were skipped, those items are NOT verified\n", skipped, len(results))
+	}
+	return nil
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+// result is the outcome of checking a single item.
+type result struct {
+	path   string
+	status string
+	detail string
+}
+
+func (r *result) String() string {
+	return fmt.Sprintf("%s  %s  %s", r.status, r.path, r.detail)
+}
+
+// verifyPackage checks every item in the package against the manifest, and then
+// checks what the items claim: file CIDs, attestation signatures, and that VCs, OTS
+// proofs and derivatives match the attestations.
+//
+// An error is only returned if the manifest can't be read.
+func verifyPackage(zr *zip.Reader, keys []*aa.TrustedKey) ([]*result, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files[ManifestPath]
+	if !ok {
+		return nil, fmt.Errorf("package has no %s", ManifestPath)
+	}
+	data, err := readZipFile(mf)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error decoding manifest: %w", err)
+	}
+	if manifest.Version != 1 {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+
+	var results []*result
+	fail := func(path, format string, a ...any) {
+		results = append(results, &result{path, statusFail, fmt.Sprintf(format, a...)})
+	}
+
+	// Attestations are checked first, as other items are checked against them
+	verified := make(map[string]*aa.AttEntry)
+	skipped := make(map[string]bool)
+	items := slices.Clone(manifest.Items)
+	slices.SortStableFunc(items, func(a, b Item) int {
+		if a.Kind == KindAttestation && b.Kind != KindAttestation {
+			return -1
+		}
+		if a.Kind != KindAttestation && b.Kind == KindAttestation {
+			return 1
+		}
+		return 0
+	})
+
+	listed := map[string]bool{ManifestPath: true}
+	for _, it := range items {
+		listed[it.Path] = true
+		f, ok := files[it.Path]
+		if !ok {
+			fail(it.Path, "missing from package")
+			continue
+		}
+
+		// Every item must match the manifest, whatever it is
+		rc, err := f.Open()
+		if err != nil {
+			fail(it.Path, "error reading: %v", err)
+			continue
+		}
+		h := sha256.New()
+		var buf bytes.Buffer
+		var w io.Writer = h
+		if it.Kind != KindFile && it.Kind != KindDerivative {
+			// Small enough to keep
+			w = io.MultiWriter(h, &buf)
+		}
+		n, err := io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			fail(it.Path, "error reading: %v", err)
+			continue
+		}
+		if n != it.Size || hex.EncodeToString(h.Sum(nil)) != it.SHA256 {
+			fail(it.Path, "doesn't match the hash in the manifest")
+			continue
+		}
+
+		switch it.Kind {
+		case KindFile, KindDerivative:
+			if it.Kind == KindFile && it.CID != manifest.CID {
+				fail(it.Path, "file is not the one the package is for")
+				continue
+			}
+			results = append(results, checkFileCID(f, it))
+			if it.Kind == KindDerivative {
+				results = append(results, checkDerivative(it, verified["children"]))
+			}
+		case KindAttestation:
+			r, ae := checkAttestation(it, buf.Bytes(), manifest.CID, keys)
+			if ae != nil {
+				verified[it.Attr] = ae
+			}
+			if r.status == statusSkip {
+				skipped[it.Attr] = true
+			}
+			results = append(results, r)
+		case KindOTS:
+			ae, ok := verified[it.Attr]
+			switch {
+			case skipped[it.Attr]:
+				results = append(results, &result{it.Path, statusSkip, "attestation is encrypted"})
+			case !ok:
+				fail(it.Path, "no verified attestation for %s", it.Attr)
+			case !bytes.Equal(ae.Timestamp.OTS.Proof, buf.Bytes()):
+				fail(it.Path, "doesn't match the proof in the attestation")
+			default:
+				results = append(results, &result{it.Path, statusPass, "matches the attestation"})
+			}
+		case KindVC:
+			ae, ok := verified[it.Attr]
+			switch {
+			case skipped[it.Attr]:
+				results = append(results, &result{it.Path, statusSkip, "attestation is encrypted"})
+			case !ok:
+				fail(it.Path, "no verified attestation for %s", it.Attr)
+			default:
+				if err := aa.CheckVC(buf.Bytes(), ae); err != nil {
+					fail(it.Path, "%v", err)
+				} else {
+					results = append(results, &result{it.Path, statusPass, "matches the attestation"})
+				}
+			}
+		case KindKey:
+			// Only the decrypted attestation is packaged, so there's nothing to check
+			// the key against offline
+			results = append(results, &result{it.Path, statusSkip, "keys can't be checked offline"})
+		default:
+			fail(it.Path, "unknown kind %q", it.Kind)
+		}
+	}
+
+	for _, f := range zr.File {
+		if !listed[f.Name] {
+			fail(f.Name, "not listed in the manifest")
+		}
+	}
+	return results, nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// checkFileCID recalculates the CID of a file item.
+func checkFileCID(f *zip.File, it Item) *result {
+	rc, err := f.Open()
+	if err != nil {
+		return &result{it.Path, statusFail, fmt.Sprintf("error reading: %v", err)}
+	}
+	defer rc.Close()
+	cid, err := util.CalculateFileCid(rc)
+	if err != nil {
+		return &result{it.Path, statusFail, fmt.Sprintf("error calculating CID: %v", err)}
+	}
+	if cid != it.CID {
+		return &result{it.Path, statusFail, fmt.Sprintf("CID is %s, not %s", cid, it.CID)}
+	}
+	return &result{it.Path, statusPass, "CID matches"}
+}
+
+// checkDerivative checks the derivative is listed in the verified children attestation.
+func checkDerivative(it Item, children *aa.AttEntry) *result {
+	if children == nil {
+		return &result{it.Path, statusFail, "no verified children attestation to check derivative against"}
+	}
+	rels, err := aa.RelationshipsFromAttestations(map[string]*aa.AttEntry{"children": children})
+	if err != nil {
+		return &result{it.Path, statusFail, err.Error()}
+	}
+	if !rels.Has("children", "derived", it.CID) {
+		return &result{it.Path, statusFail, "not a derivative according to the children attestation"}
+	}
+	return &result{it.Path, statusPass, "listed as derived in the children attestation"}
+}
+
+// checkAttestation verifies the signature of an attestation item, and that it's for
+// the package's CID. The attestation is returned if it was verified.
+func checkAttestation(it Item, data []byte, cid string, keys []*aa.TrustedKey) (*result, *aa.AttEntry) {
+	var ae aa.AttEntry
+	if err := cbor.Unmarshal(data, &ae); err != nil {
+		return &result{it.Path, statusFail, fmt.Sprintf("error decoding: %v", err)}, nil
+	}
+	if len(ae.Attestation.CID) < 2 || ae.Attestation.CID.String() != cid {
+		return &result{it.Path, statusFail, "attestation is for a different CID"}, nil
+	}
+	if ae.Attestation.Attribute != it.Attr {
+		return &result{it.Path, statusFail, fmt.Sprintf("attestation is for attribute %q", ae.Attestation.Attribute)}, nil
+	}
+	// The manifest isn't signed, so whether the attestation is encrypted is decided
+	// by the attestation itself
+	_, isCiphertext := ae.Attestation.Value.([]byte)
+	if it.Encrypted && !(ae.Attestation.Encrypted && isCiphertext) {
+		return &result{it.Path, statusFail, "listed as encrypted in the manifest, but the attestation isn't"}, nil
+	}
+	key, err := aa.VerifyAttEntry(&ae, keys)
+	if err != nil {
+		if it.Encrypted {
+			return &result{it.Path, statusSkip, "encrypted, signature can't be checked"}, nil
+		}
+		return &result{it.Path, statusFail, err.Error()}, nil
+	}
+	return &result{it.Path, statusPass, "signed by " + key.Name}, &ae
+}
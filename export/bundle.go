@@ -0,0 +1,97 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/aa"
+)
+
+// Bundle is a Verifiable Presentation holding every attestation of a single CID,
+// written by "export --all --format vc".
+//
+// Each attribute is included both as a Verifiable Credential, for use with other
+// tools, and as the attestation signed by AA in its original DAG-CBOR encoding.
+// The latter is what "verify --bundle" checks, without needing to contact AA.
+type Bundle struct {
+	Context     []string          `json:"@context"`
+	Type        []string          `json:"type"`
+	Credentials []json.RawMessage `json:"verifiableCredential"`
+
+	CID     string    `json:"cid"`
+	Created time.Time `json:"created"`
+	// Attestations maps attribute names to DAG-CBOR attestations, base64 encoded.
+	// Encrypted attestations are only here if they were decrypted.
+	Attestations map[string][]byte `json:"attestations"`
+	// Encrypted lists attributes that were left encrypted, so only their VCs
+	// are included.
+	Encrypted []string `json:"encrypted,omitempty"`
+	// Relationships are the effective relationships of the CID, which can be
+	// checked against the attestations.
+	Relationships *aa.Relationships `json:"relationships"`
+}
+
+// MakeBundle gets all the attestations of the CID from AA. encKey returns the key to
+// decrypt an attribute with, or nil to leave it encrypted.
+func MakeBundle(a *aa.AuthAttrInstance, cid string, encKey func(cid, attr string) ([]byte, error)) (*Bundle, error) {
+	atts, err := a.GetAttestations(cid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attestations: %w", err)
+	}
+	attrs := make([]string, 0, len(atts))
+	for attr := range atts {
+		attrs = append(attrs, attr)
+	}
+	slices.Sort(attrs)
+
+	b := &Bundle{
+		Context:      []string{"https://www.w3.org/2018/credentials/v1"},
+		Type:         []string{"VerifiablePresentation"},
+		CID:          cid,
+		Created:      time.Now().UTC().Truncate(time.Second),
+		Attestations: make(map[string][]byte),
+	}
+	for _, attr := range attrs {
+		opts := aa.GetAttOpts{LeaveEncrypted: true}
+		if atts[attr].Attestation.Encrypted {
+			key, err := encKey(cid, attr)
+			if err != nil {
+				return nil, fmt.Errorf("error reading key for %s: %w", attr, err)
+			}
+			if key == nil {
+				b.Encrypted = append(b.Encrypted, attr)
+			} else {
+				opts = aa.GetAttOpts{EncKey: key}
+			}
+		}
+
+		opts.Format = "vc"
+		vc, err := a.GetAttestationRaw(cid, attr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error getting VC for %s: %w", attr, err)
+		}
+		if !json.Valid(vc) {
+			return nil, fmt.Errorf("VC for %s is not valid JSON", attr)
+		}
+		b.Credentials = append(b.Credentials, vc)
+
+		if opts.LeaveEncrypted && atts[attr].Attestation.Encrypted {
+			continue
+		}
+		opts.Format = ""
+		raw, err := a.GetAttestationRaw(cid, attr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error getting attestation for %s: %w", attr, err)
+		}
+		b.Attestations[attr] = raw
+	}
+
+	// Computed from the attestations that were already retrieved, so they match
+	b.Relationships, err = aa.RelationshipsFromAttestations(atts)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
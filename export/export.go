@@ -1,13 +1,13 @@
 package export
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/starlinglab/integrity-v2/aa"
-	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/util"
 )
 
 var (
@@ -15,6 +15,7 @@ var (
 	format      string
 	output      string
 	isEncrypted bool
+	getAll      bool
 )
 
 func Run(args []string) error {
@@ -22,7 +23,8 @@ func Run(args []string) error {
 	fs.StringVar(&attr, "attr", "", "attribute")
 	fs.StringVar(&format, "format", "cbor", "proof format (cbor,vc)")
 	fs.StringVar(&output, "o", "", "output path")
-	fs.BoolVar(&isEncrypted, "encrypted", false, "attribute is encrypted")
+	fs.BoolVar(&isEncrypted, "encrypted", false, "attribute is encrypted. With --all, decrypt any attributes with a stored key")
+	fs.BoolVar(&getAll, "all", false, "export all attributes and relationships as a Verifiable Presentation, requires --format vc")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -31,7 +33,14 @@ func Run(args []string) error {
 	}
 
 	// Validate input
-	if attr == "" {
+	if getAll {
+		if attr != "" {
+			return fmt.Errorf("can't use --attr and --all together")
+		}
+		if format != "vc" {
+			return fmt.Errorf("--all requires --format vc")
+		}
+	} else if attr == "" {
 		fs.PrintDefaults()
 		return fmt.Errorf("\nprovide attribute name with --attr")
 	}
@@ -49,16 +58,39 @@ func Run(args []string) error {
 
 	cid := fs.Arg(0)
 
-	conf := config.GetConfig()
+	if getAll {
+		encKey := func(cid, attr string) ([]byte, error) { return nil, nil }
+		if isEncrypted {
+			encKey = util.ReadEncKey
+		}
+		b, err := MakeBundle(aa.GetAAInstanceFromConfig(), cid, encKey)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(b, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding bundle: %w", err)
+		}
+		if err := writeOutput(append(data, '\n')); err != nil {
+			return err
+		}
+		if len(b.Encrypted) > 0 {
+			fmt.Fprintf(os.Stderr, "%d encrypted attributes can't be verified from the bundle, use --encrypted to decrypt them.\n", len(b.Encrypted))
+		}
+		return nil
+	}
 
 	// Get key
 	var key []byte
 	if isEncrypted {
 		var err error
-		key, err = os.ReadFile(filepath.Join(conf.Dirs.EncKeys, fmt.Sprintf("%s_%s.key", cid, attr)))
+		key, err = util.ReadEncKey(cid, attr)
 		if err != nil {
 			return fmt.Errorf("error reading key: %w", err)
 		}
+		if key == nil {
+			return fmt.Errorf("no key found for this attribute in the key store")
+		}
 	}
 
 	// Get attribute
@@ -74,10 +106,16 @@ func Run(args []string) error {
 		return fmt.Errorf("error getting attestation: %w", err)
 	}
 
+	return writeOutput(data)
+}
+
+// writeOutput writes data to the -o path, or stdout for "-".
+func writeOutput(data []byte) error {
 	var f *os.File
 	if output == "-" {
 		f = os.Stdout
 	} else {
+		var err error
 		f, err = os.Create(output)
 		if err != nil {
 			return fmt.Errorf("couldn't open output file: %w", err)
@@ -85,7 +123,7 @@ func Run(args []string) error {
 		defer f.Close()
 	}
 
-	_, err = f.Write(data)
+	_, err := f.Write(data)
 	if err != nil {
 		return fmt.Errorf("error writing output: %w", err)
 	}
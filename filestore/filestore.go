@@ -0,0 +1,322 @@
+// Package filestore provides access to the content-addressed file storage, where
+// every file is named by its CID.
+//
+// All code that reads or writes stored files should go through a Store rather than
+// building paths itself, so that the storage layout can change.
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+// Layouts
+const (
+	// LayoutFlat stores every file directly in the root directory: <dir>/<cid>
+	LayoutFlat = "flat"
+	// LayoutSharded stores files two directories deep, using characters from the end
+	// of the CID, since the start is the same for most CIDs: <dir>/ab/cd/<cid>
+	LayoutSharded = "sharded"
+)
+
+// partialPrefix starts the name of files that are still being written into the
+// store. They are renamed into place once complete, and removed by Sweep if left
+// behind by a crash.
+const partialPrefix = ".partial-"
+
+var (
+	ErrInvalidCID  = errors.New("invalid CID for file store")
+	ErrCIDMismatch = errors.New("file contents don't match CID")
+)
+
+// Store is a content-addressed file store.
+type Store interface {
+	// Put stores the data from r under the CID. The file only appears in the store
+	// once it is completely written and its CID has been verified.
+	Put(cid string, r io.Reader) error
+	// PutFile moves the file at path into the store under the CID, with the same
+	// guarantees as Put. The file at path is removed on success.
+	PutFile(cid, path string) error
+	// Get opens the stored file for reading.
+	Get(cid string) (io.ReadCloser, error)
+	// Stat returns information about the stored file. Errors for missing files
+	// satisfy errors.Is(err, fs.ErrNotExist).
+	Stat(cid string) (fs.FileInfo, error)
+	// Path returns the local filesystem path for the CID, for tools that need one.
+	// The file may not exist.
+	Path(cid string) string
+	// List calls fn with the CID of every stored file, stopping if fn returns an error.
+	List(fn func(cid string) error) error
+	// Delete removes the stored file.
+	Delete(cid string) error
+	// Sweep removes partially written files older than maxAge, left behind by
+	// interrupted writes. It returns how many were removed.
+	Sweep(maxAge time.Duration) (int, error)
+}
+
+// localStore stores files on the local filesystem, under dir.
+type localStore struct {
+	dir    string
+	layout string
+}
+
+// New returns a Store for the files in dir, using the given layout.
+func New(dir, layout string) (Store, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("files path is not configured")
+	}
+	switch layout {
+	case "", LayoutFlat:
+		return &localStore{dir: dir, layout: LayoutFlat}, nil
+	case LayoutSharded:
+		return &localStore{dir: dir, layout: LayoutSharded}, nil
+	default:
+		return nil, fmt.Errorf("unknown file store layout: %s", layout)
+	}
+}
+
+// FromConfig returns the Store set in the config under dirs.files and dirs.files_layout.
+func FromConfig(conf *config.Config) (Store, error) {
+	return New(conf.Dirs.Files, conf.Dirs.FilesLayout)
+}
+
+// checkCID makes sure the CID is safe to use as a file name. CIDs are expected to be
+// lowercase base32, so anything else is rejected.
+func checkCID(cid string) error {
+	if cid == "" {
+		return fmt.Errorf("%w: %q", ErrInvalidCID, cid)
+	}
+	for _, r := range cid {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') {
+			return fmt.Errorf("%w: %q", ErrInvalidCID, cid)
+		}
+	}
+	return nil
+}
+
+func (s *localStore) Path(cid string) string {
+	if s.layout == LayoutSharded && len(cid) >= 5 {
+		// The last character of a base32 CID only holds a few bits, so skip it
+		n := len(cid)
+		return filepath.Join(s.dir, cid[n-5:n-3], cid[n-3:n-1], cid)
+	}
+	return filepath.Join(s.dir, cid)
+}
+
+func (s *localStore) Put(cid string, r io.Reader) error {
+	if err := checkCID(cid); err != nil {
+		return err
+	}
+	dest := s.Path(cid)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	// Write next to the destination so the final rename can't cross filesystems
+	tmp, err := os.CreateTemp(filepath.Dir(dest), partialPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return fmt.Errorf("error writing temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("error syncing temp file: %w", err)
+	}
+	// Verify what actually made it to disk
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking in temp file: %w", err)
+	}
+	if err := verifyCID(cid, tmp); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing temp file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("error moving file into place: %w", err)
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+func (s *localStore) PutFile(cid, path string) error {
+	if err := checkCID(cid); err != nil {
+		return err
+	}
+	dest := s.Path(cid)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("error creating directory: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := verifyCID(cid, f); err != nil {
+		return err
+	}
+	// Make sure the data is on disk before it appears in the store
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %w", err)
+	}
+	f.Close()
+
+	err = os.Rename(path, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		// Different filesystems, so copy it over instead
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := s.Put(cid, f); err != nil {
+			return err
+		}
+		f.Close() // For Windows, close before trying to remove
+		return os.Remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("error moving file into place: %w", err)
+	}
+	return syncDir(filepath.Dir(dest))
+}
+
+// verifyCID calculates the CID of the data in r and makes sure it matches.
+func verifyCID(cid string, r io.Reader) error {
+	got, err := util.CalculateFileCid(r)
+	if err != nil {
+		return fmt.Errorf("error calculating CID: %w", err)
+	}
+	if got != cid {
+		return fmt.Errorf("%w: expected %s, got %s", ErrCIDMismatch, cid, got)
+	}
+	return nil
+}
+
+// syncDir flushes directory entries to disk, so a rename survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory: %w", err)
+	}
+	return nil
+}
+
+func (s *localStore) Get(cid string) (io.ReadCloser, error) {
+	if err := checkCID(cid); err != nil {
+		return nil, err
+	}
+	return os.Open(s.Path(cid))
+}
+
+func (s *localStore) Stat(cid string) (fs.FileInfo, error) {
+	if err := checkCID(cid); err != nil {
+		return nil, err
+	}
+	return os.Stat(s.Path(cid))
+}
+
+func (s *localStore) Delete(cid string) error {
+	if err := checkCID(cid); err != nil {
+		return err
+	}
+	return os.Remove(s.Path(cid))
+}
+
+func (s *localStore) List(fn func(cid string) error) error {
+	if s.layout == LayoutFlat {
+		entries, err := os.ReadDir(s.dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || checkCID(e.Name()) != nil {
+				continue
+			}
+			if err := fn(e.Name()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Sharded, only look at files two directories deep
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		// Number of path components below the root, which itself is "."
+		depth := 0
+		if rel != "." {
+			depth = strings.Count(rel, string(filepath.Separator)) + 1
+		}
+		if d.IsDir() {
+			if depth > 2 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if depth != 3 || !d.Type().IsRegular() || checkCID(d.Name()) != nil {
+			return nil
+		}
+		if s.Path(d.Name()) != path {
+			// Not where it belongs, so it's not part of this store
+			return nil
+		}
+		return fn(d.Name())
+	})
+}
+
+func (s *localStore) Sweep(maxAge time.Duration) (int, error) {
+	var removed int
+	cutoff := time.Now().Add(-maxAge)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), partialPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Finished while walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			// May still be in progress
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,169 @@
+package filestore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+const testCID = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+
+func TestPath(t *testing.T) {
+	flat, _ := New("/files", LayoutFlat)
+	if got := flat.Path(testCID); got != "/files/"+testCID {
+		t.Errorf("flat: got %s", got)
+	}
+	sharded, _ := New("/files", LayoutSharded)
+	if got := sharded.Path(testCID); got != "/files/ev/ei/"+testCID {
+		t.Errorf("sharded: got %s", got)
+	}
+	if _, err := New("/files", "nested"); err == nil {
+		t.Error("expected error for unknown layout")
+	}
+}
+
+func TestStore(t *testing.T) {
+	for _, layout := range []string{LayoutFlat, LayoutSharded} {
+		t.Run(layout, func(t *testing.T) {
+			dir := t.TempDir()
+			s, err := New(dir, layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Put(testCID, strings.NewReader("hello world\n")); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+			tmp := filepath.Join(t.TempDir(), "tmp")
+			if err := os.WriteFile(tmp, []byte("other"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			other := cidOf(t, "other")
+			if err := s.PutFile(other, tmp); err != nil {
+				t.Fatalf("put file: %v", err)
+			}
+			// Files that don't belong to the store are ignored by List
+			if err := os.WriteFile(filepath.Join(dir, "README"), nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := s.Get(testCID)
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			b, _ := io.ReadAll(r)
+			r.Close()
+			if string(b) != "hello world\n" {
+				t.Errorf("got contents %q", b)
+			}
+
+			var cids []string
+			if err := s.List(func(cid string) error {
+				cids = append(cids, cid)
+				return nil
+			}); err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			want := []string{other, testCID}
+			slices.Sort(cids)
+			slices.Sort(want)
+			if !slices.Equal(cids, want) {
+				t.Errorf("got list %v", cids)
+			}
+
+			if err := s.Delete(testCID); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, err := s.Stat(testCID); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("got %v, want not exist", err)
+			}
+		})
+	}
+}
+
+func cidOf(t *testing.T, data string) string {
+	t.Helper()
+	cid, err := util.CalculateFileCid(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid
+}
+
+func TestPutVerifiesCID(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := New(dir, LayoutSharded)
+	if err := s.Put(testCID, strings.NewReader("truncated")); !errors.Is(err, ErrCIDMismatch) {
+		t.Fatalf("got %v, want ErrCIDMismatch", err)
+	}
+	if _, err := s.Stat(testCID); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("file should not be stored after a mismatch, got %v", err)
+	}
+
+	tmp := filepath.Join(t.TempDir(), "tmp")
+	if err := os.WriteFile(tmp, []byte("truncated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutFile(testCID, tmp); !errors.Is(err, ErrCIDMismatch) {
+		t.Fatalf("got %v, want ErrCIDMismatch", err)
+	}
+	if _, err := os.Stat(tmp); err != nil {
+		t.Errorf("source file should be left alone after a mismatch, got %v", err)
+	}
+
+	// No partial files are left behind
+	n, err := s.Sweep(0)
+	if err != nil || n != 0 {
+		t.Errorf("got (%d, %v), want no partial files", n, err)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := New(dir, LayoutSharded)
+	if err := s.Put(testCID, strings.NewReader("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	stale := filepath.Join(filepath.Dir(s.Path(testCID)), partialPrefix+"123")
+	fresh := filepath.Join(dir, partialPrefix+"456")
+	for _, p := range []string{stale, fresh} {
+		if err := os.WriteFile(p, []byte("partial"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.Sweep(time.Hour)
+	if err != nil || n != 1 {
+		t.Fatalf("got (%d, %v), want 1 removed", n, err)
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("stale partial file should be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("recent partial file should be kept")
+	}
+	if _, err := s.Stat(testCID); err != nil {
+		t.Error("stored file should be kept")
+	}
+}
+
+func TestInvalidCID(t *testing.T) {
+	s, _ := New(t.TempDir(), LayoutFlat)
+	for _, cid := range []string{"", "../../etc/passwd", "bafk/../x", "BAFKREIF"} {
+		if err := s.Put(cid, strings.NewReader("")); !errors.Is(err, ErrInvalidCID) {
+			t.Errorf("%q: got %v, want ErrInvalidCID", cid, err)
+		}
+	}
+}
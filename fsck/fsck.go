@@ -0,0 +1,267 @@
+package fsck
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/util"
+	"lukechampine.com/blake3"
+)
+
+var (
+	workers  int
+	format   string
+	output   string
+	noAA     bool
+	dangling bool
+)
+
+// Kinds of problems
+const (
+	kindCidMismatch  = "cid_mismatch"  // File contents don't match its name
+	kindAttrMismatch = "attr_mismatch" // File doesn't match a hash or size attribute in AA
+	kindOrphan       = "orphan"        // File has no AA record
+	kindDangling     = "dangling"      // AA record has no file
+	kindError        = "error"         // File couldn't be checked
+)
+
+// problem is a single line of the report
+type problem struct {
+	CID    string `json:"cid"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type report struct {
+	Checked  int        `json:"checked"`
+	Problems []*problem `json:"problems"`
+}
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.IntVar(&workers, "workers", runtime.NumCPU(), "number of files to check in parallel")
+	fs.StringVar(&format, "format", "json", "report format (json,csv)")
+	fs.StringVar(&output, "o", "-", "report output path, - for stdout")
+	fs.BoolVar(&noAA, "no-aa", false, "only check file CIDs, don't cross-check with AA")
+	fs.BoolVar(&dangling, "dangling", true, "look for CIDs in AA with no file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	if format != "json" && format != "csv" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nformat must be one of json,csv")
+	}
+	if workers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("fsck takes no arguments, it checks the whole file store")
+	}
+
+	conf := config.GetConfig()
+	store, err := filestore.FromConfig(conf)
+	if err != nil {
+		return err
+	}
+
+	var cids []string
+	err = store.List(func(cid string) error {
+		cids = append(cids, cid)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing file store: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Checking %d files with %d workers...\n", len(cids), workers)
+	rep := &report{Checked: len(cids), Problems: checkFiles(store, cids)}
+
+	if dangling && !noAA {
+		aaCids, err := aa.GetCIDs()
+		if err != nil {
+			return fmt.Errorf("error getting CIDs from AA: %w", err)
+		}
+		slices.Sort(cids)
+		for _, c := range aaCids {
+			if _, found := slices.BinarySearch(cids, c); !found {
+				rep.Problems = append(rep.Problems, &problem{c, kindDangling, "no file in file store"})
+			}
+		}
+	}
+
+	var f *os.File
+	if output == "-" {
+		f = os.Stdout
+	} else {
+		f, err = os.Create(output)
+		if err != nil {
+			return fmt.Errorf("couldn't open output file: %w", err)
+		}
+		defer f.Close()
+	}
+	if err := writeReport(f, rep); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+
+	if len(rep.Problems) > 0 {
+		return fmt.Errorf("found %d problem(s) in %d file(s)", len(rep.Problems), rep.Checked)
+	}
+	fmt.Fprintln(os.Stderr, "No problems found.")
+	return nil
+}
+
+// checkFiles checks the given CID files in parallel, and returns problems in the
+// same order as cids.
+func checkFiles(store filestore.Store, cids []string) []*problem {
+	results := make([][]*problem, len(cids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = checkFile(store.Path(cids[i]), cids[i])
+			}
+		}()
+	}
+	for i := range cids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var problems []*problem
+	for _, r := range results {
+		problems = append(problems, r...)
+	}
+	return problems
+}
+
+// checkFile re-hashes the file and compares it to its name and attributes.
+func checkFile(path, cid string) []*problem {
+	hashes, err := hashFile(path)
+	if err != nil {
+		return []*problem{{cid, kindError, err.Error()}}
+	}
+
+	var problems []*problem
+	if hashes["cid"] != cid {
+		problems = append(problems, &problem{cid, kindCidMismatch, "calculated CID is " + hashes["cid"].(string)})
+	}
+	if noAA {
+		return problems
+	}
+
+	atts, err := aa.GetAttestations(cid)
+	if errors.Is(err, aa.ErrNotFound) || (err == nil && len(atts) == 0) {
+		return append(problems, &problem{cid, kindOrphan, "no attributes in AA"})
+	}
+	if err != nil {
+		return append(problems, &problem{cid, kindError, fmt.Sprintf("error getting attestations: %v", err)})
+	}
+
+	for _, name := range []string{"sha256", "blake3", "md5", "file_size"} {
+		att, ok := atts[name]
+		if !ok || att.Attestation.Encrypted {
+			// Not all files have these, like C2PA exports or encrypted files
+			continue
+		}
+		if !equalValue(att.Attestation.Value, hashes[name]) {
+			problems = append(problems, &problem{
+				cid, kindAttrMismatch,
+				fmt.Sprintf("%s is %v in AA, but file has %v", name, att.Attestation.Value, hashes[name]),
+			})
+		}
+	}
+	return problems
+}
+
+// hashFile returns the CID, hashes, and size of the file at path, reading it only once.
+// Keys match AA attribute names.
+func hashFile(path string) (map[string]any, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sha := sha256.New()
+	md := md5.New()
+	blake := blake3.New(32, nil)
+	hasher := util.NewCidHasher()
+	n, err := io.Copy(io.MultiWriter(hasher, sha, md, blake), f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+
+	return map[string]any{
+		"cid":       hasher.CID(),
+		"sha256":    hex.EncodeToString(sha.Sum(nil)),
+		"md5":       hex.EncodeToString(md.Sum(nil)),
+		"blake3":    hex.EncodeToString(blake.Sum(nil)),
+		"file_size": n,
+	}, nil
+}
+
+// equalValue compares a value decoded from AA with a calculated one. Numbers may be
+// decoded as different integer types.
+func equalValue(aaVal, fileVal any) bool {
+	if n, ok := fileVal.(int64); ok {
+		switch v := aaVal.(type) {
+		case uint64:
+			return n >= 0 && uint64(n) == v
+		case int64:
+			return n == v
+		case float64:
+			return float64(n) == v
+		}
+		return false
+	}
+	return aaVal == fileVal
+}
+
+func writeReport(w io.Writer, rep *report) error {
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"cid", "kind", "detail"}); err != nil {
+			return err
+		}
+		for _, p := range rep.Problems {
+			if err := cw.Write([]string{p.CID, p.Kind, p.Detail}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	if rep.Problems == nil {
+		// Encode as [] instead of null
+		rep.Problems = []*problem{}
+	}
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,76 @@
+package fsck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/filestore"
+)
+
+func TestCheckFilesNoAA(t *testing.T) {
+	noAA = true
+	workers = 2
+	t.Cleanup(func() { noAA = false })
+
+	dir := t.TempDir()
+	// CID of "hello world\n"
+	good := "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	if err := os.WriteFile(filepath.Join(dir, good), []byte("hello world\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Same name, different content
+	bad := "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+	if err := os.WriteFile(filepath.Join(dir, bad), []byte("bit rot\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := filestore.New(dir, filestore.LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := checkFiles(store, []string{good, bad, "missing"})
+	if len(problems) != 2 {
+		t.Fatalf("got %d problems, want 2: %+v", len(problems), problems)
+	}
+	if problems[0].CID != bad || problems[0].Kind != kindCidMismatch {
+		t.Errorf("expected CID mismatch for %s, got %+v", bad, problems[0])
+	}
+	if problems[1].CID != "missing" || problems[1].Kind != kindError {
+		t.Errorf("expected error for missing file, got %+v", problems[1])
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("hello world\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"cid":       "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4",
+		"sha256":    "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447",
+		"md5":       "6f5902ac237024bdd0c176cb93063dc4",
+		"file_size": int64(12),
+	}
+	for k, v := range want {
+		if hashes[k] != v {
+			t.Errorf("%s: got %v, want %v", k, hashes[k], v)
+		}
+	}
+}
+
+func TestEqualValue(t *testing.T) {
+	if !equalValue(uint64(12), int64(12)) {
+		t.Error("uint64 from CBOR should equal int64 size")
+	}
+	if equalValue(uint64(13), int64(12)) {
+		t.Error("different sizes should not be equal")
+	}
+	if !equalValue("abc", "abc") || equalValue("abc", "abd") {
+		t.Error("string comparison is wrong")
+	}
+}
@@ -0,0 +1,158 @@
+package genkey
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	encCid  string
+	encAttr string
+	pairsIn string
+	jsonOut bool
+)
+
+func runEnc(args []string) error {
+	fs := flag.NewFlagSet("genkey aa-enc", flag.ContinueOnError)
+	fs.StringVar(&encCid, "cid", "", "CID to generate a key for")
+	fs.StringVar(&encAttr, "attr", "", "attribute to generate a key for")
+	fs.StringVar(&pairsIn, "from", "", "generate keys for the CID and attribute pairs in this file (- for stdin), one pair per line separated by whitespace")
+	fs.BoolVar(&jsonOut, "json", false, "print a JSON object per key, with its path and whether it is new")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("no arguments expected, use --cid and --attr or --from")
+	}
+
+	var pairs []keyPair
+	switch {
+	case pairsIn != "":
+		if encCid != "" || encAttr != "" {
+			return fmt.Errorf("can't use --from with --cid or --attr")
+		}
+		pairs, err = readPairsFile(pairsIn)
+		if err != nil {
+			return fmt.Errorf("error reading pairs: %w", err)
+		}
+	case encCid != "" && encAttr != "":
+		pairs = []keyPair{{CID: encCid, Attr: encAttr}}
+	case encCid == "" && encAttr == "":
+		// Interactive
+		fmt.Print("CID: ")
+		var p keyPair
+		if _, err := fmt.Scan(&p.CID); err != nil {
+			return err
+		}
+		fmt.Print("Attribute: ")
+		if _, err := fmt.Scan(&p.Attr); err != nil {
+			return err
+		}
+		pairs = []keyPair{p}
+	default:
+		return fmt.Errorf("--cid and --attr must be used together")
+	}
+
+	failed, err := generateKeys(os.Stdout, pairs, jsonOut, util.GenerateEncKey)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to generate %d of %d keys", failed, len(pairs))
+	}
+	return nil
+}
+
+type keyPair struct {
+	CID  string `json:"cid"`
+	Attr string `json:"attr"`
+}
+
+// keyResult is what --json prints for each key.
+type keyResult struct {
+	keyPair
+	Path  string `json:"path,omitempty"`
+	New   bool   `json:"new"`
+	Error string `json:"error,omitempty"`
+}
+
+func readPairsFile(path string) ([]keyPair, error) {
+	if path == "-" {
+		return readPairs(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPairs(f)
+}
+
+// readPairs reads a CID and attribute from each line. Blank lines and lines
+// starting with # are ignored.
+func readPairs(r io.Reader) ([]keyPair, error) {
+	var pairs []keyPair
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected a CID and an attribute", lineNum)
+		}
+		pairs = append(pairs, keyPair{CID: fields[0], Attr: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// generateKeys generates a key for each pair, or finds the existing one, and reports
+// it to w. Failures are reported and counted, without stopping. An error is only
+// returned if writing to w fails.
+func generateKeys(w io.Writer, pairs []keyPair, asJSON bool, gen func(cid, attr string) (string, []byte, bool, error)) (int, error) {
+	enc := json.NewEncoder(w)
+	failed := 0
+	for _, p := range pairs {
+		path, _, isNew, err := gen(p.CID, p.Attr)
+		if err != nil {
+			failed++
+		}
+		var werr error
+		if asJSON {
+			res := keyResult{keyPair: p, Path: path, New: isNew}
+			if err != nil {
+				res.Error = err.Error()
+			}
+			werr = enc.Encode(res)
+		} else {
+			switch {
+			case err != nil:
+				_, werr = fmt.Fprintf(w, "error generating key for %s %s: %v\n", p.CID, p.Attr, err)
+			case isNew:
+				_, werr = fmt.Fprintf(w, "Generated key was stored at %s\n", path)
+			default:
+				_, werr = fmt.Fprintf(w, "Key already exists at %s\n", path)
+			}
+		}
+		if werr != nil {
+			return failed, fmt.Errorf("error writing output: %w", werr)
+		}
+	}
+	return failed, nil
+}
@@ -0,0 +1,338 @@
+package genkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+// Escrow is the contents of an escrow archive, before it's encrypted.
+type Escrow struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Keys maps paths relative to the enc_keys directory, with forward slashes,
+	// to the unsealed keys.
+	Keys map[string][]byte `json:"keys"`
+}
+
+var (
+	escrowOutput   string
+	recipientsPath string
+	identityPath   string
+	passphrasePath string
+	force          bool
+)
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("genkey export", flag.ContinueOnError)
+	fs.StringVar(&escrowOutput, "o", "", "path to write the escrow archive to")
+	fs.StringVar(&recipientsPath, "recipients", "", "(optional) armored OpenPGP public keys to encrypt to, instead of escrow_recipients in the config")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if escrowOutput == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nmust provide output path with -o")
+	}
+	if recipientsPath == "" {
+		recipientsPath = config.GetConfig().Keys.EscrowRecipients
+	}
+	if recipientsPath == "" {
+		return fmt.Errorf("no recipients, use --recipients or set escrow_recipients in the config")
+	}
+
+	recipients, err := readKeyRing(recipientsPath)
+	if err != nil {
+		return fmt.Errorf("error reading recipients: %w", err)
+	}
+	dir, err := encKeysDir()
+	if err != nil {
+		return err
+	}
+	keys, err := collectKeys(dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(escrowOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer f.Close()
+	esc := &Escrow{Version: 1, Created: time.Now().UTC().Truncate(time.Second), Keys: keys}
+	if err := writeEscrow(f, esc, recipients); err != nil {
+		os.Remove(escrowOutput)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	fmt.Printf("Exported %d keys for %d recipients to %s\n", len(keys), len(recipients), escrowOutput)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("genkey import", flag.ContinueOnError)
+	fs.StringVar(&identityPath, "identity", "", "armored OpenPGP private key of an escrow recipient")
+	fs.StringVar(&passphrasePath, "passphrase-file", "", "(optional) file with the passphrase of the private key")
+	fs.BoolVar(&force, "force", false, "replace existing keys that are different from the ones in the archive")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if identityPath == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nmust specify --identity")
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single escrow archive to import")
+	}
+
+	identity, err := readKeyRing(identityPath)
+	if err != nil {
+		return fmt.Errorf("error reading identity: %w", err)
+	}
+	if passphrasePath != "" {
+		passphrase, err := os.ReadFile(passphrasePath)
+		if err != nil {
+			return fmt.Errorf("error reading passphrase: %w", err)
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+		for _, e := range identity {
+			if err := e.DecryptPrivateKeys(passphrase); err != nil {
+				return fmt.Errorf("error unlocking private key: %w", err)
+			}
+		}
+	}
+	dir, err := encKeysDir()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("error opening escrow archive: %w", err)
+	}
+	defer f.Close()
+	esc, err := readEscrow(f, identity)
+	if err != nil {
+		return err
+	}
+
+	imported, conflicts, err := importKeys(dir, esc.Keys, force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d of %d keys from archive created %s\n", imported, len(esc.Keys), esc.Created.Format(time.RFC3339))
+	if conflicts > 0 {
+		return fmt.Errorf("%d keys are different from the ones already stored and were not imported, use --force to replace them", conflicts)
+	}
+	return nil
+}
+
+// runWrap seals every raw key in the enc_keys directory with the master key.
+func runWrap(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("no arguments expected")
+	}
+	master, err := util.MasterKey()
+	if err != nil {
+		return err
+	}
+	if master == nil {
+		return fmt.Errorf("master_key is not configured")
+	}
+	dir, err := encKeysDir()
+	if err != nil {
+		return err
+	}
+	paths, err := keyFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	sealed := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading key file: %w", err)
+		}
+		if util.IsSealedKey(data) {
+			continue
+		}
+		key, err := util.ReadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		if err := util.WriteKeyFile(path, key); err != nil {
+			return err
+		}
+		sealed++
+	}
+	fmt.Printf("Sealed %d of %d keys with the master key\n", sealed, len(paths))
+	return nil
+}
+
+func encKeysDir() (string, error) {
+	dir := config.GetConfig().Dirs.EncKeys
+	if dir == "" {
+		return "", fmt.Errorf("enc_keys path is not configured, are you on the server?")
+	}
+	return dir, nil
+}
+
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return openpgp.ReadArmoredKeyRing(f)
+}
+
+// keyFiles returns the paths of all key files in dir and its subdirectories, like
+// the old keys kept by "genkey rotate".
+func keyFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && strings.HasSuffix(path, ".key") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys: %w", err)
+	}
+	return paths, nil
+}
+
+// collectKeys reads every key file in dir, unsealing them.
+func collectKeys(dir string) (map[string][]byte, error) {
+	paths, err := keyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		keys[filepath.ToSlash(rel)], err = util.ReadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// importKeys writes keys to dir, sealing them if a master key is configured.
+// Existing keys that are different are only replaced if force is set, otherwise
+// they are counted as conflicts.
+func importKeys(dir string, keys map[string][]byte, force bool) (imported, conflicts int, err error) {
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if !filepath.IsLocal(filepath.FromSlash(name)) || !strings.HasSuffix(name, ".key") {
+			return imported, conflicts, fmt.Errorf("invalid key path in archive: %s", name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		existing, err := util.ReadKeyFile(path)
+		if err == nil {
+			if bytes.Equal(existing, keys[name]) {
+				continue
+			}
+			if !force {
+				fmt.Fprintf(os.Stderr, "warning: %s is different from the stored key\n", name)
+				conflicts++
+				continue
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return imported, conflicts, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return imported, conflicts, fmt.Errorf("error creating directory: %w", err)
+		}
+		if err := util.WriteKeyFile(path, keys[name]); err != nil {
+			return imported, conflicts, err
+		}
+		imported++
+	}
+	return imported, conflicts, nil
+}
+
+// writeEscrow encrypts the escrow as an armored OpenPGP message for the recipients.
+func writeEscrow(w io.Writer, esc *Escrow, recipients openpgp.EntityList) error {
+	aw, err := armor.Encode(w, "PGP MESSAGE", nil)
+	if err != nil {
+		return err
+	}
+	pw, err := openpgp.Encrypt(aw, recipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return fmt.Errorf("error encrypting escrow archive: %w", err)
+	}
+	if err := json.NewEncoder(pw).Encode(esc); err != nil {
+		return fmt.Errorf("error writing escrow archive: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("error writing escrow archive: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("error writing escrow archive: %w", err)
+	}
+	return nil
+}
+
+// readEscrow decrypts an escrow archive written by writeEscrow.
+func readEscrow(r io.Reader, identity openpgp.EntityList) (*Escrow, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading escrow archive: %w", err)
+	}
+	if block.Type != "PGP MESSAGE" {
+		return nil, fmt.Errorf("escrow archive is a %s, not a PGP MESSAGE", block.Type)
+	}
+	md, err := openpgp.ReadMessage(block.Body, identity, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting escrow archive: %w", err)
+	}
+	// Read everything so the integrity check runs
+	data, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting escrow archive: %w", err)
+	}
+	var esc Escrow
+	if err := json.Unmarshal(data, &esc); err != nil {
+		return nil, fmt.Errorf("error decoding escrow archive: %w", err)
+	}
+	if esc.Version != 1 {
+		return nil, fmt.Errorf("unsupported escrow archive version %d", esc.Version)
+	}
+	return &esc, nil
+}
@@ -1,47 +1,83 @@
 package genkey
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"time"
 
-	"github.com/starlinglab/integrity-v2/util"
+	"github.com/starlinglab/integrity-v2/age"
 )
 
+const usage = `Valid invocations:
+genkey aa-enc [--cid <cid> --attr <attr> | --from <file>] [--json]
+genkey aa-sig [flags]
+genkey rotate --attr <attr> <cid>
+genkey identity <path>
+genkey master <path>
+genkey wrap
+genkey export [flags] -o <file>
+genkey import [flags] <file>`
+
 func Run(args []string) error {
-	if len(args) != 1 ||
-		(len(args) == 1 && args[0] != "aa-enc" && args[0] != "aa-sig") {
-		return fmt.Errorf(`Valid invocations:
-genkey aa-enc
-genkey aa-sig`)
+	if len(args) == 0 {
+		return errors.New(usage)
 	}
-	if args[0] == "aa-sig" {
-		fmt.Println(`Currently not implemented. Instead run: openssl genpkey -algorithm ED25519`)
-		return nil
+	switch args[0] {
+	case "aa-enc":
+		return runEnc(args[1:])
+	case "aa-sig":
+		return runSig(args[1:])
+	case "rotate":
+		return runRotate(args[1:])
+	case "identity":
+		return runIdentity(args[1:])
+	case "master":
+		return runMaster(args[1:])
+	case "wrap":
+		return runWrap(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "import":
+		return runImport(args[1:])
 	}
-	// "aa-enc"
+	return errors.New(usage)
+}
 
-	fmt.Print("CID: ")
-	var cid string
-	_, err := fmt.Scan(&cid)
-	if err != nil {
-		return err
+// runMaster creates a new master key file, for master_key in the config.
+func runMaster(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("provide a single path to write the master key to")
 	}
-
-	var attr string
-	fmt.Print("Attribute: ")
-	_, err = fmt.Scan(&attr)
-	if err != nil {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("error generating random bytes: %w", err)
+	}
+	if err := writeNewFile(args[0], key, 0600); err != nil {
 		return err
 	}
+	fmt.Printf("Generated master key was stored at %s\n", args[0])
+	fmt.Println("Set master_key in the [keys] config section to use it, then run \"genkey wrap\" to seal existing keys.")
+	fmt.Println("Back it up somewhere other than this server: without it, sealed keys can't be read.")
+	return nil
+}
 
-	path, _, isNew, err := util.GenerateEncKey(cid, attr)
+// runIdentity creates an age identity file, for a partner to receive shared keys
+// with. It's the same format as age-keygen.
+func runIdentity(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("provide a single path to write the identity to")
+	}
+	id, err := age.GenerateIdentity()
 	if err != nil {
-		return err
+		return fmt.Errorf("error generating identity: %w", err)
 	}
-	if !isNew {
-		fmt.Printf("Key already exists at %s\n", path)
-		return nil
+	data := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+		time.Now().UTC().Format(time.RFC3339), id.Recipient(), id)
+	if err := writeNewFile(args[0], []byte(data), 0600); err != nil {
+		return err
 	}
-	fmt.Printf("Generated key was stored at %s\n", path)
-
+	fmt.Printf("Generated identity was stored at %s\n", args[0])
+	fmt.Printf("Public key: %s\n", id.Recipient())
 	return nil
 }
@@ -0,0 +1,363 @@
+package genkey
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+const testCid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+
+var encKeysTestDir string
+
+// TestMain sets up a config with a master key, as key files are found through it.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "genkey-test")
+	if err != nil {
+		panic(err)
+	}
+	encKeysTestDir = filepath.Join(dir, "keys")
+	if err := os.Mkdir(encKeysTestDir, 0700); err != nil {
+		panic(err)
+	}
+	master := filepath.Join(dir, "master.key")
+	if err := os.WriteFile(master, randomKey(), 0600); err != nil {
+		panic(err)
+	}
+	conf := filepath.Join(dir, "config.toml")
+	err = os.WriteFile(conf, []byte(fmt.Sprintf("[dirs]\nenc_keys = %q\n[keys]\nmaster_key = %q\n", encKeysTestDir, master)), 0600)
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("INTEGRITY_CONFIG_PATH", conf)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+func TestSealedKeys(t *testing.T) {
+	path, key, isNew, err := util.GenerateEncKey("cid1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("key should be new")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !util.IsSealedKey(data) || bytes.Contains(data, key) {
+		t.Fatal("key file is not sealed")
+	}
+	got, err := util.ReadEncKey("cid1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("read key doesn't match generated one")
+	}
+	_, again, isNew, err := util.GenerateEncKey("cid1", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isNew || !bytes.Equal(again, key) {
+		t.Fatal("existing key was not returned")
+	}
+
+	// Keys from before the master key was set still work
+	raw := randomKey()
+	rawPath, _ := util.EncKeyPath("cid1", "old")
+	if err := os.WriteFile(rawPath, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err = util.ReadEncKey("cid1", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Fatal("raw key doesn't match")
+	}
+
+	// Sealing it in place replaces the file, and a failed write keeps it
+	if err := util.WriteKeyFile(rawPath, raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteKeyFile(rawPath, raw[:10]); err == nil {
+		t.Fatal("wrote a short key")
+	}
+	got, err = util.ReadEncKey("cid1", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Fatal("rewritten key doesn't match")
+	}
+	entries, _ := os.ReadDir(filepath.Dir(rawPath))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".key_") {
+			t.Fatalf("temp file left behind: %s", e.Name())
+		}
+	}
+
+	if got, err := util.ReadEncKey("cid1", "missing"); got != nil || err != nil {
+		t.Fatalf("missing key: got %v, %v", got, err)
+	}
+}
+
+func TestEscrow(t *testing.T) {
+	keysDir := t.TempDir()
+	keys := map[string][]byte{
+		"cid1_a.key":           randomKey(),
+		"cid2.key":             randomKey(),
+		"rotated/cid1_a_1.key": randomKey(),
+	}
+	if _, _, err := importKeys(keysDir, keys, false); err != nil {
+		t.Fatal(err)
+	}
+	collected, err := collectKeys(keysDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collected) != len(keys) {
+		t.Fatalf("collected %d keys, want %d", len(collected), len(keys))
+	}
+
+	recipient, err := openpgp.NewEntity("Escrow", "", "escrow@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	esc := &Escrow{Version: 1, Created: time.Now().UTC(), Keys: collected}
+	if err := writeEscrow(&buf, esc, openpgp.EntityList{recipient}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "-----BEGIN PGP MESSAGE-----") {
+		t.Fatal("archive is not armored")
+	}
+	archive := buf.Bytes()
+
+	other, err := openpgp.NewEntity("Other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEscrow(bytes.NewReader(archive), openpgp.EntityList{other}); err == nil {
+		t.Fatal("archive was decrypted by a key it wasn't for")
+	}
+
+	got, err := readEscrow(bytes.NewReader(archive), openpgp.EntityList{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreDir := t.TempDir()
+	imported, conflicts, err := importKeys(restoreDir, got.Keys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != len(keys) || conflicts != 0 {
+		t.Fatalf("imported %d with %d conflicts", imported, conflicts)
+	}
+	for name, key := range keys {
+		restored, err := util.ReadKeyFile(filepath.Join(restoreDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(restored, key) {
+			t.Errorf("%s doesn't match", name)
+		}
+	}
+
+	// Importing again changes nothing, and different keys aren't replaced
+	got.Keys["cid2.key"] = randomKey()
+	imported, conflicts, err = importKeys(restoreDir, got.Keys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 0 || conflicts != 1 {
+		t.Fatalf("imported %d with %d conflicts, want 0 and 1", imported, conflicts)
+	}
+	if imported, _, _ = importKeys(restoreDir, got.Keys, true); imported != 1 {
+		t.Fatalf("imported %d with force, want 1", imported)
+	}
+
+	if _, _, err := importKeys(restoreDir, map[string][]byte{"../evil.key": randomKey()}, false); err == nil {
+		t.Fatal("path outside the directory was accepted")
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	_, a := aatest.Start(t)
+	oldKey, newKey := randomKey(), randomKey()
+	err := a.SetAttestations(testCid, false, []aa.PostKV{{Key: "secret", Value: "hello", EncKey: oldKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reencrypt(a, testCid, "secret", randomKey(), newKey); err == nil {
+		t.Fatal("rotated with the wrong key")
+	}
+	if err := reencrypt(a, testCid, "secret", oldKey, newKey); err != nil {
+		t.Fatal(err)
+	}
+	ae, err := a.GetAttestation(testCid, "secret", aa.GetAttOpts{EncKey: newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ae.Attestation.Value != "hello" {
+		t.Fatalf("value is %v after rotation", ae.Attestation.Value)
+	}
+	_, err = a.GetAttestation(testCid, "secret", aa.GetAttOpts{EncKey: oldKey})
+	if !errors.Is(err, aa.ErrBadKey) {
+		t.Fatalf("old key: got %v, want ErrBadKey", err)
+	}
+
+	if err := recordRotation(a, testCid, "secret", "test", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	ae, err = a.GetAttestation(testCid, RotationsAttr, aa.GetAttOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotations, ok := ae.Attestation.Value.([]any)
+	if !ok || len(rotations) != 1 {
+		t.Fatalf("rotations: %v", ae.Attestation.Value)
+	}
+}
+
+func TestSigKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := sigKeyFiles(pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(files[0].data)
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.Equal(parsed) {
+		t.Fatal("PEM private key doesn't match")
+	}
+	if !bytes.Equal(files[2].data, priv.Seed()) || !bytes.Equal(files[3].data, pub) {
+		t.Fatal("raw keys don't match")
+	}
+
+	if did := didKey(pub); !strings.HasPrefix(did, "did:key:z6Mk") {
+		t.Errorf("unexpected DID %s", did)
+	}
+	if s := base58([]byte("Hello World!")); s != "2NEpo7TZRRrLZSi2U" {
+		t.Errorf("base58 is %s", s)
+	}
+	if s := base58([]byte{0, 0, 1}); s != "112" {
+		t.Errorf("base58 with leading zeros is %s", s)
+	}
+
+	path := filepath.Join(t.TempDir(), "trusted_keys.txt")
+	if err := os.WriteFile(path, []byte("# AA keys\n"+strings.Repeat("ab", 32)+" old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTrustedKey(path, pub, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addTrustedKey(path, pub, "again"); err == nil {
+		t.Fatal("key was added twice")
+	}
+	keys, err := aa.LoadTrustedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[1].Name != "new" || !keys[1].PubKey.Equal(pub) {
+		t.Fatalf("unexpected trusted keys: %+v", keys)
+	}
+}
+
+func TestGenerateKeys(t *testing.T) {
+	pairs, err := readPairs(strings.NewReader("# cid attr\ncid2 a\n\ncid2  b\ncid2 a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 3 {
+		t.Fatalf("read %d pairs, want 3", len(pairs))
+	}
+	if _, err := readPairs(strings.NewReader("cid2\n")); err == nil {
+		t.Fatal("line without an attribute was accepted")
+	}
+
+	var buf bytes.Buffer
+	if failed, err := generateKeys(&buf, pairs, true, util.GenerateEncKey); failed != 0 || err != nil {
+		t.Fatalf("%d failed: %v", failed, err)
+	}
+	dec := json.NewDecoder(&buf)
+	var results []keyResult
+	for dec.More() {
+		var r keyResult
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, isNew := range []bool{true, true, false} {
+		r := results[i]
+		if r.New != isNew || r.Error != "" {
+			t.Errorf("result %d: %+v", i, r)
+		}
+		if r.Path != filepath.Join(encKeysTestDir, r.CID+"_"+r.Attr+".key") {
+			t.Errorf("result %d has path %s", i, r.Path)
+		}
+	}
+
+	fail := func(cid, attr string) (string, []byte, bool, error) {
+		return "", nil, false, errors.New("no")
+	}
+	buf.Reset()
+	if failed, err := generateKeys(&buf, pairs[:1], true, fail); failed != 1 || err != nil {
+		t.Fatalf("%d failed, want 1: %v", failed, err)
+	}
+	if !strings.Contains(buf.String(), `"error":"no"`) {
+		t.Errorf("error not reported: %s", buf.String())
+	}
+	buf.Reset()
+	if _, err := generateKeys(&buf, pairs[:1], false, fail); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "error generating key") {
+		t.Errorf("error not reported: %s", buf.String())
+	}
+
+	// Output that can't be written is an error, not a success
+	if _, err := generateKeys(errWriter{}, pairs, true, util.GenerateEncKey); err == nil {
+		t.Error("write failure wasn't returned")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) { return 0, errors.New("closed") }
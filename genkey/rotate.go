@@ -0,0 +1,153 @@
+package genkey
+
+import (
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/share"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+// RotationsAttr records key rotations of a CID's encrypted attributes.
+const RotationsAttr = "key_rotations"
+
+// rotatedDir is where replaced keys are kept, under the enc_keys directory.
+const rotatedDir = "rotated"
+
+var (
+	rotateAttr string
+	reason     string
+)
+
+func runRotate(args []string) error {
+	fs := flag.NewFlagSet("genkey rotate", flag.ContinueOnError)
+	fs.StringVar(&rotateAttr, "attr", "", "encrypted attribute to rotate the key of")
+	fs.StringVar(&reason, "reason", "", "(optional) reason for the rotation, recorded in AA")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if rotateAttr == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nmust specify --attr")
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single CID to work with")
+	}
+	cid := fs.Arg(0)
+
+	oldKey, err := util.ReadEncKey(cid, rotateAttr)
+	if err != nil {
+		return fmt.Errorf("error reading key: %w", err)
+	}
+	if oldKey == nil {
+		return fmt.Errorf("no key found for this attribute in the key store")
+	}
+	keyPath, err := util.EncKeyPath(cid, rotateAttr)
+	if err != nil {
+		return err
+	}
+
+	newKey := make([]byte, 32)
+	if _, err := rand.Read(newKey); err != nil {
+		return fmt.Errorf("error generating random bytes: %w", err)
+	}
+	// Store the new key before AA is changed, so it can't be lost
+	newPath := keyPath + ".new"
+	if err := util.WriteKeyFile(newPath, newKey); err != nil {
+		return err
+	}
+
+	a := aa.GetAAInstanceFromConfig()
+	if err := reencrypt(a, cid, rotateAttr, oldKey, newKey); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	// Keep the old key, as older copies of the attestation still need it
+	now := time.Now().UTC()
+	dir := filepath.Join(config.GetConfig().Dirs.EncKeys, rotatedDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating directory for old keys, new key is at %s: %w", newPath, err)
+	}
+	oldPath := filepath.Join(dir, fmt.Sprintf("%s_%s_%d.key", cid, rotateAttr, now.Unix()))
+	if err := os.Rename(keyPath, oldPath); err != nil {
+		return fmt.Errorf("error moving old key, new key is at %s: %w", newPath, err)
+	}
+	if err := os.Rename(newPath, keyPath); err != nil {
+		return fmt.Errorf("error moving new key, it is at %s: %w", newPath, err)
+	}
+	fmt.Printf("Re-encrypted %s with a new key stored at %s\n", rotateAttr, keyPath)
+	fmt.Printf("Old key was moved to %s\n", oldPath)
+
+	if err := recordRotation(a, cid, rotateAttr, reason, now); err != nil {
+		return fmt.Errorf("error logging rotation to AuthAttr: %w", err)
+	}
+
+	// Shared keys are the old one, so partners lose access until it's shared again
+	shares, err := share.GetShares(a, cid)
+	if err != nil {
+		return fmt.Errorf("error checking shares of %s: %w", rotateAttr, err)
+	}
+	recipients := make(map[string]bool)
+	for _, s := range shares {
+		if s.Attribute == rotateAttr {
+			recipients[s.Recipient] = true
+		}
+	}
+	if len(recipients) > 0 {
+		fmt.Printf("Warning: %s was shared with these recipients, who can't decrypt it until it's shared again with attr share:\n", rotateAttr)
+		for _, r := range slices.Sorted(maps.Keys(recipients)) {
+			fmt.Println("  " + r)
+		}
+	}
+	return nil
+}
+
+// reencrypt sets the attribute to its current value, encrypted with newKey instead
+// of oldKey.
+func reencrypt(a *aa.AuthAttrInstance, cid, attr string, oldKey, newKey []byte) error {
+	ae, err := a.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: oldKey})
+	if errors.Is(err, aa.ErrBadKey) {
+		return fmt.Errorf("stored key for %s is wrong, can't rotate it", attr)
+	}
+	if err != nil {
+		return fmt.Errorf("error getting attestation: %w", err)
+	}
+	if !ae.Attestation.Encrypted {
+		return fmt.Errorf("%s is not encrypted", attr)
+	}
+
+	err = a.SetAttestations(cid, false, []aa.PostKV{{Key: attr, Value: ae.Attestation.Value, EncKey: newKey}})
+	if err != nil {
+		return fmt.Errorf("error setting attestation: %w", err)
+	}
+	// Make sure the new key is the one that works now
+	if _, err := a.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: newKey}); err != nil {
+		return fmt.Errorf("error checking attestation with new key: %w", err)
+	}
+	return nil
+}
+
+// recordRotation appends the rotation to the key_rotations attribute.
+func recordRotation(a *aa.AuthAttrInstance, cid, attr, reason string, t time.Time) error {
+	rotation := map[string]any{
+		"attribute": attr,
+		"timestamp": t.Format(time.RFC3339),
+	}
+	if reason != "" {
+		rotation["reason"] = reason
+	}
+	return a.AppendAttestation(cid, RotationsAttr, rotation)
+}
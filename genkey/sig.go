@@ -0,0 +1,197 @@
+package genkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/config"
+)
+
+var (
+	sigOutput   string
+	trust       bool
+	trustName   string
+	trustedPath string
+)
+
+func runSig(args []string) error {
+	fs := flag.NewFlagSet("genkey aa-sig", flag.ContinueOnError)
+	fs.StringVar(&sigOutput, "o", "aa-sig", "path prefix for the key files")
+	fs.BoolVar(&trust, "trust", false, "add the public key to the trusted keys file, so attestations signed with it are accepted")
+	fs.StringVar(&trustName, "name", "", "(optional) name of the key in the trusted keys file")
+	fs.StringVar(&trustedPath, "trusted-keys", "", "(optional) path to trusted keys file, instead of the one in the config")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("no arguments expected, use -o to choose where keys are written")
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating key: %w", err)
+	}
+	files, err := sigKeyFiles(pub, priv)
+	if err != nil {
+		return err
+	}
+	var written []string
+	for _, f := range files {
+		path := sigOutput + f.suffix
+		if err := writeNewFile(path, f.data, f.perm); err != nil {
+			// Don't leave half a keypair behind
+			for _, p := range written {
+				os.Remove(p)
+			}
+			return err
+		}
+		written = append(written, path)
+	}
+	for i, f := range files {
+		fmt.Printf("Wrote %s to %s\n", f.desc, written[i])
+	}
+	fmt.Printf("\nPublic key: %s\n", hex.EncodeToString(pub))
+	fmt.Printf("DID: %s\n", didKey(pub))
+
+	if !trust {
+		return nil
+	}
+	if trustedPath == "" {
+		trustedPath = config.GetConfig().AA.TrustedKeys
+	}
+	if trustedPath == "" {
+		return fmt.Errorf("aa.trusted_keys path is not configured, use --trusted-keys")
+	}
+	if err := addTrustedKey(trustedPath, pub, trustName); err != nil {
+		return fmt.Errorf("error adding trusted key: %w", err)
+	}
+	fmt.Printf("Added public key to %s\n", trustedPath)
+	return nil
+}
+
+type keyFile struct {
+	suffix string
+	desc   string
+	data   []byte
+	perm   os.FileMode
+}
+
+// sigKeyFiles returns the files written for a signing keypair: PEM files in the
+// format of "openssl genpkey -algorithm ED25519", and the raw 32 byte private key
+// seed (RFC 8032) and public key.
+func sigKeyFiles(pub ed25519.PublicKey, priv ed25519.PrivateKey) ([]keyFile, error) {
+	privDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding private key: %w", err)
+	}
+	pubDer, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding public key: %w", err)
+	}
+	return []keyFile{
+		{".pem", "PEM private key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDer}), 0600},
+		{".pub.pem", "PEM public key", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDer}), 0644},
+		{".key", "raw private key", priv.Seed(), 0600},
+		{".pub", "raw public key", pub, 0644},
+	}, nil
+}
+
+// writeNewFile is like os.WriteFile, but fails if the file exists.
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("error creating key file: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("error writing key file: %w", err)
+	}
+	return nil
+}
+
+// addTrustedKey appends the public key to the trusted keys file, in the format read
+// by aa.LoadTrustedKeys. The file is created if it doesn't exist.
+func addTrustedKey(path string, pub ed25519.PublicKey, name string) error {
+	keys, err := aa.LoadTrustedKeys(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, k := range keys {
+		if k.PubKey.Equal(pub) {
+			return fmt.Errorf("key is already trusted as %q", k.Name)
+		}
+	}
+
+	line := hex.EncodeToString(pub)
+	if name = strings.TrimSpace(name); name != "" {
+		line += " " + name
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// didKey returns the did:key identifier of an ed25519 public key.
+//
+// See https://w3c-ccg.github.io/did-method-key/
+func didKey(pub ed25519.PublicKey) string {
+	// ed25519-pub multicodec, then base58btc multibase
+	return "did:key:z" + base58(append([]byte{0xed, 0x01}, pub...))
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58 encodes b using the Bitcoin alphabet.
+func base58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	// Leading zero bytes are kept as leading ones
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -1,14 +1,20 @@
 package get
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"reflect"
 
 	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/age"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/ots"
+	"github.com/starlinglab/integrity-v2/share"
 	"github.com/starlinglab/integrity-v2/util"
 )
 
@@ -17,7 +23,10 @@ var (
 	getAll          bool
 	isEncrypted     bool
 	encKeyPath      string
+	identityPath    string
 	showAttestation bool
+	cidsFrom        string
+	noCache         bool
 )
 
 func Run(args []string) error {
@@ -26,7 +35,10 @@ func Run(args []string) error {
 	fs.BoolVar(&getAll, "all", false, "get all attributes instead of just one")
 	fs.BoolVar(&isEncrypted, "encrypted", false, "value to get is encrypted")
 	fs.StringVar(&encKeyPath, "key", "", "(optional) manual path to encryption key file, implies --encrypted")
+	fs.StringVar(&identityPath, "identity", "", "(optional) age identity file, to decrypt an attribute that was shared with you using \"attr share\"")
 	fs.BoolVar(&showAttestation, "attestation", false, "show attestation information, not just value. Note values are not decrypted for this output.")
+	fs.BoolVar(&noCache, "no-cache", false, "always get attributes from AA, ignoring the local cache")
+	fs.StringVar(&cidsFrom, "cids-from", "", "with --all, get attributes for every CID listed in this file (- for stdin), as JSON lines")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -34,6 +46,10 @@ func Run(args []string) error {
 		os.Exit(1)
 	}
 
+	if noCache {
+		aa.DisableCache()
+	}
+
 	// Validate flags
 	if attr == "" && !getAll {
 		fs.PrintDefaults()
@@ -42,6 +58,21 @@ func Run(args []string) error {
 	if getAll && showAttestation {
 		return fmt.Errorf("can't use --all and --attestation together")
 	}
+	if identityPath != "" && (getAll || isEncrypted || encKeyPath != "") {
+		return fmt.Errorf("--identity can't be used with --all, --encrypted or --key")
+	}
+	if cidsFrom != "" {
+		if !getAll {
+			return fmt.Errorf("--cids-from can only be used with --all")
+		}
+		if fs.NArg() != 0 {
+			return fmt.Errorf("can't provide a CID and --cids-from together")
+		}
+		if isEncrypted || encKeyPath != "" || identityPath != "" {
+			return fmt.Errorf("--cids-from doesn't support decrypting values")
+		}
+		return getMany()
+	}
 	if fs.NArg() != 1 {
 		return fmt.Errorf("provide a single CID to work with")
 	}
@@ -52,10 +83,19 @@ func Run(args []string) error {
 	var encKey []byte
 	if encKeyPath != "" {
 		var err error
-		encKey, err = os.ReadFile(encKeyPath)
+		encKey, err = util.ReadKeyFile(encKeyPath)
 		if err != nil {
 			return fmt.Errorf("error reading key: %w", err)
 		}
+	} else if identityPath != "" {
+		ids, err := age.ReadIdentityFile(identityPath)
+		if err != nil {
+			return fmt.Errorf("error reading identity: %w", err)
+		}
+		encKey, err = share.FindKey(aa.GetAAInstanceFromConfig(), cid, attr, ids)
+		if err != nil {
+			return err
+		}
 	} else if isEncrypted {
 		if config.GetConfig().Dirs.EncKeys == "" {
 			return fmt.Errorf("enc_keys path is not configured, are you on the server?")
@@ -72,15 +112,7 @@ func Run(args []string) error {
 		if err != nil {
 			return fmt.Errorf("error getting attestations: %w", err)
 		}
-		pairs := make(map[string]any, len(atts))
-		for name, att := range atts {
-			if att.Attestation.Encrypted {
-				pairs[name] = "*ENCRYPTED*"
-			} else {
-				pairs[name] = att.Attestation.Value
-			}
-		}
-		b, err := json.MarshalIndent(pairs, "", "  ")
+		b, err := json.MarshalIndent(plainValues(atts), "", "  ")
 		if err != nil {
 			return fmt.Errorf("error encoding value as JSON: %w", err)
 		}
@@ -93,15 +125,28 @@ func Run(args []string) error {
 		}
 
 		ae, err := aa.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: encKey, LeaveEncrypted: leaveEnc})
-		if err == aa.ErrNeedsKey {
+		if errors.Is(err, aa.ErrNeedsKey) {
 			return fmt.Errorf("error attestation is encrypted, use --encrypted or --key")
 		}
+		if errors.Is(err, aa.ErrBadKey) {
+			return fmt.Errorf("error decrypting attestation, the encryption key is wrong for this attribute")
+		}
 		if err != nil {
 			return fmt.Errorf("error getting attestation: %w", err)
 		}
 
 		if showAttestation {
-			b, err := json.MarshalIndent(ae, "", "  ")
+			// Include a summary of the timestamp proof, which is otherwise just bytes
+			out := struct {
+				*aa.AttEntry
+				OTSInfo  *ots.Info `json:"ots_info,omitempty"`
+				OTSError string    `json:"ots_error,omitempty"`
+			}{AttEntry: ae}
+			out.OTSInfo, err = ots.AttestationInfo(ae)
+			if err != nil {
+				out.OTSError = err.Error()
+			}
+			b, err := json.MarshalIndent(out, "", "  ")
 			if err != nil {
 				return fmt.Errorf("error encoding value as JSON: %w", err)
 			}
@@ -127,3 +172,62 @@ func Run(args []string) error {
 
 	return nil
 }
+
+// plainValues returns just the values of the attestations, with encrypted ones
+// replaced by a placeholder.
+func plainValues(atts map[string]*aa.AttEntry) map[string]any {
+	pairs := make(map[string]any, len(atts))
+	for name, att := range atts {
+		if att.Attestation.Encrypted {
+			pairs[name] = "*ENCRYPTED*"
+		} else {
+			pairs[name] = att.Attestation.Value
+		}
+	}
+	return pairs
+}
+
+// manyLine is one line of --cids-from output
+type manyLine struct {
+	CID        string         `json:"cid"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// getMany prints all attributes for the CIDs listed in the --cids-from file, one
+// JSON object per line, in the same order as the file.
+func getMany() error {
+	cids, err := util.ReadCIDList(cidsFrom)
+	if err != nil {
+		return fmt.Errorf("error reading CIDs file: %w", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+
+	var failed int
+	err = aa.StreamAttestationsMany(context.Background(), cids, nil, func(r *aa.BatchResult) error {
+		line := manyLine{CID: r.CID}
+		if r.Err != nil {
+			line.Error = r.Err.Error()
+			failed++
+		} else {
+			line.Attributes = plainValues(r.Attestations)
+		}
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to get attributes for %d of %d CIDs, see error fields in output", failed, len(cids))
+	}
+	return nil
+}
@@ -4,22 +4,32 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/starlinglab/integrity-v2/aa/aamock"
+	"github.com/starlinglab/integrity-v2/attrimport"
 	"github.com/starlinglab/integrity-v2/c2pa"
 	"github.com/starlinglab/integrity-v2/cid"
 	"github.com/starlinglab/integrity-v2/decrypt"
+	"github.com/starlinglab/integrity-v2/dump"
 	"github.com/starlinglab/integrity-v2/encrypt"
+	"github.com/starlinglab/integrity-v2/evidence"
 	"github.com/starlinglab/integrity-v2/export"
+	"github.com/starlinglab/integrity-v2/fsck"
 	"github.com/starlinglab/integrity-v2/genkey"
 	"github.com/starlinglab/integrity-v2/get"
+	"github.com/starlinglab/integrity-v2/migrate"
+	"github.com/starlinglab/integrity-v2/ots"
 	"github.com/starlinglab/integrity-v2/pfp"
 	preprocessorfolder "github.com/starlinglab/integrity-v2/preprocessor/folder"
 	"github.com/starlinglab/integrity-v2/register"
 	"github.com/starlinglab/integrity-v2/relate"
 	"github.com/starlinglab/integrity-v2/search"
 	"github.com/starlinglab/integrity-v2/set"
+	"github.com/starlinglab/integrity-v2/share"
 	"github.com/starlinglab/integrity-v2/sync"
+	"github.com/starlinglab/integrity-v2/tree"
 	"github.com/starlinglab/integrity-v2/upload"
 	"github.com/starlinglab/integrity-v2/util"
+	"github.com/starlinglab/integrity-v2/verify"
 	"github.com/starlinglab/integrity-v2/webhook"
 )
 
@@ -31,8 +41,13 @@ Remote/network commands:
     starling attr get
     starling attr set
     starling attr export
+    starling attr dump
     starling attr search
     starling attr relate
+    starling attr tree
+    starling attr import
+    starling attr verify
+    starling attr share
 
 Commands to run on the server:
     starling genkey
@@ -43,6 +58,10 @@ Commands to run on the server:
     starling file cid
     starling file c2pa
     starling file pfp
+    starling file ots
+    starling file fsck
+    starling file migrate
+    starling file package
 
 Further documentation on CLI tools is listed online:
 https://github.com/starlinglab/integrity-v2/blob/main/docs/cli.md
@@ -51,6 +70,7 @@ Other than that, services are included:
     preprocessor-folder
     webhook
     sync
+    aa-mock (in-memory AA server, for testing)
 
 And finally, the version or --version command will display the build version.`
 
@@ -79,8 +99,18 @@ func run(group string, args []string) (bool, error) {
 			err = search.Run(args)
 		case "export":
 			err = export.Run(args)
+		case "dump":
+			err = dump.Run(args)
 		case "relate":
 			err = relate.Run(args)
+		case "tree":
+			err = tree.Run(args)
+		case "import":
+			err = attrimport.Run(args)
+		case "verify":
+			err = verify.Run(args)
+		case "share":
+			err = share.Run(args)
 		default:
 			// Unknown command
 			return false, nil
@@ -103,6 +133,14 @@ func run(group string, args []string) (bool, error) {
 			err = c2pa.Run(args)
 		case "pfp":
 			err = pfp.Run(args)
+		case "ots":
+			err = ots.Run(args)
+		case "fsck":
+			err = fsck.Run(args)
+		case "migrate":
+			err = migrate.Run(args)
+		case "package":
+			err = evidence.Run(args)
 		default:
 			// Unknown command
 			return false, nil
@@ -117,6 +155,8 @@ func run(group string, args []string) (bool, error) {
 		err = preprocessorfolder.Run(args)
 	case "sync":
 		err = sync.Run(args)
+	case "aa-mock":
+		err = aamock.Run(args)
 	// Helpers / metadata
 	case "-h", "--help", "help":
 		fmt.Println(helpText)
@@ -0,0 +1,82 @@
+package migrate
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
+)
+
+var (
+	from   string
+	to     string
+	dryRun bool
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.StringVar(&from, "from", "", "current layout of the file store (flat,sharded), defaults to files_layout in the config")
+	fs.StringVar(&to, "to", "", "layout to move files into (flat,sharded)")
+	fs.BoolVar(&dryRun, "dry-run", false, "only print how many files would be moved")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	conf := config.GetConfig()
+	if from == "" {
+		from = conf.Dirs.FilesLayout
+	}
+	if to == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nprovide the layout to migrate to with --to")
+	}
+
+	src, err := filestore.New(conf.Dirs.Files, from)
+	if err != nil {
+		return err
+	}
+	dst, err := filestore.New(conf.Dirs.Files, to)
+	if err != nil {
+		return err
+	}
+
+	// List everything first, so files aren't moved while they're being listed
+	var cids []string
+	err = src.List(func(cid string) error {
+		cids = append(cids, cid)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error listing file store: %w", err)
+	}
+
+	var moved int
+	for i, cid := range cids {
+		if src.Path(cid) == dst.Path(cid) {
+			continue
+		}
+		if dryRun {
+			moved++
+			continue
+		}
+		if err := dst.PutFile(cid, src.Path(cid)); err != nil {
+			return fmt.Errorf("error moving %s (%d of %d): %w", cid, i+1, len(cids), err)
+		}
+		moved++
+	}
+
+	if dryRun {
+		fmt.Printf("Would move %d of %d files from %s to %s layout.\n", moved, len(cids), from, to)
+		return nil
+	}
+	fmt.Printf("Moved %d of %d files from %s to %s layout.\n", moved, len(cids), from, to)
+	if conf.Dirs.FilesLayout != to {
+		fmt.Printf("Set files_layout = %q under [dirs] in the config file to use the new layout.\n", to)
+	}
+	return nil
+}
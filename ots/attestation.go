@@ -0,0 +1,91 @@
+package ots
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Attestation kinds
+const (
+	KindBitcoin  = "bitcoin"
+	KindLitecoin = "litecoin"
+	KindPending  = "pending"
+	KindUnknown  = "unknown"
+)
+
+var (
+	tagBitcoin  = [8]byte{0x05, 0x88, 0x96, 0x0d, 0x73, 0xd7, 0x19, 0x01}
+	tagLitecoin = [8]byte{0x06, 0x86, 0x9a, 0x0d, 0x73, 0xd7, 0x1b, 0x45}
+	tagPending  = [8]byte{0x83, 0xdf, 0xe3, 0x0d, 0x2e, 0xf9, 0x0c, 0x8e}
+)
+
+// Attestation is a claim that a message existed at a certain time.
+type Attestation struct {
+	Kind string
+	Tag  [8]byte
+
+	Height uint64 // For blockchain attestations
+	URI    string // For pending attestations, the calendar to ask for an upgrade
+
+	// Payload is the raw attestation data, kept for re-serializing
+	Payload []byte
+}
+
+func readAttestation(r *bufio.Reader) (*Attestation, error) {
+	var a Attestation
+	if _, err := io.ReadFull(r, a.Tag[:]); err != nil {
+		return nil, err
+	}
+	payload, err := readVarbytes(r, maxPayloadLength)
+	if err != nil {
+		return nil, err
+	}
+	a.Payload = payload
+	pr := bufio.NewReader(bytes.NewReader(payload))
+
+	switch a.Tag {
+	case tagBitcoin, tagLitecoin:
+		a.Kind = KindBitcoin
+		if a.Tag == tagLitecoin {
+			a.Kind = KindLitecoin
+		}
+		a.Height, err = readVaruint(pr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading block height: %w", err)
+		}
+	case tagPending:
+		a.Kind = KindPending
+		uri, err := readVarbytes(pr, maxURILength)
+		if err != nil {
+			return nil, fmt.Errorf("error reading calendar URI: %w", err)
+		}
+		if !utf8.Valid(uri) {
+			return nil, fmt.Errorf("calendar URI is not valid UTF-8")
+		}
+		a.URI = string(uri)
+	default:
+		a.Kind = KindUnknown
+	}
+	return &a, nil
+}
+
+func (a *Attestation) write(buf *bytes.Buffer) {
+	buf.Write(a.Tag[:])
+	writeVarbytes(buf, a.Payload)
+}
+
+// String fulfills the fmt.Stringer interface.
+func (a *Attestation) String() string {
+	switch a.Kind {
+	case KindBitcoin, KindLitecoin:
+		return fmt.Sprintf("%s block %d", a.Kind, a.Height)
+	case KindPending:
+		return "pending at " + a.URI
+	default:
+		return fmt.Sprintf("unknown attestation %s", hex.EncodeToString(a.Tag[:]))
+	}
+}
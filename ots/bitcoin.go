@@ -0,0 +1,98 @@
+package ots
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const blockHeaderSize = 80
+
+// BlockHeaders maps Bitcoin block heights to raw 80-byte block headers.
+type BlockHeaders map[uint64][]byte
+
+// LoadBlockHeaders reads Bitcoin block headers from a file, for offline verification.
+//
+// Each line holds a block height, whitespace, and then the hex-encoded 80-byte block
+// header, as returned by "bitcoin-cli getblockheader <hash> false". Empty lines and
+// lines starting with # are ignored.
+func LoadBlockHeaders(path string) (BlockHeaders, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	headers := make(BlockHeaders)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected height and header", lineNum)
+		}
+		height, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid height: %w", lineNum, err)
+		}
+		header, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hex: %w", lineNum, err)
+		}
+		if len(header) != blockHeaderSize {
+			return nil, fmt.Errorf("line %d: header is %d bytes, not %d", lineNum, len(header), blockHeaderSize)
+		}
+		headers[height] = header
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// BlockHash returns the hash of a raw block header, in the reversed byte order
+// used by block explorers.
+func BlockHash(header []byte) string {
+	h := sha256.Sum256(header)
+	h = sha256.Sum256(h[:])
+	return hex.EncodeToString(reversed(h[:]))
+}
+
+// VerifyBitcoin checks every Bitcoin attestation in info against the provided block
+// headers, setting the Verified and BlockTime fields. The attested message must equal
+// the merkle root in the header at that height.
+//
+// An error is returned if an attestation doesn't match its header. Attestations for
+// blocks that aren't in headers are left unverified.
+func VerifyBitcoin(info *Info, headers BlockHeaders) error {
+	for _, bi := range info.Bitcoin {
+		header, ok := headers[bi.Height]
+		if !ok {
+			continue
+		}
+		root, err := hex.DecodeString(bi.MerkleRoot)
+		if err != nil {
+			return err
+		}
+		// Header stores the merkle root in internal byte order
+		if !bytes.Equal(reversed(root), header[36:68]) {
+			return fmt.Errorf("merkle root mismatch for block %d (%s)", bi.Height, BlockHash(header))
+		}
+		bi.Verified = true
+		bi.BlockTime = time.Unix(int64(binary.LittleEndian.Uint32(header[68:72])), 0).
+			UTC().Format(time.RFC3339)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package ots
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" //nolint:gosec // Part of the OTS format, may appear in old proofs
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160" //nolint:staticcheck // Part of the OTS format
+	"golang.org/x/crypto/sha3"
+)
+
+// Operation tags
+const (
+	OpSHA1      byte = 0x02
+	OpRIPEMD160 byte = 0x03
+	OpSHA256    byte = 0x08
+	OpKECCAK256 byte = 0x67
+	OpAppend    byte = 0xf0
+	OpPrepend   byte = 0xf1
+	OpReverse   byte = 0xf2
+	OpHexlify   byte = 0xf3
+)
+
+// Op is a single operation applied to a message. Binary operations have an argument.
+type Op struct {
+	Tag byte
+	Arg []byte
+}
+
+func readOp(r *bufio.Reader, tag byte) (*Op, error) {
+	switch tag {
+	case OpSHA1, OpRIPEMD160, OpSHA256, OpKECCAK256, OpReverse, OpHexlify:
+		return &Op{Tag: tag}, nil
+	case OpAppend, OpPrepend:
+		arg, err := readVarbytes(r, maxMsgLength)
+		if err != nil {
+			return nil, err
+		}
+		return &Op{Tag: tag, Arg: arg}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation tag 0x%02x", tag)
+	}
+}
+
+func (op *Op) write(buf *bytes.Buffer) {
+	buf.WriteByte(op.Tag)
+	if op.Tag == OpAppend || op.Tag == OpPrepend {
+		writeVarbytes(buf, op.Arg)
+	}
+}
+
+// IsCrypto returns true for hash operations.
+func (op *Op) IsCrypto() bool {
+	return op.digestLen() > 0
+}
+
+func (op *Op) digestLen() int {
+	switch op.Tag {
+	case OpSHA1, OpRIPEMD160:
+		return 20
+	case OpSHA256, OpKECCAK256:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// Apply runs the operation on msg and returns the result.
+func (op *Op) Apply(msg []byte) ([]byte, error) {
+	if len(msg) > maxMsgLength {
+		return nil, fmt.Errorf("message too long for operation")
+	}
+	var result []byte
+	switch op.Tag {
+	case OpSHA1:
+		h := sha1.Sum(msg) //nolint:gosec
+		result = h[:]
+	case OpRIPEMD160:
+		h := ripemd160.New()
+		h.Write(msg)
+		result = h.Sum(nil)
+	case OpSHA256:
+		h := sha256.Sum256(msg)
+		result = h[:]
+	case OpKECCAK256:
+		h := sha3.NewLegacyKeccak256()
+		h.Write(msg)
+		result = h.Sum(nil)
+	case OpAppend:
+		result = append(append([]byte{}, msg...), op.Arg...)
+	case OpPrepend:
+		result = append(append([]byte{}, op.Arg...), msg...)
+	case OpReverse:
+		result = reversed(msg)
+	case OpHexlify:
+		result = []byte(hex.EncodeToString(msg))
+	default:
+		return nil, fmt.Errorf("unknown operation tag 0x%02x", op.Tag)
+	}
+	if len(result) > maxMsgLength {
+		return nil, fmt.Errorf("operation result too long")
+	}
+	return result, nil
+}
+
+// String fulfills the fmt.Stringer interface.
+func (op *Op) String() string {
+	switch op.Tag {
+	case OpSHA1:
+		return "sha1"
+	case OpRIPEMD160:
+		return "ripemd160"
+	case OpSHA256:
+		return "sha256"
+	case OpKECCAK256:
+		return "keccak256"
+	case OpAppend:
+		return "append " + hex.EncodeToString(op.Arg)
+	case OpPrepend:
+		return "prepend " + hex.EncodeToString(op.Arg)
+	case OpReverse:
+		return "reverse"
+	case OpHexlify:
+		return "hexlify"
+	default:
+		return fmt.Sprintf("unknown(0x%02x)", op.Tag)
+	}
+}
+
+func (op *Op) equal(other *Op) bool {
+	return op.Tag == other.Tag && bytes.Equal(op.Arg, other.Arg)
+}
@@ -0,0 +1,351 @@
+// Package ots parses and inspects OpenTimestamps proofs.
+//
+// The binary format is described by the reference implementation:
+// https://github.com/opentimestamps/python-opentimestamps
+package ots
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// headerMagic starts every detached timestamp file (.ots)
+var headerMagic = []byte("\x00OpenTimestamps\x00\x00Proof\x00\xbf\x89\xe2\xe8\x84\xe8\x92\x94")
+
+const (
+	majorVersion = 1
+
+	// Limits from the reference implementation
+	maxMsgLength     = 4096
+	maxPayloadLength = 8192
+	maxURILength     = 1000
+	maxDepth         = 256
+)
+
+var (
+	ErrNotOTS     = errors.New("not an OpenTimestamps proof")
+	ErrBadVersion = errors.New("unsupported OpenTimestamps version")
+)
+
+// Timestamp is a tree of operations on a message, ending in attestations.
+// Each attestation commits to the message as it was at that point in the tree.
+type Timestamp struct {
+	Msg          []byte
+	Attestations []*Attestation
+	Branches     []*Branch
+}
+
+// Branch is an operation applied to the message of a Timestamp, along with the
+// timestamp of its result.
+type Branch struct {
+	Op        *Op
+	Timestamp *Timestamp
+}
+
+// DetachedFile is a parsed .ots file, which proves the existence of a file by its hash.
+type DetachedFile struct {
+	// HashOp is the operation that was used to hash the original file, like sha256
+	HashOp    *Op
+	Timestamp *Timestamp
+}
+
+// Digest returns the hash of the original file.
+func (d *DetachedFile) Digest() []byte {
+	return d.Timestamp.Msg
+}
+
+// ParseDetached parses a whole .ots file.
+func ParseDetached(b []byte) (*DetachedFile, error) {
+	if !bytes.HasPrefix(b, headerMagic) {
+		return nil, ErrNotOTS
+	}
+	r := bufio.NewReader(bytes.NewReader(b[len(headerMagic):]))
+
+	version, err := readVaruint(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != majorVersion {
+		return nil, fmt.Errorf("%w: %d", ErrBadVersion, version)
+	}
+
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	hashOp, err := readOp(r, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !hashOp.IsCrypto() {
+		return nil, fmt.Errorf("file hash operation is not a hash: %s", hashOp)
+	}
+	digest := make([]byte, hashOp.digestLen())
+	if _, err := io.ReadFull(r, digest); err != nil {
+		return nil, err
+	}
+
+	ts, err := readTimestamp(r, digest, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after end of proof")
+	}
+	return &DetachedFile{HashOp: hashOp, Timestamp: ts}, nil
+}
+
+// ParseTimestamp parses a bare serialized timestamp, without the .ots file header.
+// msg is the message the timestamp starts from. This is the format returned by
+// calendar servers.
+func ParseTimestamp(b []byte, msg []byte) (*Timestamp, error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	ts, err := readTimestamp(r, msg, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after end of timestamp")
+	}
+	return ts, nil
+}
+
+func readTimestamp(r *bufio.Reader, msg []byte, depth int) (*Timestamp, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("timestamp is nested too deeply")
+	}
+	ts := &Timestamp{Msg: msg}
+
+	handleTag := func(tag byte) error {
+		if tag == 0x00 {
+			a, err := readAttestation(r)
+			if err != nil {
+				return err
+			}
+			ts.Attestations = append(ts.Attestations, a)
+			return nil
+		}
+		op, err := readOp(r, tag)
+		if err != nil {
+			return err
+		}
+		result, err := op.Apply(msg)
+		if err != nil {
+			return err
+		}
+		sub, err := readTimestamp(r, result, depth+1)
+		if err != nil {
+			return err
+		}
+		ts.Branches = append(ts.Branches, &Branch{Op: op, Timestamp: sub})
+		return nil
+	}
+
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	// 0xff marks a fork, there is at least one more item after this one
+	for tag == 0xff {
+		tag, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if err := handleTag(tag); err != nil {
+			return nil, err
+		}
+		tag, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := handleTag(tag); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// Serialize returns the .ots file bytes.
+func (d *DetachedFile) Serialize() []byte {
+	var buf bytes.Buffer
+	buf.Write(headerMagic)
+	writeVaruint(&buf, majorVersion)
+	d.HashOp.write(&buf)
+	buf.Write(d.Timestamp.Msg)
+	d.Timestamp.write(&buf)
+	return buf.Bytes()
+}
+
+// Serialize returns the bare timestamp bytes, without the starting message.
+func (ts *Timestamp) Serialize() []byte {
+	var buf bytes.Buffer
+	ts.write(&buf)
+	return buf.Bytes()
+}
+
+func (ts *Timestamp) write(buf *bytes.Buffer) {
+	// Every item but the last is marked as a fork with 0xff
+	n := len(ts.Attestations) + len(ts.Branches)
+	i := 0
+	for _, a := range ts.Attestations {
+		i++
+		if i < n {
+			buf.WriteByte(0xff)
+		}
+		buf.WriteByte(0x00)
+		a.write(buf)
+	}
+	for _, b := range ts.Branches {
+		i++
+		if i < n {
+			buf.WriteByte(0xff)
+		}
+		b.Op.write(buf)
+		b.Timestamp.write(buf)
+	}
+}
+
+// Leaf is an attestation found by walking a Timestamp.
+type Leaf struct {
+	Attestation *Attestation
+	// Msg is the message the attestation commits to
+	Msg []byte
+}
+
+// Leaves returns every attestation in the tree, in order.
+func (ts *Timestamp) Leaves() []*Leaf {
+	var leaves []*Leaf
+	for _, a := range ts.Attestations {
+		leaves = append(leaves, &Leaf{Attestation: a, Msg: ts.Msg})
+	}
+	for _, b := range ts.Branches {
+		leaves = append(leaves, b.Timestamp.Leaves()...)
+	}
+	return leaves
+}
+
+// IsComplete returns true if the timestamp has at least one Bitcoin attestation,
+// meaning it doesn't rely on any calendar server anymore.
+func (ts *Timestamp) IsComplete() bool {
+	for _, l := range ts.Leaves() {
+		if l.Attestation.Kind == KindBitcoin {
+			return true
+		}
+	}
+	return false
+}
+
+// Info is a summary of a timestamp, suitable for JSON output.
+type Info struct {
+	Status  string         `json:"status"` // "complete" or "pending"
+	Digest  string         `json:"digest"` // hex
+	Bitcoin []*BitcoinInfo `json:"bitcoin,omitempty"`
+	Pending []string       `json:"pending,omitempty"` // calendar URIs
+	Other   []string       `json:"other,omitempty"`   // unknown attestations
+}
+
+// BitcoinInfo describes a Bitcoin attestation.
+type BitcoinInfo struct {
+	Height uint64 `json:"height"`
+	// MerkleRoot is hex, in the reversed byte order used by block explorers
+	MerkleRoot string `json:"merkle_root"`
+
+	// Set by VerifyBitcoin
+	Verified  bool   `json:"verified,omitempty"`
+	BlockTime string `json:"block_time,omitempty"` // RFC 3339
+}
+
+// Info summarizes the timestamp.
+func (ts *Timestamp) Info() *Info {
+	info := &Info{Status: "pending", Digest: hex.EncodeToString(ts.Msg)}
+	for _, l := range ts.Leaves() {
+		switch l.Attestation.Kind {
+		case KindBitcoin:
+			info.Status = "complete"
+			info.Bitcoin = append(info.Bitcoin, &BitcoinInfo{
+				Height:     l.Attestation.Height,
+				MerkleRoot: hex.EncodeToString(reversed(l.Msg)),
+			})
+		case KindPending:
+			info.Pending = append(info.Pending, l.Attestation.URI)
+		default:
+			info.Other = append(info.Other, l.Attestation.String())
+		}
+	}
+	return info
+}
+
+// ParseAny parses either a .ots file or a bare timestamp, falling back to the latter
+// if the header is missing. msg is only used for bare timestamps.
+func ParseAny(b []byte, msg []byte) (*Timestamp, error) {
+	if bytes.HasPrefix(b, headerMagic) {
+		d, err := ParseDetached(b)
+		if err != nil {
+			return nil, err
+		}
+		return d.Timestamp, nil
+	}
+	if msg == nil {
+		return nil, ErrNotOTS
+	}
+	return ParseTimestamp(b, msg)
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func readVaruint(r io.ByteReader) (uint64, error) {
+	var v uint64
+	var shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= 64 {
+			return 0, fmt.Errorf("varuint overflows 64 bits")
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+}
+
+func readVarbytes(r *bufio.Reader, maxLen uint64) ([]byte, error) {
+	n, err := readVaruint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLen {
+		return nil, fmt.Errorf("length %d exceeds maximum of %d", n, maxLen)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeVaruint(buf *bytes.Buffer, v uint64) {
+	for v >= 0x80 {
+		buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	buf.WriteByte(byte(v))
+}
+
+func writeVarbytes(buf *bytes.Buffer, b []byte) {
+	writeVaruint(buf, uint64(len(b)))
+	buf.Write(b)
+}
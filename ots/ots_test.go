@@ -0,0 +1,180 @@
+package ots
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func bitcoinAttestation(height uint64) *Attestation {
+	var payload bytes.Buffer
+	writeVaruint(&payload, height)
+	return &Attestation{Kind: KindBitcoin, Tag: tagBitcoin, Height: height, Payload: payload.Bytes()}
+}
+
+func pendingAttestation(uri string) *Attestation {
+	var payload bytes.Buffer
+	writeVarbytes(&payload, []byte(uri))
+	return &Attestation{Kind: KindPending, Tag: tagPending, URI: uri, Payload: payload.Bytes()}
+}
+
+// chain builds a timestamp applying ops in order to msg, ending in the attestation.
+func chain(t *testing.T, msg []byte, a *Attestation, ops ...*Op) *Timestamp {
+	t.Helper()
+	root := &Timestamp{Msg: msg}
+	cur := root
+	for _, op := range ops {
+		result, err := op.Apply(cur.Msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := &Timestamp{Msg: result}
+		cur.Branches = append(cur.Branches, &Branch{Op: op, Timestamp: next})
+		cur = next
+	}
+	cur.Attestations = append(cur.Attestations, a)
+	return root
+}
+
+func testDigest() []byte {
+	h := sha256.Sum256([]byte("hello world\n"))
+	return h[:]
+}
+
+func TestRoundTrip(t *testing.T) {
+	digest := testDigest()
+	ts := chain(t, digest, bitcoinAttestation(358391),
+		&Op{Tag: OpAppend, Arg: []byte{0x01, 0x02}}, &Op{Tag: OpSHA256},
+		&Op{Tag: OpPrepend, Arg: []byte{0xaa}}, &Op{Tag: OpSHA256},
+	)
+	// Fork at the root, with a pending attestation
+	ts.Attestations = append(ts.Attestations, pendingAttestation("https://alice.btc.calendar.opentimestamps.org"))
+
+	d := &DetachedFile{HashOp: &Op{Tag: OpSHA256}, Timestamp: ts}
+	b := d.Serialize()
+
+	parsed, err := ParseDetached(b)
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+	if !bytes.Equal(parsed.Digest(), digest) {
+		t.Errorf("digest mismatch")
+	}
+	if !bytes.Equal(parsed.Serialize(), b) {
+		t.Errorf("re-serialized proof differs from original")
+	}
+
+	info := parsed.Timestamp.Info()
+	if info.Status != "complete" {
+		t.Errorf("got status %s, want complete", info.Status)
+	}
+	if len(info.Bitcoin) != 1 || info.Bitcoin[0].Height != 358391 {
+		t.Errorf("unexpected bitcoin attestations: %+v", info.Bitcoin)
+	}
+	if len(info.Pending) != 1 || info.Pending[0] != "https://alice.btc.calendar.opentimestamps.org" {
+		t.Errorf("unexpected pending attestations: %v", info.Pending)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := ParseDetached([]byte("not a proof")); err != ErrNotOTS {
+		t.Errorf("got %v, want ErrNotOTS", err)
+	}
+
+	ts := chain(t, testDigest(), bitcoinAttestation(1), &Op{Tag: OpSHA256})
+	b := (&DetachedFile{HashOp: &Op{Tag: OpSHA256}, Timestamp: ts}).Serialize()
+	if _, err := ParseDetached(b[:len(b)-1]); err == nil {
+		t.Error("expected error for truncated proof")
+	}
+	if _, err := ParseDetached(append(b, 0x00)); err == nil {
+		t.Error("expected error for trailing data")
+	}
+}
+
+func TestVerifyBitcoin(t *testing.T) {
+	ts := chain(t, testDigest(), bitcoinAttestation(100), &Op{Tag: OpSHA256})
+	leaf := ts.Leaves()[0]
+
+	header := make([]byte, blockHeaderSize)
+	copy(header[36:68], leaf.Msg)
+	binary.LittleEndian.PutUint32(header[68:72], 1700000000)
+
+	info := ts.Info()
+	if err := VerifyBitcoin(info, BlockHeaders{100: header}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !info.Bitcoin[0].Verified || info.Bitcoin[0].BlockTime != "2023-11-14T22:13:20Z" {
+		t.Errorf("unexpected result: %+v", info.Bitcoin[0])
+	}
+
+	// Missing header leaves it unverified
+	info = ts.Info()
+	if err := VerifyBitcoin(info, BlockHeaders{}); err != nil || info.Bitcoin[0].Verified {
+		t.Errorf("got (%v, %v), want unverified without error", info.Bitcoin[0].Verified, err)
+	}
+
+	header[40] ^= 0xff
+	if err := VerifyBitcoin(ts.Info(), BlockHeaders{100: header}); err == nil {
+		t.Error("expected merkle root mismatch")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	digest := testDigest()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/broken/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path != "/timestamp/"+hex.EncodeToString(digest) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		upgraded := chain(t, digest, bitcoinAttestation(200), &Op{Tag: OpSHA256})
+		_, _ = w.Write(upgraded.Serialize())
+	}))
+	defer srv.Close()
+	defer func(c *http.Client) { client = c }(client)
+	client = srv.Client()
+
+	ts := &Timestamp{Msg: digest, Attestations: []*Attestation{pendingAttestation(srv.URL)}}
+	changed, err := ts.Upgrade(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed || !ts.IsComplete() {
+		t.Fatal("expected timestamp to be upgraded to complete")
+	}
+	if len(ts.Attestations) != 0 {
+		t.Errorf("pending attestation should be removed after upgrade, got %v", ts.Attestations)
+	}
+
+	// Still pending: nothing changes
+	other := &Timestamp{Msg: []byte("other"), Attestations: []*Attestation{pendingAttestation(srv.URL)}}
+	changed, err = other.Upgrade(context.Background())
+	if err != nil || changed {
+		t.Errorf("got (%v, %v), want no change without error", changed, err)
+	}
+
+	// A failing calendar doesn't stop the others from being upgraded
+	partial := &Timestamp{Msg: digest, Attestations: []*Attestation{
+		pendingAttestation(srv.URL + "/broken"), pendingAttestation(srv.URL),
+	}}
+	changed, err = partial.Upgrade(context.Background())
+	if err == nil || !changed || !partial.IsComplete() {
+		t.Errorf("got (%v, %v), want the working calendar upgraded and an error", changed, err)
+	}
+
+	insecure := &Timestamp{Msg: digest, Attestations: []*Attestation{
+		pendingAttestation(strings.Replace(srv.URL, "https", "http", 1)),
+	}}
+	if _, err := insecure.Upgrade(context.Background()); err == nil {
+		t.Error("expected error for non-HTTPS calendar")
+	}
+}
@@ -0,0 +1,209 @@
+package ots
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	cid         string
+	attr        string
+	proofmode   bool
+	isEncrypted bool
+	encKeyPath  string
+	headersPath string
+	upgrade     bool
+	output      string
+	jsonOutput  bool
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("ots", flag.ContinueOnError)
+	fs.StringVar(&cid, "cid", "", "inspect a proof stored in AA for this CID, instead of a file")
+	fs.StringVar(&attr, "attr", "", "with --cid, inspect the AA timestamp of this attribute's attestation")
+	fs.BoolVar(&proofmode, "proofmode", false, "with --cid, inspect the .ots file from the proofmode attribute")
+	fs.BoolVar(&isEncrypted, "encrypted", false, "attribute is encrypted (proofmode always is)")
+	fs.StringVar(&encKeyPath, "key", "", "(optional) manual path to encryption key file, implies --encrypted")
+	fs.StringVar(&headersPath, "headers", "", "file of Bitcoin block headers to verify against offline")
+	fs.BoolVar(&upgrade, "upgrade", false, "ask calendar servers to complete pending proofs")
+	fs.StringVar(&output, "o", "", "with --upgrade, path to write the upgraded proof to")
+	fs.BoolVar(&jsonOutput, "json", false, "output information as JSON")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	// Validate flags
+	if cid == "" && fs.NArg() != 1 {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nprovide a path to a .ots file, or use --cid")
+	}
+	if cid != "" && fs.NArg() != 0 {
+		return fmt.Errorf("can't provide a file path and --cid together")
+	}
+	if cid != "" && (attr == "") == !proofmode {
+		return fmt.Errorf("with --cid, use exactly one of --attr or --proofmode")
+	}
+	if upgrade && output == "" {
+		return fmt.Errorf("provide an output path for the upgraded proof with -o")
+	}
+
+	var proof, msg []byte
+	if cid == "" {
+		proof, err = os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("error reading proof: %w", err)
+		}
+	} else {
+		proof, msg, err = proofFromAA()
+		if err != nil {
+			return err
+		}
+	}
+
+	ts, err := ParseAny(proof, msg)
+	if err != nil {
+		return fmt.Errorf("error parsing proof: %w", err)
+	}
+
+	if upgrade {
+		changed, upgradeErr := ts.Upgrade(context.Background())
+		if changed {
+			var b []byte
+			if d, err := ParseDetached(proof); err == nil {
+				d.Timestamp = ts
+				b = d.Serialize()
+			} else {
+				b = ts.Serialize()
+			}
+			if err := os.WriteFile(output, b, 0644); err != nil {
+				return fmt.Errorf("error writing upgraded proof: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote upgraded proof to %s\n", output)
+		} else if upgradeErr == nil {
+			fmt.Fprintln(os.Stderr, "No upgrades available yet, nothing written.")
+		}
+		if upgradeErr != nil {
+			return fmt.Errorf("error upgrading proof: %w", upgradeErr)
+		}
+	}
+
+	info := ts.Info()
+	if headersPath != "" {
+		headers, err := LoadBlockHeaders(headersPath)
+		if err != nil {
+			return fmt.Errorf("error loading block headers: %w", err)
+		}
+		if err := VerifyBitcoin(info, headers); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+	}
+
+	if jsonOutput {
+		b, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding info as JSON: %w", err)
+		}
+		os.Stdout.Write(b)
+		fmt.Println()
+		return nil
+	}
+
+	fmt.Printf("Status: %s\n", info.Status)
+	fmt.Printf("Digest: %s\n", info.Digest)
+	for _, bi := range info.Bitcoin {
+		fmt.Printf("Bitcoin block %d, merkle root %s\n", bi.Height, bi.MerkleRoot)
+		if bi.Verified {
+			fmt.Printf("  verified against block header, block time %s\n", bi.BlockTime)
+		} else if headersPath != "" {
+			fmt.Println("  not verified, block header not provided")
+		}
+	}
+	for _, uri := range info.Pending {
+		fmt.Printf("Pending at %s\n", uri)
+	}
+	for _, s := range info.Other {
+		fmt.Println(s)
+	}
+	return nil
+}
+
+// proofFromAA returns the proof bytes according to the --cid flags. msg is returned
+// as well if the proof might be a bare timestamp.
+func proofFromAA() (proof []byte, msg []byte, err error) {
+	var encKey []byte
+	if encKeyPath != "" {
+		encKey, err = util.ReadKeyFile(encKeyPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading key: %w", err)
+		}
+	} else if isEncrypted || proofmode {
+		keyAttr := attr
+		if proofmode {
+			keyAttr = "proofmode"
+		}
+		_, encKey, _, err = util.GenerateEncKey(cid, keyAttr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading key: %w", err)
+		}
+	}
+
+	if proofmode {
+		ae, err := aa.GetAttestation(cid, "proofmode", aa.GetAttOpts{EncKey: encKey})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting proofmode attestation: %w", err)
+		}
+		m, ok := ae.Attestation.Value.(map[string]any)
+		if !ok {
+			return nil, nil, fmt.Errorf("schema error: proofmode is not a map")
+		}
+		proof, ok := m["ots"].([]byte)
+		if !ok {
+			return nil, nil, fmt.Errorf("schema error: proofmode has no ots bytes")
+		}
+		return proof, nil, nil
+	}
+
+	ae, err := aa.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: encKey})
+	if errors.Is(err, aa.ErrNeedsKey) {
+		return nil, nil, fmt.Errorf("error attestation is encrypted, use --encrypted or --key")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting attestation: %w", err)
+	}
+	return ae.Timestamp.OTS.Proof, AttestationMsg(ae), nil
+}
+
+// AttestationMsg returns the message AA timestamped for the attestation, as bytes.
+// It's used as the starting point when the proof is a bare timestamp.
+func AttestationMsg(ae *aa.AttEntry) []byte {
+	m := strings.TrimSpace(ae.Timestamp.OTS.Msg)
+	if b, err := hex.DecodeString(m); err == nil {
+		return b
+	}
+	return []byte(m)
+}
+
+// AttestationInfo returns a summary of the attestation's OpenTimestamps proof, or
+// nil if it has none.
+func AttestationInfo(ae *aa.AttEntry) (*Info, error) {
+	if len(ae.Timestamp.OTS.Proof) == 0 {
+		return nil, nil
+	}
+	ts, err := ParseAny(ae.Timestamp.OTS.Proof, AttestationMsg(ae))
+	if err != nil {
+		return nil, err
+	}
+	return ts.Info(), nil
+}
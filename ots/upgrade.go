@@ -0,0 +1,132 @@
+package ots
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// Upgrade contacts the calendar server of every pending attestation, asking for the
+// rest of the proof. Calendar responses are merged into the timestamp, and pending
+// attestations that now lead to a Bitcoin attestation are removed.
+//
+// It returns true if the timestamp was changed. Calendars that don't have a completed
+// proof yet are not an error. Calendars that fail don't stop the others from being
+// tried, so the timestamp can be changed even if an error is returned.
+func (ts *Timestamp) Upgrade(ctx context.Context) (bool, error) {
+	changed := false
+	var errs []error
+
+	var pending []*Attestation
+	for _, a := range ts.Attestations {
+		if a.Kind == KindPending {
+			pending = append(pending, a)
+		}
+	}
+	for _, a := range pending {
+		upgraded, err := fetchFromCalendar(ctx, a.URI, ts.Msg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if upgraded == nil {
+			// Not ready yet
+			continue
+		}
+		ts.merge(upgraded)
+		changed = true
+		if upgraded.IsComplete() {
+			ts.removeAttestation(a)
+		}
+	}
+
+	for _, b := range ts.Branches {
+		c, err := b.Timestamp.Upgrade(ctx)
+		if c {
+			changed = true
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return changed, errors.Join(errs...)
+}
+
+// fetchFromCalendar returns the timestamp the calendar has for msg, or nil if the
+// calendar doesn't have a complete one yet.
+func fetchFromCalendar(ctx context.Context, uri string, msg []byte) (*Timestamp, error) {
+	if !strings.HasPrefix(uri, "https://") {
+		return nil, fmt.Errorf("refusing to contact non-HTTPS calendar: %s", uri)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		strings.TrimSuffix(uri, "/")+"/timestamp/"+hex.EncodeToString(msg), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/vnd.opentimestamps.v1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting calendar %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("calendar %s returned status code %d", uri, resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10000))
+	if err != nil {
+		return nil, fmt.Errorf("error reading calendar response: %w", err)
+	}
+	ts, err := ParseTimestamp(b, msg)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing calendar response: %w", err)
+	}
+	return ts, nil
+}
+
+// merge adds the attestations and branches of other into ts. Both must be for the
+// same message.
+func (ts *Timestamp) merge(other *Timestamp) {
+outer:
+	for _, a := range other.Attestations {
+		for _, existing := range ts.Attestations {
+			if existing.Tag == a.Tag && string(existing.Payload) == string(a.Payload) {
+				continue outer
+			}
+		}
+		ts.Attestations = append(ts.Attestations, a)
+	}
+	for _, b := range other.Branches {
+		found := false
+		for _, existing := range ts.Branches {
+			if existing.Op.equal(b.Op) {
+				existing.Timestamp.merge(b.Timestamp)
+				found = true
+				break
+			}
+		}
+		if !found {
+			ts.Branches = append(ts.Branches, b)
+		}
+	}
+}
+
+func (ts *Timestamp) removeAttestation(a *Attestation) {
+	for i, existing := range ts.Attestations {
+		if existing == a {
+			ts.Attestations = append(ts.Attestations[:i], ts.Attestations[i+1:]...)
+			return
+		}
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/starlinglab/integrity-v2/aa"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
 	"github.com/starlinglab/integrity-v2/nectar"
 	"github.com/starlinglab/integrity-v2/util"
 )
@@ -18,8 +18,8 @@ import (
 // tests can substitute an in-memory fake instead of going through config/network, the same way
 // computeImagePFP (webhook/file.go) takes its config explicitly rather than reading globals.
 type aaClient interface {
-	GetAttestation(cid, attr string, opts aa.GetAttOpts) (*aa.AttEntry, error)
-	SetAttestations(cid string, index bool, kvs []aa.PostKV) error
+	GetAttestationCtx(ctx context.Context, cid, attr string, opts aa.GetAttOpts) (*aa.AttEntry, error)
+	SetAttestationsCtx(ctx context.Context, cid string, index bool, kvs []aa.PostKV) error
 }
 
 func Run(args []string) error {
@@ -56,7 +56,7 @@ func computeAndSetPFP(ctx context.Context, conf *config.Config, aaInst aaClient,
 	}
 
 	if !force {
-		ae, err := aaInst.GetAttestation(cid, "pfp", aa.GetAttOpts{})
+		ae, err := aaInst.GetAttestationCtx(ctx, cid, "pfp", aa.GetAttOpts{})
 		if err != nil && !errors.Is(err, aa.ErrNotFound) {
 			return "", fmt.Errorf("checking for existing pfp attestation: %w", err)
 		}
@@ -65,10 +65,14 @@ func computeAndSetPFP(ctx context.Context, conf *config.Config, aaInst aaClient,
 		}
 	}
 
-	cidPath := filepath.Join(conf.Dirs.Files, cid)
-	if _, err := os.Stat(cidPath); err != nil {
+	store, err := filestore.FromConfig(conf)
+	if err != nil {
+		return "", err
+	}
+	if _, err := store.Stat(cid); err != nil {
 		return "", fmt.Errorf("file not found for CID %s: %w", cid, err)
 	}
+	cidPath := store.Path(cid)
 
 	mediaType, err := util.GuessMediaType(cidPath)
 	if err != nil {
@@ -83,7 +87,7 @@ func computeAndSetPFP(ctx context.Context, conf *config.Config, aaInst aaClient,
 		return "", fmt.Errorf("computing pfp: %w", err)
 	}
 
-	if err := aaInst.SetAttestations(cid, true, []aa.PostKV{{Key: "pfp", Value: pfpVal, Type: "str"}}); err != nil {
+	if err := aaInst.SetAttestationsCtx(ctx, cid, true, []aa.PostKV{{Key: "pfp", Value: pfpVal, Type: "str"}}); err != nil {
 		return "", fmt.Errorf("setting pfp attestation: %w", err)
 	}
 
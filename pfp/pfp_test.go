@@ -27,7 +27,7 @@ type fakeAA struct {
 	setCalls []aa.PostKV
 }
 
-func (f *fakeAA) GetAttestation(cid, attr string, opts aa.GetAttOpts) (*aa.AttEntry, error) {
+func (f *fakeAA) GetAttestationCtx(ctx context.Context, cid, attr string, opts aa.GetAttOpts) (*aa.AttEntry, error) {
 	f.getCalls++
 	if f.getErr != nil {
 		return nil, f.getErr
@@ -38,7 +38,7 @@ func (f *fakeAA) GetAttestation(cid, attr string, opts aa.GetAttOpts) (*aa.AttEn
 	return f.existing, nil
 }
 
-func (f *fakeAA) SetAttestations(cid string, index bool, kvs []aa.PostKV) error {
+func (f *fakeAA) SetAttestationsCtx(ctx context.Context, cid string, index bool, kvs []aa.PostKV) error {
 	f.setCalls = append(f.setCalls, kvs...)
 	return f.setErr
 }
@@ -108,13 +108,19 @@ func Run(args []string) error {
 		requestData["nftChainID"] = chainID
 	}
 
+	// Get all attributes at once rather than one request per attribute
+	atts, err := aa.GetAttestations(cid)
+	if err != nil {
+		return fmt.Errorf("error getting attestations: %w", err)
+	}
+
 	var attrNames []string
 	if include != "" {
 		attrNames = strings.Split(include, ",")
 		metadata := make(map[string]any)
 		for _, attr := range attrNames {
 			var err error
-			metadata[attr], err = getAttValue(cid, attr)
+			metadata[attr], err = getAttValue(atts, attr)
 			if err != nil {
 				return err
 			}
@@ -124,16 +130,16 @@ func Run(args []string) error {
 
 	// Required fields
 
-	requestData["encodingFormat"], err = getAttValue(cid, "media_type")
+	requestData["encodingFormat"], err = getAttValue(atts, "media_type")
 	if err != nil {
 		return err
 	}
-	requestData["assetSha256"], err = getAttValue(cid, "sha256")
+	requestData["assetSha256"], err = getAttValue(atts, "sha256")
 	if err != nil {
 		return err
 	}
 
-	tmp, err := getAttValue(cid, "time_created")
+	tmp, err := getAttValue(atts, "time_created")
 	if err != nil {
 		return err
 	}
@@ -153,11 +159,11 @@ func Run(args []string) error {
 	if conf.Numbers.NftContractAddress != "" {
 		requestData["nftContractAddress"] = conf.Numbers.NftContractAddress
 	}
-	requestData["abstract"], err = getAttValue(cid, "description")
+	requestData["abstract"], err = getAttValue(atts, "description")
 	if err != nil && !errors.Is(err, aa.ErrNotFound) {
 		return err
 	}
-	requestData["headline"], err = getAttValue(cid, "name")
+	requestData["headline"], err = getAttValue(atts, "name")
 	if err != nil && !errors.Is(err, aa.ErrNotFound) {
 		return err
 	}
@@ -269,10 +275,13 @@ type aaRegistration struct {
 	Data  any      `cbor:"data"`
 }
 
-func getAttValue(cid string, attr string) (any, error) {
-	att, err := aa.GetAttestation(cid, attr, aa.GetAttOpts{})
-	if err != nil {
-		return nil, fmt.Errorf("error getting attestation '%s': %w", attr, err)
+func getAttValue(atts map[string]*aa.AttEntry, attr string) (any, error) {
+	att, ok := atts[attr]
+	if !ok {
+		return nil, fmt.Errorf("error getting attestation '%s': %w", attr, aa.ErrNotFound)
+	}
+	if att.Attestation.Encrypted {
+		return nil, fmt.Errorf("error getting attestation '%s': %w", attr, aa.ErrNeedsKey)
 	}
 	return att.Attestation.Value, nil
 }
@@ -12,6 +12,9 @@ var (
 	relType string
 	parent  string
 	child   string
+	remove  bool
+	replace string
+	oldType string
 )
 
 func Run(args []string) error {
@@ -19,6 +22,9 @@ func Run(args []string) error {
 	fs.StringVar(&relType, "type", "", "relationship word/type like 'related', 'verifies', '123', etc.")
 	fs.StringVar(&parent, "parent", "", "CID of parent")
 	fs.StringVar(&child, "child", "", "CID of child")
+	fs.BoolVar(&remove, "remove", false, "remove the relationship instead of adding it")
+	fs.StringVar(&replace, "replace", "", "CID of a wrong child to remove, as the relationship with --child is added")
+	fs.StringVar(&oldType, "old-type", "", "with --replace, the type of the wrong relationship if it differs from --type")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -28,14 +34,87 @@ func Run(args []string) error {
 
 	if relType == "" || parent == "" || child == "" {
 		fs.PrintDefaults()
-		return fmt.Errorf("\nall flags must be used")
+		return fmt.Errorf("\n--type, --parent and --child must be used")
 	}
+	if remove && replace != "" {
+		return fmt.Errorf("can't use --remove and --replace together")
+	}
+	if oldType != "" && replace == "" {
+		return fmt.Errorf("--old-type can only be used with --replace")
+	}
+	if oldType == "" {
+		oldType = relType
+	}
+
+	a := aa.GetAAInstanceFromConfig()
+	if remove {
+		return removeRelationship(a, parent, relType, child)
+	}
+	return addRelationship(a, parent, relType, child, replace, oldType)
+}
+
+// removeRelationship removes an existing relationship of the parent.
+func removeRelationship(a *aa.AuthAttrInstance, parent, relType, child string) error {
+	rels, err := a.GetEffectiveRelationships(parent)
+	if err != nil {
+		return fmt.Errorf("error getting relationships from AuthAttr: %w", err)
+	}
+	if !rels.Has("children", relType, child) {
+		return fmt.Errorf("relationship doesn't exist, nothing to remove")
+	}
+	err = a.RemoveRelationship(parent, "children", relType, child)
+	if err != nil {
+		return fmt.Errorf("error removing relationship in AuthAttr: %w", err)
+	}
+	fmt.Println("Removed relationship in AuthAttr.")
+	return nil
+}
 
-	err = aa.AddRelationship(parent, "children", relType, child)
+// addRelationship adds a relationship to the parent. If replace isn't empty, the
+// relationship of type oldType to that child is removed after the new one is added,
+// so a failure never leaves the parent with neither.
+func addRelationship(a *aa.AuthAttrInstance, parent, relType, child, replace, oldType string) error {
+	rels, err := a.GetEffectiveRelationships(parent)
 	if err != nil {
-		return fmt.Errorf("error adding relationship to AuthAttr: %w", err)
+		return fmt.Errorf("error getting relationships from AuthAttr: %w", err)
+	}
+	if replace != "" {
+		if replace == child && oldType == relType {
+			return fmt.Errorf("nothing to replace, the old and new relationships are the same")
+		}
+		if !rels.Has("children", oldType, replace) {
+			return fmt.Errorf("relationship to replace doesn't exist")
+		}
+	}
+
+	if rels.Has("children", relType, child) {
+		fmt.Println("Relationship already exists in AuthAttr.")
+	} else {
+		err = a.AddRelationship(parent, "children", relType, child)
+		if err != nil {
+			return fmt.Errorf("error adding relationship to AuthAttr: %w", err)
+		}
+		// If the relationship was removed before, adding it again doesn't change
+		// anything in AA, and it has to be restored instead
+		rels, err = a.GetEffectiveRelationships(parent)
+		if err != nil {
+			return fmt.Errorf("error getting relationships from AuthAttr: %w", err)
+		}
+		if !rels.Has("children", relType, child) {
+			err = a.RestoreRelationship(parent, "children", relType, child)
+			if err != nil {
+				return fmt.Errorf("error restoring relationship in AuthAttr: %w", err)
+			}
+		}
+		fmt.Println("Added relationship to AuthAttr.")
 	}
 
-	fmt.Println("Added relationship to AuthAttr.")
+	if replace != "" {
+		err = a.RemoveRelationship(parent, "children", oldType, replace)
+		if err != nil {
+			return fmt.Errorf("error removing old relationship in AuthAttr: %w", err)
+		}
+		fmt.Println("Removed old relationship in AuthAttr.")
+	}
 	return nil
 }
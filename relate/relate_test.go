@@ -0,0 +1,86 @@
+package relate
+
+import (
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+	cid3 = "bafkreiaxnnnb7qz2focittuqq3ya25q7rcv3bqynnczfzao2vlrjnvpmea"
+)
+
+func getRels(t *testing.T, a *aa.AuthAttrInstance) *aa.Relationships {
+	t.Helper()
+	rels, err := a.GetEffectiveRelationships(cid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rels
+}
+
+func TestRemoveAndRestore(t *testing.T) {
+	_, a := aatest.Start(t)
+
+	if err := removeRelationship(a, cid1, "related", cid2); err == nil {
+		t.Fatal("removed relationship that doesn't exist")
+	}
+	if err := addRelationship(a, cid1, "related", cid2, "", "related"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeRelationship(a, cid1, "related", cid2); err != nil {
+		t.Fatal(err)
+	}
+	if getRels(t, a).Has("children", "related", cid2) {
+		t.Fatal("relationship wasn't removed")
+	}
+	if err := removeRelationship(a, cid1, "related", cid2); err == nil {
+		t.Fatal("removed relationship twice")
+	}
+
+	// Adding it again restores it
+	if err := addRelationship(a, cid1, "related", cid2, "", "related"); err != nil {
+		t.Fatal(err)
+	}
+	if !getRels(t, a).Has("children", "related", cid2) {
+		t.Fatal("relationship wasn't restored")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	_, a := aatest.Start(t)
+	if err := addRelationship(a, cid1, "related", cid2, "", "related"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addRelationship(a, cid1, "related", cid3, cid3, "related"); err == nil {
+		t.Fatal("replaced relationship that doesn't exist")
+	}
+	if err := addRelationship(a, cid1, "related", cid2, cid2, "related"); err == nil {
+		t.Fatal("replaced relationship with itself")
+	}
+	if getRels(t, a).Has("children", "related", cid3) {
+		t.Fatal("relationship added despite the error")
+	}
+
+	// Wrong child
+	if err := addRelationship(a, cid1, "related", cid3, cid2, "related"); err != nil {
+		t.Fatal(err)
+	}
+	rels := getRels(t, a)
+	if rels.Has("children", "related", cid2) || !rels.Has("children", "related", cid3) {
+		t.Fatalf("child wasn't replaced: %v", rels.Children)
+	}
+
+	// Wrong type, with --old-type
+	if err := addRelationship(a, cid1, "verifies", cid3, cid3, "related"); err != nil {
+		t.Fatal(err)
+	}
+	rels = getRels(t, a)
+	if rels.Has("children", "related", cid3) || !rels.Has("children", "verifies", cid3) {
+		t.Fatalf("type wasn't replaced: %v", rels.Children)
+	}
+}
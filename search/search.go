@@ -8,7 +8,9 @@ import (
 	"github.com/starlinglab/integrity-v2/aa"
 )
 
-const helpText = `search attr <cid>
+const helpText = `Add --no-cache to any command to ignore the local cache of attributes.
+
+search attr <cid>
 <list of all the attribute names>
 
 search cids
@@ -21,6 +23,10 @@ search index my_attr my_value
 <all the CIDs that have that key-value pair>`
 
 func Run(args []string) error {
+	if i := slices.Index(args, "--no-cache"); i != -1 {
+		aa.DisableCache()
+		args = slices.Delete(args, i, i+1)
+	}
 	if len(args) == 0 {
 		fmt.Println(helpText)
 		return nil
@@ -0,0 +1,182 @@
+package share
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/age"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+// SharesAttr is the attribute that records the keys of encrypted attributes shared
+// with other parties. Each key is encrypted for its recipient, so only they can use it.
+const SharesAttr = "key_shares"
+
+// Share is a single entry of the SharesAttr array.
+type Share struct {
+	Attribute string `cbor:"attribute"`
+	Recipient string `cbor:"recipient"` // age public key
+	Timestamp string `cbor:"timestamp"`
+	Key       []byte `cbor:"key"` // Attribute key, age encrypted for the recipient
+}
+
+var (
+	attr    string
+	to      string
+	outPath string
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	fs.StringVar(&attr, "attr", "", "name of the encrypted attribute to share")
+	fs.StringVar(&to, "to", "", "age public key (age1...) of the recipient")
+	fs.StringVar(&outPath, "o", "", "(optional) also write the encrypted key to this file, for the recipient to decrypt with age")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	if attr == "" || to == "" {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nmust specify --attr and --to")
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single CID to work with")
+	}
+	cid := fs.Arg(0)
+
+	recipient, err := age.ParseRecipient(to)
+	if err != nil {
+		return err
+	}
+	key, err := util.ReadEncKey(cid, attr)
+	if err != nil {
+		return fmt.Errorf("error reading key: %w", err)
+	}
+	if key == nil {
+		return fmt.Errorf("no key found for this attribute in the key store")
+	}
+
+	a := aa.GetAAInstanceFromConfig()
+	s, err := share(a, cid, attr, key, recipient, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Shared %s with %s\n", attr, recipient)
+
+	if outPath != "" {
+		if err := os.WriteFile(outPath, s.Key, 0644); err != nil {
+			return fmt.Errorf("error writing encrypted key: %w", err)
+		}
+		fmt.Printf("Wrote encrypted key to %s\n", outPath)
+	}
+	return nil
+}
+
+// share encrypts the key for the recipient and appends it to SharesAttr. The key is
+// checked against the attestation first, so a wrong key is never shared.
+func share(a *aa.AuthAttrInstance, cid, attr string, key []byte, recipient *age.Recipient, t time.Time) (*Share, error) {
+	ae, err := a.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: key})
+	if errors.Is(err, aa.ErrBadKey) {
+		return nil, fmt.Errorf("stored key for %s is wrong, not sharing it", attr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting attestation: %w", err)
+	}
+	if !ae.Attestation.Encrypted {
+		return nil, fmt.Errorf("%s is not encrypted, there is nothing to share", attr)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting key: %w", err)
+	}
+	if _, err := w.Write(key); err != nil {
+		return nil, fmt.Errorf("error encrypting key: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("error encrypting key: %w", err)
+	}
+
+	s := &Share{
+		Attribute: attr,
+		Recipient: recipient.String(),
+		Timestamp: t.UTC().Format(time.RFC3339),
+		Key:       buf.Bytes(),
+	}
+	if err := a.AppendAttestation(cid, SharesAttr, s); err != nil {
+		return nil, fmt.Errorf("error logging share to AuthAttr: %w", err)
+	}
+	return s, nil
+}
+
+// GetShares returns the shares recorded for the CID, oldest first.
+func GetShares(a *aa.AuthAttrInstance, cid string) ([]Share, error) {
+	data, err := a.GetAttestationRaw(cid, SharesAttr, aa.GetAttOpts{})
+	if errors.Is(err, aa.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Attestation struct {
+			Value []Share `cbor:"value"`
+		} `cbor:"attestation"`
+	}
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s attribute for %s: %w", SharesAttr, cid, err)
+	}
+	return v.Attestation.Value, nil
+}
+
+// FindKey returns the key of the attribute from the most recent share for one of
+// the identities that still decrypts it. Keys shared before the key was rotated
+// are skipped.
+func FindKey(a *aa.AuthAttrInstance, cid, attr string, identities []*age.Identity) ([]byte, error) {
+	shares, err := GetShares(a, cid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting shares: %w", err)
+	}
+	recipients := make(map[string]bool, len(identities))
+	for _, id := range identities {
+		recipients[id.Recipient().String()] = true
+	}
+	stale := false
+	for i := len(shares) - 1; i >= 0; i-- {
+		s := shares[i]
+		if s.Attribute != attr || !recipients[s.Recipient] {
+			continue
+		}
+		r, err := age.Decrypt(bytes.NewReader(s.Key), identities...)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting shared key: %w", err)
+		}
+		key, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting shared key: %w", err)
+		}
+		_, err = a.GetAttestationRaw(cid, attr, aa.GetAttOpts{EncKey: key})
+		if errors.Is(err, aa.ErrBadKey) {
+			stale = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error checking shared key: %w", err)
+		}
+		return key, nil
+	}
+	if stale {
+		return nil, fmt.Errorf("keys shared for %s no longer decrypt it, it was probably rotated and must be shared again", attr)
+	}
+	return nil, fmt.Errorf("%s has not been shared with this identity", attr)
+}
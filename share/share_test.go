@@ -0,0 +1,105 @@
+package share
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/age"
+)
+
+const testCid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+
+func TestShare(t *testing.T) {
+	_, a := aatest.Start(t)
+	key := make([]byte, 32)
+	rand.Read(key)
+	err := a.SetAttestations(testCid, false, []aa.PostKV{
+		{Key: "secret", Value: "hello", EncKey: key},
+		{Key: "public", Value: "hi"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	partner, _ := age.GenerateIdentity()
+	other, _ := age.GenerateIdentity()
+
+	if _, err := share(a, testCid, "public", key, partner.Recipient(), time.Now()); err == nil {
+		t.Fatal("unencrypted attribute was shared")
+	}
+	wrongKey := make([]byte, 32)
+	if _, err := share(a, testCid, "secret", wrongKey, partner.Recipient(), time.Now()); err == nil {
+		t.Fatal("wrong key was shared")
+	}
+	if _, err := FindKey(a, testCid, "secret", []*age.Identity{partner}); err == nil {
+		t.Fatal("found a key before sharing")
+	}
+
+	s, err := share(a, testCid, "secret", key, partner.Recipient(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(s.Key, key) {
+		t.Fatal("key is not encrypted")
+	}
+	// The key written with -o can be decrypted with age directly
+	r, err := age.Decrypt(bytes.NewReader(s.Key), partner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); !bytes.Equal(b, key) {
+		t.Fatal("decrypted key doesn't match")
+	}
+
+	got, err := FindKey(a, testCid, "secret", []*age.Identity{other, partner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("found key doesn't match")
+	}
+	ae, err := a.GetAttestation(testCid, "secret", aa.GetAttOpts{EncKey: got})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ae.Attestation.Value != "hello" {
+		t.Fatalf("decrypted value is %v", ae.Attestation.Value)
+	}
+
+	if _, err := FindKey(a, testCid, "secret", []*age.Identity{other}); err == nil {
+		t.Fatal("found a key for an identity it wasn't shared with")
+	}
+	if _, err := FindKey(a, testCid, "public", []*age.Identity{partner}); err == nil {
+		t.Fatal("found a key for an attribute that wasn't shared")
+	}
+
+	// Like "genkey rotate"
+	newKey := make([]byte, 32)
+	rand.Read(newKey)
+	if err := a.SetAttestations(testCid, false, []aa.PostKV{{Key: "secret", Value: "hello", EncKey: newKey}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindKey(a, testCid, "secret", []*age.Identity{partner}); err == nil {
+		t.Fatal("found a stale key")
+	}
+	if _, err := share(a, testCid, "secret", newKey, partner.Recipient(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// A stale share recorded later is skipped
+	stale := *s
+	stale.Timestamp = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if err := a.AppendAttestation(testCid, SharesAttr, &stale); err != nil {
+		t.Fatal(err)
+	}
+	got, err = FindKey(a, testCid, "secret", []*age.Identity{partner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, newKey) {
+		t.Fatal("found key isn't the rotated one")
+	}
+}
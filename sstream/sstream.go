@@ -0,0 +1,180 @@
+// Package sstream encrypts and decrypts files with libsodium's secretstream, in the
+// format written by "file encrypt".
+//
+// A file is the 24 byte stream header, followed by messages of ChunkSize bytes of
+// plaintext, each StreamABytes longer once encrypted. The last message is tagged
+// as final and may be shorter, or empty.
+//
+// See https://doc.libsodium.org/secret-key_cryptography/secretstream
+package sstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/openziti/secretstream"
+)
+
+const (
+	KeySize   = secretstream.StreamKeyBytes
+	ChunkSize = 32768 // 32 KiB
+
+	encChunkSize = ChunkSize + secretstream.StreamABytes
+)
+
+var (
+	ErrTruncated = errors.New("encrypted stream is truncated")
+	ErrTrailing  = errors.New("encrypted stream ended before the file did")
+)
+
+type writer struct {
+	enc secretstream.Encryptor
+	dst io.Writer
+	buf []byte
+	err error
+}
+
+// NewWriter returns a writer that encrypts what's written to it with the key, and
+// writes it to dst. The header is written immediately. Close must be called to write
+// the final message, it doesn't close dst.
+func NewWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	enc, header, err := secretstream.NewEncryptor(key)
+	if err != nil {
+		return nil, fmt.Errorf("error starting encryption: %w", err)
+	}
+	if _, err := dst.Write(header); err != nil {
+		return nil, err
+	}
+	return &writer{enc: enc, dst: dst, buf: make([]byte, 0, ChunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only pushed once more data arrives, because the last
+		// message must be tagged final, and can be full
+		if len(w.buf) == ChunkSize {
+			if err := w.push(secretstream.TagMessage); err != nil {
+				w.err = err
+				return n, err
+			}
+		}
+		c := min(ChunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:c]...)
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (w *writer) push(tag byte) error {
+	cipher, err := w.enc.Push(w.buf, tag)
+	if err != nil {
+		return fmt.Errorf("error encrypting data: %w", err)
+	}
+	if _, err := w.dst.Write(cipher); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the final message.
+func (w *writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.push(secretstream.TagFinal)
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("write to closed secretstream writer")
+	return nil
+}
+
+type reader struct {
+	dec secretstream.Decryptor
+	src io.Reader
+	// One extra byte, to know if a full message is the last one
+	buf     []byte
+	pending bool // buf[0] holds the extra byte from the previous read
+	unread  []byte
+	done    bool
+	err     error
+}
+
+// NewReader reads the header from src, and returns a reader of the decrypted data.
+//
+// Messages are read whole no matter how src splits up reads. Reads return
+// ErrTruncated or ErrTrailing if the stream doesn't end with the file, or an error if
+// a message doesn't decrypt. Nothing read should be trusted until io.EOF.
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, secretstream.StreamHeaderBytes)
+	if _, err := io.ReadFull(src, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+	dec, err := secretstream.NewDecryptor(key, header)
+	if err != nil {
+		return nil, fmt.Errorf("error starting decryption: %w", err)
+	}
+	return &reader{dec: dec, src: src, buf: make([]byte, encChunkSize+1)}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.unread) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.readMessage()
+	}
+	n := copy(p, r.unread)
+	r.unread = r.unread[n:]
+	return n, nil
+}
+
+func (r *reader) readMessage() error {
+	start := 0
+	if r.pending {
+		start = 1
+	}
+	n, err := io.ReadFull(r.src, r.buf[start:])
+	n += start
+	atEOF := false
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		atEOF = true
+	case err != nil:
+		return err
+	}
+	if n == 0 {
+		return ErrTruncated
+	}
+
+	plain, tag, err := r.dec.Pull(r.buf[:min(n, encChunkSize)])
+	if err != nil {
+		return fmt.Errorf("error decrypting data: %w", err)
+	}
+	if tag == secretstream.TagFinal && !atEOF {
+		return ErrTrailing
+	}
+	if tag != secretstream.TagFinal && atEOF {
+		return ErrTruncated
+	}
+	if !atEOF {
+		r.buf[0] = r.buf[encChunkSize]
+		r.pending = true
+	}
+	r.done = atEOF
+	r.unread = plain
+	return nil
+}
@@ -0,0 +1,141 @@
+package sstream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/openziti/secretstream"
+)
+
+func encrypt(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd write size, so writes don't line up with chunks
+	for p := plain; len(p) > 0; {
+		n := min(len(p), 1000)
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 5} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		cipher := encrypt(t, key, plain)
+
+		readers := map[string]func(io.Reader) io.Reader{
+			"full":    func(r io.Reader) io.Reader { return r },
+			"onebyte": iotest.OneByteReader,
+			"half":    iotest.HalfReader,
+		}
+		for name, wrap := range readers {
+			r, err := NewReader(wrap(bytes.NewReader(cipher)), key)
+			if err != nil {
+				t.Fatalf("%d %s: %v", size, name, err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%d %s: %v", size, name, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("%d %s: decrypted data doesn't match", size, name)
+			}
+		}
+	}
+}
+
+// Files written by earlier versions of "file encrypt", which pushed each chunk
+// directly, must still decrypt.
+func TestOldFormat(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	plain := make([]byte, 2*ChunkSize+10)
+	rand.Read(plain)
+
+	enc, header, err := secretstream.NewEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher := bytes.NewBuffer(header)
+	for p := plain; len(p) > 0; {
+		n := min(len(p), ChunkSize)
+		tag := byte(secretstream.TagMessage)
+		if n == len(p) {
+			tag = secretstream.TagFinal
+		}
+		c, err := enc.Push(p[:n], tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cipher.Write(c)
+		p = p[n:]
+	}
+
+	r, err := NewReader(cipher, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data doesn't match")
+	}
+}
+
+func TestBadStreams(t *testing.T) {
+	key := make([]byte, KeySize)
+	rand.Read(key)
+	plain := make([]byte, 2*ChunkSize)
+	rand.Read(plain)
+	cipher := encrypt(t, key, plain)
+
+	readAll := func(b, k []byte) error {
+		r, err := NewReader(bytes.NewReader(b), k)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	// Cut after the first message
+	if err := readAll(cipher[:secretstream.StreamHeaderBytes+encChunkSize], key); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated stream: got %v", err)
+	}
+	if err := readAll(cipher[:10], key); !errors.Is(err, ErrTruncated) {
+		t.Errorf("truncated header: got %v", err)
+	}
+	if err := readAll(append(bytes.Clone(cipher), 0), key); !errors.Is(err, ErrTrailing) {
+		t.Errorf("trailing data: got %v", err)
+	}
+
+	wrongKey := make([]byte, KeySize)
+	if err := readAll(cipher, wrongKey); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	corrupt := bytes.Clone(cipher)
+	corrupt[len(corrupt)-1] ^= 1
+	if err := readAll(corrupt, key); err == nil {
+		t.Error("decrypted corrupt data")
+	}
+}
@@ -0,0 +1,232 @@
+package tree
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/starlinglab/integrity-v2/aa"
+)
+
+var (
+	depth     int
+	direction string
+	format    string
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	fs.IntVar(&depth, "depth", 5, "how many levels of relationships to follow, 0 for no limit")
+	fs.StringVar(&direction, "direction", "both", "relationships to follow: children, parents, or both")
+	fs.StringVar(&format, "format", "text", "output format: text, json, or dot (Graphviz)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("provide a single CID to work with")
+	}
+	if depth < 0 {
+		return fmt.Errorf("--depth can't be negative")
+	}
+	if direction != "children" && direction != "parents" && direction != "both" {
+		return fmt.Errorf("--direction must be children, parents, or both")
+	}
+	var render func(io.Writer, *Node) error
+	switch format {
+	case "text":
+		render = writeText
+	case "json":
+		render = writeJSON
+	case "dot":
+		render = writeDot
+	default:
+		return fmt.Errorf("--format must be text, json, or dot")
+	}
+
+	w := walker{get: aa.GetEffectiveRelationships, depth: depth}
+	root, err := w.walk(fs.Arg(0), direction)
+	if err != nil {
+		return err
+	}
+	return render(os.Stdout, root)
+}
+
+// Node is a CID in the relationship tree.
+type Node struct {
+	CID string `json:"cid"`
+	// Relation is the relation type that links this node to the one above it,
+	// like "encrypted" or "derived". Empty for the root.
+	Relation string `json:"relation,omitempty"`
+	// Cycle is set if this CID already appears above this node, so it wasn't followed.
+	Cycle    bool    `json:"cycle,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+	Parents  []*Node `json:"parents,omitempty"`
+}
+
+// walker resolves relationships recursively, retrieving each CID only once.
+type walker struct {
+	get   func(cid string) (*aa.Relationships, error)
+	depth int // 0 for no limit
+	rels  map[string]*aa.Relationships
+}
+
+func (w *walker) relationships(cid string) (*aa.Relationships, error) {
+	if w.rels == nil {
+		w.rels = make(map[string]*aa.Relationships)
+	}
+	if rels, ok := w.rels[cid]; ok {
+		return rels, nil
+	}
+	rels, err := w.get(cid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting relationships of %s: %w", cid, err)
+	}
+	w.rels[cid] = rels
+	return rels, nil
+}
+
+// walk builds the tree for the root CID. direction is "children", "parents", or
+// "both". Below the root, nodes are only followed in the same direction they were
+// reached by, otherwise every child would lead straight back to its parent.
+func (w *walker) walk(cid, direction string) (*Node, error) {
+	root := &Node{CID: cid}
+	path := []string{cid}
+	var err error
+	if direction == "children" || direction == "both" {
+		root.Children, err = w.expand(cid, "children", path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if direction == "parents" || direction == "both" {
+		root.Parents, err = w.expand(cid, "parents", path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// expand returns the related nodes of cid in the given direction, recursively.
+// path is the list of CIDs from the root down to cid, used to detect cycles.
+func (w *walker) expand(cid, relType string, path []string) ([]*Node, error) {
+	if w.depth > 0 && len(path) > w.depth {
+		return nil, nil
+	}
+	rels, err := w.relationships(cid)
+	if err != nil {
+		return nil, err
+	}
+	m := rels.Children
+	if relType == "parents" {
+		m = rels.Parents
+	}
+
+	relationTypes := make([]string, 0, len(m))
+	for t := range m {
+		relationTypes = append(relationTypes, t)
+	}
+	slices.Sort(relationTypes)
+
+	var nodes []*Node
+	for _, relation := range relationTypes {
+		for _, relCid := range m[relation] {
+			n := &Node{CID: relCid, Relation: relation}
+			nodes = append(nodes, n)
+			if slices.Contains(path, relCid) {
+				n.Cycle = true
+				continue
+			}
+			related, err := w.expand(relCid, relType, append(slices.Clip(path), relCid))
+			if err != nil {
+				return nil, err
+			}
+			if relType == "parents" {
+				n.Parents = related
+			} else {
+				n.Children = related
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// writeText writes the tree as indented text, with parents shown above the root
+// and children below it.
+func writeText(w io.Writer, root *Node) error {
+	var sb strings.Builder
+	if len(root.Parents) > 0 {
+		sb.WriteString("parents:\n")
+		writeTextNodes(&sb, root.Parents, "  ", true)
+	}
+	sb.WriteString(root.CID + "\n")
+	writeTextNodes(&sb, root.Children, "", false)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeTextNodes(sb *strings.Builder, nodes []*Node, prefix string, parents bool) {
+	for i, n := range nodes {
+		branch, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintf(sb, "%s%s%s: %s", prefix, branch, n.Relation, n.CID)
+		if n.Cycle {
+			sb.WriteString(" (cycle)")
+		}
+		sb.WriteString("\n")
+		next := n.Children
+		if parents {
+			next = n.Parents
+		}
+		writeTextNodes(sb, next, prefix+indent, parents)
+	}
+}
+
+func writeJSON(w io.Writer, root *Node) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(root)
+}
+
+// writeDot writes the tree as a Graphviz graph, with edges pointing from parent to
+// child. A CID that appears more than once in the tree is a single node in the graph.
+func writeDot(w io.Writer, root *Node) error {
+	var sb strings.Builder
+	sb.WriteString("digraph relationships {\n")
+	fmt.Fprintf(&sb, "  %q [style=bold];\n", root.CID)
+
+	seen := make(map[string]bool)
+	edge := func(parent, child, relation string) {
+		line := fmt.Sprintf("  %q -> %q [label=%q];\n", parent, child, relation)
+		if !seen[line] {
+			seen[line] = true
+			sb.WriteString(line)
+		}
+	}
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		for _, c := range n.Children {
+			edge(n.CID, c.CID, c.Relation)
+			visit(c)
+		}
+		for _, p := range n.Parents {
+			edge(p.CID, n.CID, p.Relation)
+			visit(p)
+		}
+	}
+	visit(root)
+
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,68 @@
+package tree
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+)
+
+const (
+	cidOrig = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cidEnc  = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+	cidC2pa = "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+)
+
+func TestWalk(t *testing.T) {
+	_, a := aatest.Start(t)
+	for _, rel := range [][3]string{
+		{cidOrig, "encrypted", cidEnc},
+		{cidOrig, "derived", cidC2pa},
+		// Cycle
+		{cidC2pa, "related", cidOrig},
+	} {
+		if err := a.AddRelationship(rel[0], "children", rel[1], rel[2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := walker{get: a.GetEffectiveRelationships}
+	root, err := w.walk(cidOrig, "children")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := writeText(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	want := cidOrig + `
+├── derived: ` + cidC2pa + `
+│   └── related: ` + cidOrig + ` (cycle)
+└── encrypted: ` + cidEnc + `
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	// Parents of the encrypted copy
+	w = walker{get: a.GetEffectiveRelationships, depth: 1}
+	root, err = w.walk(cidEnc, "both")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Children) != 0 || len(root.Parents) != 1 || root.Parents[0].CID != cidOrig {
+		t.Errorf("unexpected tree: %+v", root)
+	}
+	if len(root.Parents[0].Parents) != 0 {
+		t.Errorf("depth limit not applied: %+v", root.Parents[0])
+	}
+
+	buf.Reset()
+	if err := writeDot(&buf, root); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"`+cidOrig+`" -> "`+cidEnc+`" [label="encrypted"];`) {
+		t.Errorf("edge missing from DOT output:\n%s", buf.String())
+	}
+}
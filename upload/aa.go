@@ -13,17 +13,38 @@ type aaUpload struct {
 	ServiceType string `cbor:"service_type"`
 	Path        string `cbor:"path"`
 	Timestamp   string `cbor:"timestamp"` // RFC 3339
+	// Set when an encrypted copy was uploaded with --encrypt, on the plaintext and
+	// encrypted CID respectively
+	EncryptedCid string `cbor:"encrypted_cid,omitempty"`
+	PlaintextCid string `cbor:"plaintext_cid,omitempty"`
 }
 
-func logUploadWithAA(cid, serviceName, serviceType, path string) error {
-	err := aa.AppendAttestation(cid, "uploads", aaUpload{
+// logUploadWithAA logs the upload of cid in AA. plainCid is the CID cid was
+// encrypted from with --encrypt, or empty.
+func logUploadWithAA(cid, plainCid, serviceName, serviceType, path string) error {
+	err := logUpload(aa.GetAAInstanceFromConfig(), cid, plainCid, aaUpload{
 		ServiceName: serviceName,
 		ServiceType: serviceType,
 		Path:        path,
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
 	})
-	if err != nil {
+	if err == nil {
 		fmt.Println("Logged upload to AuthAttr under the attribute 'uploads'.")
 	}
 	return err
 }
+
+// logUpload appends the upload to the CID. If plainCid is set, cid is an encrypted
+// copy of it, and the upload is logged on both, each referencing the other.
+func logUpload(a *aa.AuthAttrInstance, cid, plainCid string, u aaUpload) error {
+	if plainCid == "" {
+		return a.AppendAttestation(cid, "uploads", u)
+	}
+	u.PlaintextCid = plainCid
+	if err := a.AppendAttestation(cid, "uploads", u); err != nil {
+		return err
+	}
+	u.PlaintextCid = ""
+	u.EncryptedCid = cid
+	return a.AppendAttestation(plainCid, "uploads", u)
+}
@@ -0,0 +1,66 @@
+package upload
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+)
+
+func getUploads(t *testing.T, a *aa.AuthAttrInstance, cid string) []aaUpload {
+	t.Helper()
+	data, err := a.GetAttestationRaw(cid, "uploads", aa.GetAttOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Attestation struct {
+			Value []aaUpload `cbor:"value"`
+		} `cbor:"attestation"`
+	}
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v.Attestation.Value
+}
+
+func TestLogUpload(t *testing.T) {
+	_, a := aatest.Start(t)
+	u := aaUpload{ServiceName: "drive", ServiceType: "drive", Path: "/foo", Timestamp: "2024-05-29T20:02:12Z"}
+
+	if err := logUpload(a, cid1, "", u); err != nil {
+		t.Fatal(err)
+	}
+	uploads := getUploads(t, a, cid1)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploads", len(uploads))
+	}
+	if uploads[0] != u {
+		t.Errorf("wrong upload entry: %+v", uploads[0])
+	}
+
+	// cid2 is the encrypted copy of cid1
+	if err := logUpload(a, cid2, cid1, u); err != nil {
+		t.Fatal(err)
+	}
+	uploads = getUploads(t, a, cid1)
+	if len(uploads) != 2 {
+		t.Fatalf("got %d uploads", len(uploads))
+	}
+	if got := uploads[1]; got.EncryptedCid != cid2 || got.PlaintextCid != "" || got.Path != "/foo" {
+		t.Errorf("wrong plaintext upload entry: %+v", got)
+	}
+	uploads = getUploads(t, a, cid2)
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploads", len(uploads))
+	}
+	if got := uploads[0]; got.PlaintextCid != cid1 || got.EncryptedCid != "" {
+		t.Errorf("wrong encrypted upload entry: %+v", got)
+	}
+}
@@ -40,7 +40,7 @@ func rcloneHasRemote(name string) (bool, string, error) {
 	return true, r.Type, nil
 }
 
-func uploadRclone(remote, remoteType, remotePath string, cidPaths []string) error {
+func uploadRclone(remote, remoteType, remotePath string, cidPaths []string, plainCids map[string]string) error {
 	for i, cidPath := range cidPaths {
 		fmt.Printf("Uploading %d of %d...\n", i+1, len(cidPaths))
 
@@ -72,7 +72,8 @@ func uploadRclone(remote, remoteType, remotePath string, cidPaths []string) erro
 			return fmt.Errorf("rclone failed, see output above if any. Error was: %w", err)
 		}
 
-		err = logUploadWithAA(filepath.Base(cidPath), remote, remoteType, remotePath)
+		cid := filepath.Base(cidPath)
+		err = logUploadWithAA(cid, plainCids[cid], remote, remoteType, remotePath)
 		if err != nil {
 			return fmt.Errorf("error logging upload to AuthAttr: %w", err)
 		}
@@ -0,0 +1,391 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/starlinglab/integrity-v2/config"
+)
+
+const (
+	s3DefaultRegion   = "us-east-1"
+	s3DefaultPartSize = 16 << 20 // 16 MiB
+	s3MinPartSize     = 5 << 20  // Smallest part S3 allows, except for the last one
+	s3MaxParts        = 10000
+)
+
+func uploadS3(name string, target config.S3Target, prefix string, cidPaths []string, plainCids map[string]string) error {
+	c, err := newS3Client(target)
+	if err != nil {
+		return fmt.Errorf("error in S3 config for %s: %w", name, err)
+	}
+
+	// Stop uploading if main Go process is cancelled, multipart uploads are aborted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	prefix = strings.Trim(prefix, "/")
+	for i, cidPath := range cidPaths {
+		fmt.Printf("Uploading %d of %d...\n", i+1, len(cidPaths))
+
+		cid := filepath.Base(cidPath)
+		if err := c.uploadFile(ctx, path.Join(prefix, cid), cidPath); err != nil {
+			return fmt.Errorf("error uploading to S3: %w", err)
+		}
+
+		err = logUploadWithAA(cid, plainCids[cid], name, "s3", path.Join(target.Bucket, prefix))
+		if err != nil {
+			return fmt.Errorf("error logging upload to AuthAttr: %w", err)
+		}
+	}
+
+	fmt.Println("Done.")
+	return nil
+}
+
+// s3Client uploads objects to an S3-compatible service, using AWS Signature Version 4.
+// Every request has its payload signed, and a Content-MD5 header, so the service
+// checks both hashes of what it received.
+type s3Client struct {
+	conf     config.S3Target
+	endpoint *url.URL
+	partSize int64
+	client   *http.Client
+	now      func() time.Time
+}
+
+func newS3Client(conf config.S3Target) (*s3Client, error) {
+	if conf.Endpoint == "" || conf.Bucket == "" {
+		return nil, fmt.Errorf("endpoint and bucket must be set")
+	}
+	if conf.AccessKeyID == "" || conf.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key must be set")
+	}
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint: %s", conf.Endpoint)
+	}
+	switch conf.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("unknown sse type: %s", conf.SSE)
+	}
+	if conf.SSEKMSKeyID != "" && conf.SSE != "aws:kms" {
+		return nil, fmt.Errorf("sse_kms_key_id requires sse = \"aws:kms\"")
+	}
+	if conf.Region == "" {
+		conf.Region = s3DefaultRegion
+	}
+	partSize := conf.PartSize
+	if partSize == 0 {
+		partSize = s3DefaultPartSize
+	}
+	if partSize < s3MinPartSize {
+		return nil, fmt.Errorf("part_size must be at least %d", s3MinPartSize)
+	}
+	return &s3Client{
+		conf:     conf,
+		endpoint: u,
+		partSize: partSize,
+		client:   &http.Client{},
+		now:      time.Now,
+	}, nil
+}
+
+// objectURL returns the URL for the object key in the bucket.
+func (c *s3Client) objectURL(key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	p := "/" + key
+	if c.conf.PathStyle {
+		p = "/" + c.conf.Bucket + p
+	} else {
+		u.Host = c.conf.Bucket + "." + u.Host
+	}
+	u.Path = path.Join(strings.TrimSuffix(u.Path, "/"), p)
+	u.RawPath = s3URIEncode(u.Path, true)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// s3Error is the XML body of S3 errors.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// do sends a signed request for the object key, and returns the response if it
+// succeeded. sse adds the server-side encryption headers, for requests that
+// create objects.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, body []byte, sse bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	md5sum := md5.Sum(body)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+	req.Header.Set("X-Amz-Content-Sha256", hexSHA256(body))
+	if sse && c.conf.SSE != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", c.conf.SSE)
+		if c.conf.SSEKMSKeyID != "" {
+			req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.conf.SSEKMSKeyID)
+		}
+	}
+	signV4(req, c.conf.AccessKeyID, c.conf.SecretAccessKey, c.conf.Region, c.now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		var e s3Error
+		if xml.Unmarshal(b, &e) == nil && e.Code != "" {
+			return nil, fmt.Errorf("S3 returned %s: %s: %s", resp.Status, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("S3 returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// uploadFile uploads the file at filePath to the object key, with a multipart
+// upload if it's larger than the part size.
+func (c *s3Client) uploadFile(ctx context.Context, key, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening CID file: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting CID file info: %w", err)
+	}
+
+	if fi.Size() <= c.partSize {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return fmt.Errorf("error reading CID file: %w", err)
+		}
+		return c.putObject(ctx, key, b)
+	}
+	// Parts must be larger for big files, to stay under the limit
+	partSize := max(c.partSize, (fi.Size()+s3MaxParts-1)/s3MaxParts)
+	return c.multipartUpload(ctx, key, f, partSize)
+}
+
+func (c *s3Client) putObject(ctx context.Context, key string, body []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, nil, body, true)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return c.checkETag(resp, body)
+}
+
+// checkETag makes sure the ETag S3 returned is the MD5 of the data, when it should be.
+// With KMS encryption it's something else.
+func (c *s3Client) checkETag(resp *http.Response, body []byte) error {
+	if c.conf.SSE == "aws:kms" {
+		return nil
+	}
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	md5sum := md5.Sum(body)
+	if etag != "" && etag != hex.EncodeToString(md5sum[:]) {
+		return fmt.Errorf("ETag from S3 doesn't match uploaded data: %s", etag)
+	}
+	return nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (c *s3Client) multipartUpload(ctx context.Context, key string, r io.Reader, partSize int64) (err error) {
+	resp, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, true)
+	if err != nil {
+		return fmt.Errorf("error starting multipart upload: %w", err)
+	}
+	var initResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initResult)
+	resp.Body.Close()
+	if err != nil || initResult.UploadID == "" {
+		return fmt.Errorf("error starting multipart upload: invalid response from S3")
+	}
+	uploadID := initResult.UploadID
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Don't leave parts behind to be billed for. Use a new context, in case the
+		// error is from cancelling.
+		abortCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		resp, abortErr := c.do(abortCtx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, false)
+		if abortErr != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to abort multipart upload %s: %v\n", uploadID, abortErr)
+			return
+		}
+		resp.Body.Close()
+	}()
+
+	var parts []s3CompletedPart
+	buf := make([]byte, partSize)
+	for n := 1; ; n++ {
+		m, readErr := io.ReadFull(r, buf)
+		if m == 0 && readErr == io.EOF {
+			break
+		}
+		if readErr != nil && readErr != io.ErrUnexpectedEOF {
+			return fmt.Errorf("error reading CID file: %w", readErr)
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		resp, err := c.do(ctx, http.MethodPut, key, query, buf[:m], false)
+		if err != nil {
+			return fmt.Errorf("error uploading part %d: %w", n, err)
+		}
+		resp.Body.Close()
+		if err := c.checkETag(resp, buf[:m]); err != nil {
+			return fmt.Errorf("error uploading part %d: %w", n, err)
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+		if readErr == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err = c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body, false)
+	if err != nil {
+		return fmt.Errorf("error completing multipart upload: %w", err)
+	}
+	defer resp.Body.Close()
+	// Completing can fail after the 200 status is sent, so check the body
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error completing multipart upload: %w", err)
+	}
+	var e s3Error
+	if xml.Unmarshal(b, &e) == nil {
+		return fmt.Errorf("error completing multipart upload: %s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+func hexSHA256(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3URIEncode percent-encodes everything but unreserved characters, as SigV4
+// requires. Slashes are kept for paths.
+func s3URIEncode(s string, isPath bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (isPath && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 sets the X-Amz-Date and Authorization headers of req, as described in
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+// X-Amz-Content-Sha256 must already be set. The host and every x-amz-*, content-md5,
+// content-type and range header are signed.
+func signV4(req *http.Request, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "content-md5" || k == "content-type" || k == "range" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	var params []string
+	query := req.URL.Query()
+	for _, k := range slices.Sorted(maps.Keys(query)) {
+		values := slices.Clone(query[k])
+		slices.Sort(values)
+		for _, v := range values {
+			params = append(params, s3URIEncode(k, false)+"="+s3URIEncode(v, false))
+		}
+	}
+	uri := req.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3URIEncode(uri, true),
+		strings.Join(params, "&"),
+		canonHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, sig,
+	))
+}
@@ -2,12 +2,22 @@ package upload
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/age"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/encrypt"
+	"github.com/starlinglab/integrity-v2/filestore"
+)
+
+var (
+	encryptFirst bool
+	recipients   []*age.Recipient
 )
 
 func Run(args []string) error {
@@ -17,39 +27,86 @@ func Run(args []string) error {
 The first one is the storage provider and path, and the second one is the CID
 to upload. You can provide multiple CIDs as well.
 
+With --encrypt, each CID is encrypted first like with "file encrypt", and the
+encrypted file is uploaded instead. The upload is logged on both CIDs. If a CID
+was already encrypted with a stored key, that encrypted copy is reused. Add
+--recipient <age public key> (repeatable) to encrypt for recipients instead of
+with a stored key. Flags go before the storage provider.
+
 Some examples:
 
 upload drive:dir/subdir bafy1... bafy2...
 upload web3:some-space bafy1... bafy2...
 upload dropbox:/ bafy1... bafy2...
 upload drive_for_work:/ bafy1... bafy2...
+upload --encrypt drive:dir bafy1... bafy2...
 
 upload supports any storage provider supported by rclone (https://rclone.org).
 It also supports the following:
 - web3.storage (web3)
+- S3-compatible storage, configured in the config file under [s3.<name>]
+  and used as <name>, without rclone
 
 web3.storage requires providing the "space" the file is uploaded to instead of
-a path.
+a path. For S3 the path is a prefix for the object keys in the bucket.
 
 For traditional storage providers, the path is always a directory.`)
 		return nil
 	}
+
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	fs.BoolVar(&encryptFirst, "encrypt", false, "encrypt each CID and upload the encrypted file instead")
+	fs.Func("recipient", "with --encrypt, age public key (age1...) to encrypt for instead of a stored key. Can be repeated", func(s string) error {
+		r, err := age.ParseRecipient(s)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, r)
+		return nil
+	})
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+	args = fs.Args()
+
 	if len(args) < 2 {
 		return fmt.Errorf("must provide a storage provider and CID(s), see --help")
 	}
+	if len(recipients) > 0 && !encryptFirst {
+		return fmt.Errorf("--recipient can only be used with --encrypt")
+	}
 
 	remote, path, ok := strings.Cut(args[0], ":")
 	if !ok {
 		return fmt.Errorf("proper storage provider syntax is <remote>:<path>")
 	}
 
-	cidPaths, err := getCidPaths(args[1:])
+	cids := args[1:]
+	// Maps encrypted CIDs to the plaintext CIDs they were made from, if --encrypt
+	var plainCids map[string]string
+	if encryptFirst {
+		conf := config.GetConfig()
+		store, err := filestore.FromConfig(conf)
+		if err != nil {
+			return err
+		}
+		cids, plainCids, err = encryptCids(aa.GetAAInstanceFromConfig(), store, conf.Dirs.EncKeys, cids)
+		if err != nil {
+			return err
+		}
+	}
+	cidPaths, err := getCidPaths(cids)
 	if err != nil {
 		return err
 	}
 
 	if remote == "web3" {
-		return uploadWeb3(path, cidPaths)
+		return uploadWeb3(path, cidPaths, plainCids)
+	}
+	if target, ok := config.GetConfig().S3[remote]; ok {
+		return uploadS3(remote, target, path, cidPaths, plainCids)
 	}
 	// To add another custom uploader please see "uploadRclone" in rclone.go
 	// as a basic example. "logUploadWithAA" must be used!
@@ -77,18 +134,75 @@ For traditional storage providers, the path is always a directory.`)
 		return fmt.Errorf("")
 	}
 
-	return uploadRclone(remote, remoteType, path, cidPaths)
+	return uploadRclone(remote, remoteType, path, cidPaths, plainCids)
 }
 
 func getCidPaths(cids []string) ([]string, error) {
+	store, err := filestore.FromConfig(config.GetConfig())
+	if err != nil {
+		return nil, err
+	}
 	cidPaths := make([]string, len(cids))
 	for i, cid := range cids {
-		cidPaths[i] = filepath.Join(config.GetConfig().Dirs.Files, cid)
 		// Confirm it actually exists
-		_, err := os.Stat(cidPaths[i])
+		_, err := store.Stat(cid)
 		if err != nil {
 			return nil, err
 		}
+		cidPaths[i] = store.Path(cid)
 	}
 	return cidPaths, nil
 }
+
+// encryptCids encrypts each CID, and returns the encrypted CIDs to upload instead,
+// along with a map from each encrypted CID to its plaintext CID, so uploads can be
+// logged on the plaintext CIDs too.
+//
+// Without recipients, an encrypted copy made earlier with a stored key is reused, so
+// uploading the same CID again doesn't upload a different file.
+func encryptCids(a *aa.AuthAttrInstance, store filestore.Store, keysDir string, cids []string) ([]string, map[string]string, error) {
+	encCids := make([]string, len(cids))
+	plainCids := make(map[string]string, len(cids))
+	for i, cid := range cids {
+		var encCid string
+		if len(recipients) == 0 {
+			var err error
+			encCid, err = findEncrypted(a, store, keysDir, cid)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error finding encrypted copy of %s: %w", cid, err)
+			}
+		}
+		if encCid != "" {
+			fmt.Printf("Using existing encrypted copy %d of %d: %s\n", i+1, len(cids), encCid)
+		} else {
+			fmt.Printf("Encrypting %d of %d...\n", i+1, len(cids))
+			var err error
+			encCid, err = encrypt.EncryptCid(cid, recipients)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error encrypting %s: %w", cid, err)
+			}
+		}
+		plainCids[encCid] = cid
+		encCids[i] = encCid
+	}
+	return encCids, plainCids, nil
+}
+
+// findEncrypted returns an encrypted child of the CID in AA that is in the file store
+// and has its key in keysDir, or an empty string if there isn't one.
+func findEncrypted(a *aa.AuthAttrInstance, store filestore.Store, keysDir, cid string) (string, error) {
+	rels, err := a.GetEffectiveRelationships(cid)
+	if err != nil {
+		return "", err
+	}
+	for _, encCid := range rels.Children["encrypted"] {
+		if _, err := os.Stat(filepath.Join(keysDir, encCid+".key")); err != nil {
+			continue
+		}
+		if _, err := store.Stat(encCid); err != nil {
+			continue
+		}
+		return encCid, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,72 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/filestore"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+func cidOf(b []byte) string {
+	h := util.NewCidHasher()
+	h.Write(b)
+	return h.CID()
+}
+
+func TestEncryptCidsReuse(t *testing.T) {
+	_, a := aatest.Start(t)
+	store, err := filestore.New(t.TempDir(), filestore.LayoutFlat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysDir := t.TempDir()
+
+	// An encrypted copy of cid1, stored like "file encrypt" does
+	cipher := make([]byte, 1000)
+	rand.Read(cipher)
+	encCid := cidOf(cipher)
+	if err := a.AddRelationship(cid1, "children", "encrypted", encCid); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findEncrypted(a, store, keysDir, cid1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "" {
+		t.Fatal("found encrypted copy that isn't in the file store")
+	}
+	if err := store.Put(encCid, bytes.NewReader(cipher)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := findEncrypted(a, store, keysDir, cid1); got != "" {
+		t.Fatal("found encrypted copy without a stored key")
+	}
+	if err := os.WriteFile(filepath.Join(keysDir, encCid+".key"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encCids, plainCids, err := encryptCids(a, store, keysDir, []string{cid1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encCids) != 1 || encCids[0] != encCid {
+		t.Fatalf("encrypted copy wasn't reused: %v", encCids)
+	}
+	if plainCids[encCid] != cid1 {
+		t.Errorf("wrong plaintext CID: %v", plainCids)
+	}
+
+	// Removed relationships don't count
+	if err := a.RemoveRelationship(cid1, "children", "encrypted", encCid); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := findEncrypted(a, store, keysDir, cid1); got != "" {
+		t.Fatal("found encrypted copy whose relationship was removed")
+	}
+}
@@ -13,7 +13,7 @@ import (
 	"github.com/starlinglab/integrity-v2/util"
 )
 
-func uploadWeb3(space string, cidPaths []string) error {
+func uploadWeb3(space string, cidPaths []string, plainCids map[string]string) error {
 	conf := config.GetConfig()
 
 	if conf.Bins.W3 == "" {
@@ -98,7 +98,8 @@ func uploadWeb3(space string, cidPaths []string) error {
 				return fmt.Errorf("w3 (w3cli) failed to upload, see output above if any. Error was: %w", err)
 			}
 
-			err = logUploadWithAA(filepath.Base(cidPath), "web3", "web3.storage", space)
+			cid := filepath.Base(cidPath)
+			err = logUploadWithAA(cid, plainCids[cid], "web3", "web3.storage", space)
 			if err != nil {
 				return fmt.Errorf("error logging upload to AuthAttr: %w", err)
 			}
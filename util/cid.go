@@ -3,6 +3,7 @@ package util
 import (
 	"crypto/sha256"
 	"encoding/base32"
+	"hash"
 	"io"
 )
 
@@ -16,11 +17,30 @@ var (
 // CalculateFileCid gets the CIDv1 for the given data, using raw SHA-256.
 // It does not load the whole file into memory.
 func CalculateFileCid(fileReader io.Reader) (string, error) {
-	hasher := sha256.New()
+	hasher := NewCidHasher()
 	_, err := io.Copy(hasher, fileReader)
 	if err != nil {
 		return "", err
 	}
+	return hasher.CID(), nil
+}
+
+// CidHasher calculates the same CID as CalculateFileCid for the data written to it,
+// so it can be done while the data is used for something else.
+type CidHasher struct {
+	h hash.Hash
+}
+
+func NewCidHasher() *CidHasher {
+	return &CidHasher{h: sha256.New()}
+}
+
+func (c *CidHasher) Write(p []byte) (int, error) {
+	return c.h.Write(p)
+}
+
+// CID returns the CID of the data written so far.
+func (c *CidHasher) CID() string {
 	// The bytes are (in order) CID version, raw multicodec, sha2-256 multihash, 32 byte length hash
-	return "b" + multibaseBase32.EncodeToString(append([]byte{0x01, 0x55, 0x12, 0x20}, hasher.Sum(nil)...)), nil
+	return "b" + multibaseBase32.EncodeToString(append([]byte{0x01, 0x55, 0x12, 0x20}, c.h.Sum(nil)...))
 }
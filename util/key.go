@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bytes"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -8,49 +9,211 @@ import (
 	"path/filepath"
 
 	"github.com/starlinglab/integrity-v2/config"
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
 const (
 	secretboxKeySize = 32
+	nonceSize        = 24
 )
 
+// sealedKeyMagic starts key files that are sealed with the master key. Key files
+// without it are the raw 32 byte key.
+var sealedKeyMagic = []byte("SLWK1")
+
 // GenerateEncKey generates a new encryption key for the given CID and attribute
 // and stores it in a file. If the key already exists, it is read from the file.
+//
+// If a master key is configured, the key file is sealed with it.
 func GenerateEncKey(cid, attr string) (encKeyPath string, encKeyBytes []byte, isNew bool, err error) {
-	conf := config.GetConfig()
+	encKeyPath, err = EncKeyPath(cid, attr)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	encKeyBytes = make([]byte, secretboxKeySize)
+	_, err = rand.Read(encKeyBytes)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("error generating random bytes: %w", err)
+	}
+	err = writeKeyFile(encKeyPath, encKeyBytes, false)
+	if errors.Is(err, os.ErrExist) {
+		encKeyBytes, err := ReadKeyFile(encKeyPath)
+		if err != nil {
+			return "", nil, false, err
+		}
+		return encKeyPath, encKeyBytes, false, nil
+	}
+	if err != nil {
+		return "", nil, false, err
+	}
+	return encKeyPath, encKeyBytes, true, nil
+}
+
+// ReadEncKey returns the stored encryption key for the given CID and attribute.
+// Unlike GenerateEncKey, it never creates a new key: nil is returned if there isn't one.
+func ReadEncKey(cid, attr string) ([]byte, error) {
+	path, err := EncKeyPath(cid, attr)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ReadKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return key, err
+}
 
-	if conf.Dirs.EncKeys == "" {
-		return "", nil, false, fmt.Errorf("enc_keys path is not configured")
+// EncKeyPath returns the path of the key file for the given CID and attribute.
+func EncKeyPath(cid, attr string) (string, error) {
+	dir := config.GetConfig().Dirs.EncKeys
+	if dir == "" {
+		return "", fmt.Errorf("enc_keys path is not configured, are you on the server?")
 	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.key", cid, attr)), nil
+}
 
-	encKeyPath = filepath.Join(conf.Dirs.EncKeys, fmt.Sprintf("%s_%s.key", cid, attr))
-	f, err := os.OpenFile(encKeyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+// ReadKeyFile reads a 32 byte key from a file, which can be raw or sealed with the
+// master key.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			encKeyBytes, err := os.ReadFile(encKeyPath)
-			if err != nil {
-				return "", nil, false, fmt.Errorf("error reading key file: %w", err)
-			}
-			if len(encKeyBytes) != secretboxKeySize {
-				return "", nil, false, fmt.Errorf("key file is not the correct size")
-			}
-			return encKeyPath, encKeyBytes, false, nil
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+	if !IsSealedKey(data) {
+		if len(data) != secretboxKeySize {
+			return nil, fmt.Errorf("key file %s is not the correct size", path)
 		}
-		return "", nil, false, fmt.Errorf("error creating key file: %w", err)
+		return data, nil
 	}
-	defer f.Close()
-	encKeyBytes = make([]byte, secretboxKeySize)
-	_, err = rand.Read(encKeyBytes)
+	master, err := MasterKey()
 	if err != nil {
-		return "", nil, false, fmt.Errorf("error generating random bytes: %w", err)
+		return nil, err
+	}
+	if master == nil {
+		return nil, fmt.Errorf("key file %s is sealed, but master_key is not configured", path)
 	}
+	key, err := OpenKey(master, data)
+	if err != nil {
+		return nil, fmt.Errorf("error opening key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// WriteKeyFile writes a key to a file, replacing it if it exists. If a master key
+// is configured, the key is sealed with it. The file is replaced atomically, so the
+// old key is left in place if anything fails.
+func WriteKeyFile(path string, key []byte) error {
+	return writeKeyFile(path, key, true)
+}
 
-	_, err = f.Write(encKeyBytes)
+// writeKeyFile writes the key to a temp file next to path and moves it into place.
+// If replace is false and path exists, an error wrapping os.ErrExist is returned.
+func writeKeyFile(path string, key []byte, replace bool) error {
+	if len(key) != secretboxKeySize {
+		return fmt.Errorf("key is not the correct size")
+	}
+	master, err := MasterKey()
 	if err != nil {
-		// Cleanup
-		f.Close()
-		os.Remove(encKeyPath)
-		return "", nil, false, fmt.Errorf("error writing key file: %w", err)
+		return err
 	}
-	return encKeyPath, encKeyBytes, true, nil
+	data := key
+	if master != nil {
+		data, err = SealKey(master, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".key_*")
+	if err != nil {
+		return fmt.Errorf("error creating key file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	defer tmp.Close()
+
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("error writing key file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("error syncing key file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing key file: %w", err)
+	}
+
+	if replace {
+		err = os.Rename(tmp.Name(), path)
+	} else {
+		// Unlike rename, a hard link fails if the key already exists
+		err = os.Link(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("error moving key file into place: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes directory entries to disk, so a new key file survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory: %w", err)
+	}
+	return nil
+}
+
+// MasterKey returns the key from the master_key file in the config, or nil if it's
+// not configured.
+func MasterKey() ([]byte, error) {
+	path := config.GetConfig().Keys.MasterKey
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading master key: %w", err)
+	}
+	if len(key) != secretboxKeySize {
+		return nil, fmt.Errorf("master key file is not the correct size")
+	}
+	return key, nil
+}
+
+// IsSealedKey returns true if the key file data was sealed with SealKey.
+func IsSealedKey(data []byte) bool {
+	return bytes.HasPrefix(data, sealedKeyMagic)
+}
+
+// SealKey encrypts a key with the master key, using secretbox.
+func SealKey(master, key []byte) ([]byte, error) {
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("error generating random bytes: %w", err)
+	}
+	out := append(bytes.Clone(sealedKeyMagic), nonce[:]...)
+	return secretbox.Seal(out, key, &nonce, (*[secretboxKeySize]byte)(master)), nil
+}
+
+// OpenKey decrypts a key sealed with SealKey.
+func OpenKey(master, data []byte) ([]byte, error) {
+	if !IsSealedKey(data) || len(data) < len(sealedKeyMagic)+nonceSize {
+		return nil, fmt.Errorf("not a sealed key")
+	}
+	data = data[len(sealedKeyMagic):]
+	var nonce [nonceSize]byte
+	copy(nonce[:], data)
+	key, ok := secretbox.Open(nil, data[nonceSize:], &nonce, (*[secretboxKeySize]byte)(master))
+	if !ok {
+		return nil, fmt.Errorf("wrong master key, or the key file is corrupted")
+	}
+	if len(key) != secretboxKeySize {
+		return nil, fmt.Errorf("sealed key is not the correct size")
+	}
+	return key, nil
 }
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	car "github.com/photon-storage/go-ipfs-car"
 )
@@ -16,7 +18,8 @@ import (
 // MoveFile moves the provided file, even if source and dest are part of different file systems.
 //
 // It is not atomic. os.Rename should be used in favour of this function if it can be guaranteed
-// that source and dest are on the same filesystem, or atomicity is required.
+// that source and dest are on the same filesystem, or atomicity is required. To move files into
+// the file store, use filestore.Store.PutFile, which is atomic and verifies the CID.
 func MoveFile(sourcePath, destPath string) error {
 	// Adapted from https://stackoverflow.com/a/50741908
 
@@ -112,3 +115,30 @@ func FileExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// ReadCIDList reads CIDs from a file with one per line, or from stdin if path is "-".
+// Blank lines and lines starting with # are ignored.
+func ReadCIDList(path string) ([]string, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+
+	var cids []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cids = append(cids, line)
+	}
+	return cids, scanner.Err()
+}
@@ -0,0 +1,154 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/export"
+)
+
+// Names used for the results of checking the bundle's relationships and VCs, which
+// can't clash with a real attribute.
+const (
+	relationshipsAttr = "(relationships)"
+	vcsAttr           = "(credentials)"
+)
+
+// verifyBundleFile reads and verifies a bundle. If cid isn't empty, the bundle must
+// be for that CID.
+func verifyBundleFile(path, cid string, keys []*aa.TrustedKey) ([]*result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading bundle: %w", err)
+	}
+	var b export.Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("error decoding bundle: %w", err)
+	}
+	if cid != "" && b.CID != cid {
+		return nil, fmt.Errorf("bundle is for %s, not %s", b.CID, cid)
+	}
+	return verifyBundle(&b, keys), nil
+}
+
+// verifyBundle verifies every attestation in the bundle, in alphabetical order,
+// and checks the listed relationships and VCs match them.
+func verifyBundle(b *export.Bundle, keys []*aa.TrustedKey) []*result {
+	attrs := make([]string, 0, len(b.Attestations)+len(b.Encrypted))
+	for attr := range b.Attestations {
+		attrs = append(attrs, attr)
+	}
+	attrs = append(attrs, b.Encrypted...)
+	slices.Sort(attrs)
+
+	var results []*result
+	verified := make(map[string]*aa.AttEntry)
+	for _, attr := range attrs {
+		raw, ok := b.Attestations[attr]
+		if !ok {
+			results = append(results, &result{attr, statusSkip, "encrypted, not included in bundle"})
+			continue
+		}
+		var ae aa.AttEntry
+		if err := cbor.Unmarshal(raw, &ae); err != nil {
+			results = append(results, &result{attr, statusFail, fmt.Sprintf("error decoding attestation: %v", err)})
+			continue
+		}
+		// The signature only proves the attestation is genuine, it must also be the
+		// one the bundle claims it is
+		if len(ae.Attestation.CID) < 2 || ae.Attestation.CID.String() != b.CID {
+			results = append(results, &result{attr, statusFail, "attestation is for a different CID"})
+			continue
+		}
+		if ae.Attestation.Attribute != attr {
+			results = append(results, &result{attr, statusFail,
+				fmt.Sprintf("attestation is for attribute %q", ae.Attestation.Attribute)})
+			continue
+		}
+		r := check(attr, &ae, keys)
+		if r.status == statusPass {
+			verified[attr] = &ae
+		}
+		results = append(results, r)
+	}
+
+	rels, err := aa.RelationshipsFromAttestations(verified)
+	switch {
+	case err != nil:
+		results = append(results, &result{relationshipsAttr, statusFail, err.Error()})
+	case b.Relationships == nil || !sameRelationships(rels, b.Relationships):
+		results = append(results, &result{relationshipsAttr, statusFail, "don't match the verified attestations"})
+	default:
+		results = append(results, &result{relationshipsAttr, statusPass, "match the verified attestations"})
+	}
+	return append(results, checkVCs(b, verified)...)
+}
+
+// checkVCs checks each VC in the bundle states the same as the verified attestation
+// of its attribute. VCs of attributes left encrypted can't be checked, only that
+// they state an encrypted value.
+func checkVCs(b *export.Bundle, verified map[string]*aa.AttEntry) []*result {
+	var results []*result
+	fail := func(format string, a ...any) {
+		results = append(results, &result{vcsAttr, statusFail, fmt.Sprintf(format, a...)})
+	}
+	seen := make(map[string]bool)
+	encrypted := 0
+	for i, vc := range b.Credentials {
+		_, attr, value, err := aa.VCSubject(vc)
+		if err != nil {
+			fail("VC %d: %v", i+1, err)
+			continue
+		}
+		if seen[attr] {
+			fail("more than one VC for %s", attr)
+			continue
+		}
+		seen[attr] = true
+		ae, ok := verified[attr]
+		switch {
+		case ok:
+			if err := aa.CheckVC(vc, ae); err != nil {
+				fail("%s: %v", attr, err)
+			}
+		case slices.Contains(b.Encrypted, attr):
+			// The bundle's list of encrypted attributes isn't signed, so it can't
+			// be used to skip checking a plaintext VC
+			if !aa.VCValueEncrypted(value) {
+				fail("%s: listed as encrypted, but the VC value isn't", attr)
+				continue
+			}
+			encrypted++
+		default:
+			fail("%s: no verified attestation to check VC against", attr)
+		}
+	}
+	if len(results) > 0 {
+		return results
+	}
+	results = append(results, &result{vcsAttr, statusPass, "match the verified attestations"})
+	if encrypted > 0 {
+		results = append(results, &result{vcsAttr, statusSkip, fmt.Sprintf("%d VCs of encrypted attributes can't be checked, they are NOT verified", encrypted)})
+	}
+	return results
+}
+
+// sameRelationships compares relationships, treating nil and empty the same.
+func sameRelationships(a, b *aa.Relationships) bool {
+	norm := func(m map[string][]string) map[string][]string {
+		out := make(map[string][]string)
+		for k, v := range m {
+			if len(v) > 0 {
+				out[k] = v
+			}
+		}
+		return out
+	}
+	return reflect.DeepEqual(norm(a.Children), norm(b.Children)) &&
+		reflect.DeepEqual(norm(a.Parents), norm(b.Parents))
+}
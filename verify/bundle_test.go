@@ -0,0 +1,126 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/aa/aatest"
+	"github.com/starlinglab/integrity-v2/export"
+)
+
+const (
+	cid1 = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	cid2 = "bafkreidogqfzz75tpkmjzjke425xqcrmpcib2p5tg44hnbirumdbpl5adu"
+)
+
+func TestBundle(t *testing.T) {
+	s, a := aatest.Start(t)
+	keys := []*aa.TrustedKey{s.TrustedKey()}
+	key := make([]byte, 32)
+	err := a.SetAttestations(cid1, false, []aa.PostKV{
+		{Key: "name", Value: "hello"},
+		{Key: "secret", Value: "shh", EncKey: key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddRelationship(cid1, "children", "derived", cid2); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetAttestations(cid2, false, []aa.PostKV{{Key: "name", Value: "other"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	noKeys := func(cid, attr string) ([]byte, error) { return nil, nil }
+	b, err := export.MakeBundle(a, cid1, noKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round trip like a file
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = new(export.Bundle)
+	if err := json.Unmarshal(data, b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Credentials) != 3 || len(b.Attestations) != 2 {
+		t.Errorf("got %d VCs and %d attestations, want 3 and 2", len(b.Credentials), len(b.Attestations))
+	}
+
+	want := []string{
+		"PASS  children  signed by aatest",
+		"PASS  name  signed by aatest",
+		"SKIP  secret  encrypted, not included in bundle",
+		"PASS  (relationships)  match the verified attestations",
+		"PASS  (credentials)  match the verified attestations",
+		"SKIP  (credentials)  1 VCs of encrypted attributes can't be checked, they are NOT verified",
+	}
+	checkResults(t, verifyBundle(b, keys), want)
+
+	// Plaintext VC listed as encrypted, to skip checking it
+	edited := *b
+	edited.Attestations = map[string][]byte{"children": b.Attestations["children"]}
+	edited.Encrypted = append([]string{"name"}, b.Encrypted...)
+	results := verifyBundle(&edited, keys)
+	if r := results[len(results)-1].String(); r != "FAIL  (credentials)  name: listed as encrypted, but the VC value isn't" {
+		t.Errorf("plaintext VC listed as encrypted: got %s", r)
+	}
+
+	// Edited VC
+	for i, vc := range b.Credentials {
+		if _, attr, _, _ := aa.VCSubject(vc); attr == "name" {
+			b.Credentials[i] = bytes.Replace(vc, []byte(`"hello"`), []byte(`"goodbye"`), 1)
+		}
+	}
+	want[4] = "FAIL  (credentials)  name: VC does not match attestation: value is different"
+	checkResults(t, verifyBundle(b, keys), want[:5])
+
+	// Invented VC
+	b.Credentials = append(b.Credentials, json.RawMessage(`{"credentialSubject":{"id":"ipfs://`+cid1+`","author":"someone"}}`))
+	results = verifyBundle(b, keys)
+	if r := results[len(results)-1].String(); r != "FAIL  (credentials)  author: no verified attestation to check VC against" {
+		t.Errorf("invented VC: got %s", r)
+	}
+
+	// Decrypted
+	b, err = export.MakeBundle(a, cid1, func(cid, attr string) ([]byte, error) { return key, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = verifyBundle(b, keys)
+	if results[2].String() != "PASS  secret  signed by aatest" {
+		t.Errorf("decrypted attestation should verify, got %s", results[2])
+	}
+
+	// Attestation of another CID swapped in
+	other, err := export.MakeBundle(a, cid2, noKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Attestations["name"] = other.Attestations["name"]
+	b.Relationships.Children["derived"] = []string{cid1}
+	want = []string{
+		"PASS  children  signed by aatest",
+		"FAIL  name  attestation is for a different CID",
+		"PASS  secret  signed by aatest",
+		"FAIL  (relationships)  don't match the verified attestations",
+		"FAIL  (credentials)  name: no verified attestation to check VC against",
+	}
+	checkResults(t, verifyBundle(b, keys), want)
+}
+
+func checkResults(t *testing.T, results []*result, want []string) {
+	t.Helper()
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %v", len(results), len(want), results)
+	}
+	for i, r := range results {
+		if r.String() != want[i] {
+			t.Errorf("got %q, want %q", r, want[i])
+		}
+	}
+}
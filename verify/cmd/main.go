@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os"
+
+	"github.com/starlinglab/integrity-v2/util"
+	"github.com/starlinglab/integrity-v2/verify"
+)
+
+func main() {
+	util.Runner(os.Args[1:], verify.Run)
+}
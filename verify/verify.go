@@ -0,0 +1,196 @@
+package verify
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/starlinglab/integrity-v2/aa"
+	"github.com/starlinglab/integrity-v2/util"
+)
+
+var (
+	attr        string
+	getAll      bool
+	isEncrypted bool
+	encKeyPath  string
+	keysPath    string
+	bundlePath  string
+)
+
+func Run(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.StringVar(&attr, "attr", "", "name of attribute to verify")
+	fs.BoolVar(&getAll, "all", false, "verify all attributes instead of just one")
+	fs.BoolVar(&isEncrypted, "encrypted", false, "attribute is encrypted, find key automatically")
+	fs.StringVar(&encKeyPath, "key", "", "(optional) manual path to encryption key file, implies --encrypted")
+	fs.StringVar(&bundlePath, "bundle", "", "verify a bundle from \"export --all\" instead of getting attestations from AA")
+	fs.StringVar(&keysPath, "trusted-keys", "", "(optional) path to trusted keys file, instead of the one in the config")
+
+	err := fs.Parse(args)
+	if err != nil {
+		// Error is already printed
+		os.Exit(1)
+	}
+
+	// Validate flags
+	if bundlePath != "" {
+		if attr != "" || getAll || isEncrypted || encKeyPath != "" {
+			return fmt.Errorf("--bundle can't be used with other attribute flags")
+		}
+		if fs.NArg() > 1 {
+			return fmt.Errorf("provide at most one CID, to check the bundle is for it")
+		}
+	} else if attr == "" && !getAll {
+		fs.PrintDefaults()
+		return fmt.Errorf("\nprovide attribute name with --attr, or use --all")
+	}
+	if attr != "" && getAll {
+		return fmt.Errorf("can't use --attr and --all together")
+	}
+	if getAll && encKeyPath != "" {
+		return fmt.Errorf("can't use --all and --key together")
+	}
+	if bundlePath == "" && fs.NArg() != 1 {
+		return fmt.Errorf("provide a single CID to work with")
+	}
+	cid := fs.Arg(0)
+
+	var keys []*aa.TrustedKey
+	if keysPath != "" {
+		keys, err = aa.LoadTrustedKeys(keysPath)
+	} else {
+		keys, err = aa.TrustedKeysFromConfig()
+	}
+	if err != nil {
+		return fmt.Errorf("error loading trusted keys: %w", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no trusted keys found, nothing can be verified")
+	}
+
+	var results []*result
+	if bundlePath != "" {
+		results, err = verifyBundleFile(bundlePath, cid, keys)
+		if err != nil {
+			return err
+		}
+	} else if getAll {
+		results, err = verifyAll(cid, keys)
+		if err != nil {
+			return err
+		}
+	} else {
+		var encKey []byte
+		if encKeyPath != "" {
+			encKey, err = util.ReadKeyFile(encKeyPath)
+			if err != nil {
+				return fmt.Errorf("error reading key: %w", err)
+			}
+		} else if isEncrypted {
+			encKey, err = util.ReadEncKey(cid, attr)
+			if err != nil {
+				return fmt.Errorf("error reading key: %w", err)
+			}
+			if encKey == nil {
+				return fmt.Errorf("no key found for this attribute in the key store")
+			}
+		}
+		results = []*result{verifyOne(cid, attr, encKey, keys)}
+	}
+
+	failed, skipped := 0, 0
+	for _, r := range results {
+		fmt.Println(r)
+		switch r.status {
+		case statusFail:
+			failed++
+		case statusSkip:
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		fmt.Printf("\nWARNING: %d of %d check(s) were skipped, those items are NOT verified\n", skipped, len(results))
+	}
+	if failed > 0 {
+		return fmt.Errorf("\n%d of %d attestation(s) failed verification", failed, len(results))
+	}
+	return nil
+}
+
+const (
+	statusPass = "PASS"
+	statusFail = "FAIL"
+	statusSkip = "SKIP"
+)
+
+// result is the outcome of verifying a single attestation.
+type result struct {
+	attr   string
+	status string
+	detail string
+}
+
+func (r *result) String() string {
+	return fmt.Sprintf("%s  %s  %s", r.status, r.attr, r.detail)
+}
+
+// check verifies an attestation that has already been retrieved.
+func check(attr string, ae *aa.AttEntry, keys []*aa.TrustedKey) *result {
+	key, err := aa.VerifyAttEntry(ae, keys)
+	if err != nil {
+		return &result{attr, statusFail, err.Error()}
+	}
+	return &result{attr, statusPass, "signed by " + key.Name}
+}
+
+// verifyOne gets a single attestation from AA and verifies it. encKey can be nil for
+// unencrypted attributes.
+func verifyOne(cid, attr string, encKey []byte, keys []*aa.TrustedKey) *result {
+	ae, err := aa.GetAttestation(cid, attr, aa.GetAttOpts{EncKey: encKey})
+	if errors.Is(err, aa.ErrNeedsKey) {
+		return &result{attr, statusSkip, "encrypted, use --encrypted or --key"}
+	}
+	if err != nil {
+		return &result{attr, statusFail, fmt.Sprintf("error getting attestation: %v", err)}
+	}
+	return check(attr, ae, keys)
+}
+
+// verifyAll verifies every attestation of the CID, in alphabetical order.
+// Encrypted attestations are re-requested with their key from the key store
+// if one exists, and skipped otherwise.
+func verifyAll(cid string, keys []*aa.TrustedKey) ([]*result, error) {
+	atts, err := aa.GetAttestations(cid)
+	if err != nil {
+		return nil, fmt.Errorf("error getting attestations: %w", err)
+	}
+
+	attNames := make([]string, 0, len(atts))
+	for name := range atts {
+		attNames = append(attNames, name)
+	}
+	slices.Sort(attNames)
+
+	results := make([]*result, len(attNames))
+	for i, name := range attNames {
+		ae := atts[name]
+		if !ae.Attestation.Encrypted {
+			results[i] = check(name, ae, keys)
+			continue
+		}
+		encKey, err := util.ReadEncKey(cid, name)
+		if err != nil {
+			results[i] = &result{name, statusFail, fmt.Sprintf("error reading key: %v", err)}
+			continue
+		}
+		if encKey == nil {
+			results[i] = &result{name, statusSkip, "encrypted, no key in key store"}
+			continue
+		}
+		results[i] = verifyOne(cid, name, encKey, keys)
+	}
+	return results, nil
+}
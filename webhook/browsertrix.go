@@ -8,7 +8,6 @@ import (
 	"net/http"
 	urlpkg "net/url"
 	"os"
-	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -231,10 +230,15 @@ func handleBrowsertrixEvent(w http.ResponseWriter, r *http.Request, conf *config
 
 	// Check if file is already stored before storing it again
 	// Convert SHA-256 hash from Browsertrix to CID, then check the disk
+	store, err := getFileStore()
+	if err != nil {
+		writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
 	alreadyDownloaded := false
-	matches, _ := aa.IndexMatchQuery("sha256", crawlInfo.Resources[0].Hash, "str")
+	matches, _ := aa.IndexMatchQueryCtx(r.Context(), "sha256", crawlInfo.Resources[0].Hash, "str")
 	if len(matches) > 0 {
-		_, err = os.Stat(filepath.Join(conf.Dirs.Files, matches[0]))
+		_, err = store.Stat(matches[0])
 		if err == nil {
 			alreadyDownloaded = true
 		}
@@ -255,12 +259,6 @@ func handleBrowsertrixEvent(w http.ResponseWriter, r *http.Request, conf *config
 			return
 		}
 
-		outputDirectory, err := getFileOutputDirectory()
-		if err != nil {
-			writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
-			return
-		}
-
 		tempFile, err := os.CreateTemp(util.TempDir(), "browsertrix-webhook_")
 		if err != nil {
 			log.Printf("browsertrix: failed to create temp file: %s", err.Error())
@@ -307,7 +305,7 @@ func handleBrowsertrixEvent(w http.ResponseWriter, r *http.Request, conf *config
 			metadataMap["crawl_qa_rating"] = e.ReviewStatusLabel
 		}
 
-		err = util.MoveFile(tempFilePath, filepath.Join(outputDirectory, cid))
+		err = store.PutFile(cid, tempFilePath)
 		if err != nil {
 			writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 			return
@@ -319,10 +317,10 @@ func handleBrowsertrixEvent(w http.ResponseWriter, r *http.Request, conf *config
 			writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 			return
 		}
-		err = aa.SetAttestations(cid, true, attributes)
+		err = aa.SetAttestationsCtx(r.Context(), cid, true, attributes)
 		if err != nil {
 			log.Println("browsertrix: error setting attestations:", err)
-			writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeJsonResponse(w, aaErrorStatus(err), map[string]string{"error": err.Error()})
 			return
 		}
 
@@ -336,10 +334,10 @@ func handleBrowsertrixEvent(w http.ResponseWriter, r *http.Request, conf *config
 			{Key: "crawl_qa_rating", Value: e.ReviewStatusLabel},
 			{Key: "crawl_description", Value: crawlInfo.Description},
 		}
-		err = aa.SetAttestations(cid, true, attrs)
+		err = aa.SetAttestationsCtx(r.Context(), cid, true, attrs)
 		if err != nil {
 			log.Println("browsertrix: error setting attestations:", err)
-			writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeJsonResponse(w, aaErrorStatus(err), map[string]string{"error": err.Error()})
 			return
 		}
 		log.Printf("browsertrix: processed QA info for WACZ without re-downloading: %s", cid)
@@ -11,6 +11,7 @@ import (
 	"os"
 
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
 	"github.com/starlinglab/integrity-v2/nectar"
 	"github.com/starlinglab/integrity-v2/util"
 	"lukechampine.com/blake3"
@@ -89,17 +90,17 @@ func computeImagePFP(ctx context.Context, conf *config.Config, path string) (pfp
 	return pfp, true, nil
 }
 
-// Check if the output directory is set and exists
-func getFileOutputDirectory() (string, error) {
-	outputDirectory := config.GetConfig().Dirs.Files
-	if outputDirectory == "" {
+// Check if the output directory is set and exists, and return the file store for it
+func getFileStore() (filestore.Store, error) {
+	conf := config.GetConfig()
+	if conf.Dirs.Files == "" {
 		log.Println("error: output directory not set")
-		return "", fmt.Errorf("output directory not set")
+		return nil, fmt.Errorf("output directory not set")
 	}
-	_, err := os.Stat(outputDirectory)
+	_, err := os.Stat(conf.Dirs.Files)
 	if err != nil {
 		log.Println("error: output directory not set")
-		return "", err
+		return nil, err
 	}
-	return outputDirectory, nil
+	return filestore.FromConfig(conf)
 }
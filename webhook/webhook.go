@@ -1,20 +1,23 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"reflect"
+	"time"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/starlinglab/integrity-v2/aa"
 	"github.com/starlinglab/integrity-v2/config"
+	"github.com/starlinglab/integrity-v2/filestore"
 	"github.com/starlinglab/integrity-v2/util"
 )
 
@@ -45,6 +48,20 @@ func writeJsonResponse(w http.ResponseWriter, httpStatus int, data any) {
 	}
 }
 
+// aaErrorStatus returns the HTTP status to respond with when a request to AA failed.
+// Failures on AA's side are reported as gateway errors so they aren't mistaken for
+// problems with the request sent to the webhook.
+func aaErrorStatus(err error) int {
+	var apiErr *aa.APIError
+	if errors.As(err, &apiErr) {
+		return http.StatusBadGateway
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 // Handle ping request
 func handlePing(w http.ResponseWriter, r *http.Request) {
 	writeJsonResponse(w, http.StatusOK, map[string]string{"message": "pong"})
@@ -53,7 +70,7 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 // Handle quest to get all attributes for a CID
 func handleGetCid(w http.ResponseWriter, r *http.Request) { //nolint:unused
 	cid := chi.URLParam(r, "cid")
-	v, err := aa.GetAttestations(cid)
+	v, err := aa.GetAttestationsCtx(r.Context(), cid)
 	if err != nil {
 		writeJsonResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
@@ -65,7 +82,7 @@ func handleGetCid(w http.ResponseWriter, r *http.Request) { //nolint:unused
 func handleGetCidAttribute(w http.ResponseWriter, r *http.Request) { //nolint:unused
 	cid := chi.URLParam(r, "cid")
 	attr := chi.URLParam(r, "attr")
-	v, err := aa.GetAttestation(cid, attr, aa.GetAttOpts{
+	v, err := aa.GetAttestationCtx(r.Context(), cid, attr, aa.GetAttOpts{
 		EncKey:         nil,
 		LeaveEncrypted: false,
 		Format:         "",
@@ -86,7 +103,7 @@ func handleGenericFileUpload(w http.ResponseWriter, r *http.Request, conf *confi
 		return
 	}
 
-	outputDirectory, err := getFileOutputDirectory()
+	store, err := getFileStore()
 	if err != nil {
 		writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
@@ -141,7 +158,7 @@ func handleGenericFileUpload(w http.ResponseWriter, r *http.Request, conf *confi
 		writeJsonResponse(w, http.StatusBadRequest, map[string]string{"error": "No file provided"})
 		return
 	}
-	err = util.MoveFile(tempFile.Name(), filepath.Join(outputDirectory, cid))
+	err = store.PutFile(cid, tempFile.Name())
 	if err != nil {
 		writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
@@ -153,10 +170,10 @@ func handleGenericFileUpload(w http.ResponseWriter, r *http.Request, conf *confi
 		writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
 	}
-	err = aa.SetAttestations(cid, true, attributes)
+	err = aa.SetAttestationsCtx(r.Context(), cid, true, attributes)
 	if err != nil {
 		log.Println("error setting attestations:", err)
-		writeJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		writeJsonResponse(w, aaErrorStatus(err), map[string]string{"error": err.Error()})
 		return
 	}
 
@@ -173,6 +190,17 @@ func Run(args []string) error {
 
 	conf := config.GetConfig()
 
+	// Clean up after any writes interrupted by a crash. Recent files are left
+	// alone, since other tools may be writing to the store right now.
+	if store, err := filestore.FromConfig(conf); err == nil {
+		n, err := store.Sweep(time.Hour)
+		if err != nil {
+			log.Println("error sweeping file store:", err)
+		} else if n > 0 {
+			log.Printf("removed %d partially written file(s) from file store\n", n)
+		}
+	}
+
 	r := chi.NewRouter()
 	r.Get("/ping", handlePing)
 	// r.Get("/c/{cid}", handleGetCid)